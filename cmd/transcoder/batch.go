@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/batch"
+	"github.com/gleicon/transcoder/pkg/translation"
+)
+
+func runBatch(ctx context.Context, args []string) error {
+	fs := newFlagSet("batch", "-output-dir <dir> [flags] <dir|glob|file>...",
+		"Transcribe or translate many files with a worker pool. The directory layout of the\n"+
+			"inputs is mirrored under the output directory and a JSON report is written there.")
+	outputDir := stringFlag(fs, "output-dir", "o", "Directory receiving the subtitle tree")
+	manifest := fs.String("manifest", "", "File listing inputs (directories, globs or files), one per line")
+	workers := fs.Int("workers", 2, "Number of files processed concurrently")
+	mode := fs.String("mode", "transcribe", "Operation to run on each file: transcribe or translate")
+	lang := fs.String("lang", "", "Source language for transcribe (default auto), target language for translate (en only)")
+	format := fs.String("format", "srt", "Subtitle format to write: srt or vtt")
+	reportPath := fs.String("report", "", "Report file (default <output-dir>/batch-report.json)")
+	skipExisting := fs.Bool("skip-existing", false, "Skip inputs whose output already exists")
+	var wopts whisperOptions
+	wopts.register(fs)
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"output-dir": *outputDir}); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 && *manifest == "" {
+		fs.Usage()
+		return usagef("no inputs given")
+	}
+	if *workers < 1 {
+		return usagef("workers must be at least 1")
+	}
+	if *format != "srt" && *format != "vtt" {
+		return usagef("unknown subtitle format: %s", *format)
+	}
+
+	config, err := wopts.config()
+	if err != nil {
+		return err
+	}
+
+	process, err := subtitleOperation(*mode, *lang, &config)
+	if err != nil {
+		return err
+	}
+
+	items, err := batch.Collect(fs.Args(), *manifest)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("no media files found")
+	}
+
+	// One translator is validated up front and shared by every worker
+	translator, err := translation.NewWithConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create translator: %w", err)
+	}
+	defer translator.Close()
+	if err := wopts.setup(ctx, translator); err != nil {
+		return err
+	}
+
+	report := batch.Run(ctx, items, *workers, func(ctx context.Context, item batch.Item) (string, error) {
+		output := item.OutputPath(*outputDir, "."+*format)
+		if *skipExisting {
+			if _, err := os.Stat(output); err == nil {
+				return output, batch.ErrSkipped
+			}
+		}
+		err := writeSubtitles(output, func(srt string) error {
+			return process(ctx, translator, item.Input, srt)
+		})
+		return output, err
+	})
+
+	if *reportPath == "" {
+		*reportPath = filepath.Join(*outputDir, "batch-report.json")
+	}
+	if err := report.WriteJSON(*reportPath); err != nil {
+		return err
+	}
+
+	rep := reportFrom(ctx)
+	rep.result(report)
+	for _, r := range report.Results {
+		rep.input(r.Input)
+		switch {
+		case r.Error != "":
+			fmt.Fprintf(os.Stderr, "FAILED %s: %s\n", r.Input, r.Error)
+			rep.warn("%s failed: %s", r.Input, r.Error)
+		case !r.Skipped:
+			rep.output(r.Output)
+		}
+	}
+	rep.output(*reportPath)
+	fmt.Printf("%d files in %s: %d succeeded, %d skipped, %d failed (report: %s)\n",
+		report.Total, report.Duration.Round(time.Millisecond), report.Succeeded, report.Skipped, report.Failed, *reportPath)
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d files failed", report.Failed, report.Total)
+	}
+	return nil
+}
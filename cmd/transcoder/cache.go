@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gleicon/transcoder/pkg/cache"
+)
+
+var cacheCommands = []command{
+	{name: "prune", summary: "Evict cache entries by size limit or age", run: runCachePrune},
+	{name: "stats", summary: "Show the number and total size of cache entries", run: runCacheStats},
+}
+
+func runCache(ctx context.Context, args []string) error {
+	return dispatch(ctx, "transcoder cache", cacheCommands, args)
+}
+
+func runCachePrune(ctx context.Context, args []string) error {
+	fs := newFlagSet("cache prune", "[flags]",
+		"Remove least recently used cache entries until the cache fits in -max-mb,\n"+
+			"and entries not used for longer than -older-than.")
+	dir := fs.String("dir", cache.DefaultDir(), "Cache directory")
+	maxMB := fs.Int64("max-mb", cache.DefaultMaxSize>>20, "Size to shrink the cache to in MiB")
+	olderThan := fs.Duration("older-than", 0, "Also remove entries unused for this long, e.g. 720h")
+	all := fs.Bool("all", false, "Remove every entry")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *maxMB < 0 || *olderThan < 0 {
+		return usagef("max-mb and older-than must not be negative")
+	}
+
+	c, err := cache.Open(*dir, -1)
+	if err != nil {
+		return err
+	}
+	limit := *maxMB << 20
+	if *all {
+		limit = 0
+	}
+	removed, err := c.Prune(limit, *olderThan)
+	if err != nil {
+		return err
+	}
+	left, err := c.Stats()
+	if err != nil {
+		return err
+	}
+	reportFrom(ctx).result(map[string]cache.Stats{"removed": removed, "remaining": left})
+	fmt.Printf("Removed %d entries (%s), %d entries (%s) remain\n",
+		removed.Entries, formatSize(removed.Size), left.Entries, formatSize(left.Size))
+	return nil
+}
+
+func runCacheStats(ctx context.Context, args []string) error {
+	fs := newFlagSet("cache stats", "[flags]", "Show the number and total size of cache entries.")
+	dir := fs.String("dir", cache.DefaultDir(), "Cache directory")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	c, err := cache.Open(*dir, -1)
+	if err != nil {
+		return err
+	}
+	stats, err := c.Stats()
+	if err != nil {
+		return err
+	}
+	reportFrom(ctx).result(stats)
+	fmt.Printf("%s: %d entries, %s\n", c.Dir(), stats.Entries, formatSize(stats.Size))
+	return nil
+}
+
+// formatSize formats a byte count in MiB
+func formatSize(n int64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/cache"
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/subtitle"
+	"github.com/gleicon/transcoder/pkg/translation"
+	"github.com/gleicon/transcoder/pkg/whisper"
+	"github.com/gleicon/transcoder/pkg/workspace"
+)
+
+// newFlagSet creates the flag set of a subcommand with a usage message
+func newFlagSet(name, args, summary string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.BoolVar(&jsonOutput, "json", jsonOutput, "Print a single JSON result on stdout instead of text")
+	fs.TextVar(logLevel, "log-level", logLevel, "Minimum level of log messages: debug, info, warn or error; debug includes the output of ffmpeg and whisper-cli")
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: transcoder %s %s\n\n%s\n", name, args, summary)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(out, "\nFlags:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// parseFlags parses args, turning flag errors into usage errors. Positional
+// arguments are rejected.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return usagef("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return nil
+}
+
+// parseArgs parses args, turning flag errors into usage errors, and leaves
+// positional arguments in fs.Args()
+func parseArgs(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{msg: err.Error()}
+	}
+	if jsonOutput {
+		reserveStdout()
+	}
+	return nil
+}
+
+// stringFlag registers a string flag under a long and an optional short name
+func stringFlag(fs *flag.FlagSet, name, short, usage string) *string {
+	p := new(string)
+	fs.StringVar(p, name, "", usage)
+	if short != "" {
+		fs.StringVar(p, short, "", "Shorthand for -"+name)
+	}
+	return p
+}
+
+// require returns a usage error naming the first empty flag
+func require(fs *flag.FlagSet, flags map[string]string) error {
+	var missing []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := flags[f.Name]; ok && v == "" {
+			missing = append(missing, "-"+f.Name)
+		}
+	})
+	if len(missing) > 0 {
+		fs.Usage()
+		return usagef("missing required flags: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// requireInput checks that an input file exists
+func requireInput(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return &notFoundError{path: path}
+		}
+		return fmt.Errorf("error checking input file: %w", err)
+	}
+	return nil
+}
+
+// notFoundError reports a missing input file
+type notFoundError struct {
+	path string
+}
+
+func (e *notFoundError) Error() string {
+	return "input file not found: " + e.path
+}
+
+// Is makes the error match fs.ErrNotExist
+func (e *notFoundError) Is(target error) bool {
+	return target == fs.ErrNotExist
+}
+
+// whisperOptions holds the flags shared by commands that run whisper
+type whisperOptions struct {
+	model     string
+	modelsDir string
+	prefer    string
+	threads   int
+
+	cacheDir   string
+	cacheMaxMB int64
+	noCache    bool
+
+	workDir   string
+	keepWork  bool
+	minFreeMB int64
+
+	timeouts     string
+	retries      int
+	retryBackoff time.Duration
+
+	captions string
+	filter   bool
+}
+
+// register adds the whisper flags to fs
+func (o *whisperOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.model, "model", "", "Whisper model file, or \"auto\" to pick one by language and hardware")
+	fs.StringVar(&o.modelsDir, "models-dir", "", "Directory with ggml-*.bin models used by -model auto")
+	fs.StringVar(&o.prefer, "prefer", string(whisper.PreferBalanced), "Model selection preference for -model auto: speed, balanced or quality")
+	fs.IntVar(&o.threads, "threads", 0, "Number of whisper threads, 0 keeps the default of 4")
+	fs.StringVar(&o.cacheDir, "cache-dir", cache.DefaultDir(), "Directory caching extracted audio and transcripts")
+	fs.Int64Var(&o.cacheMaxMB, "cache-max-mb", cache.DefaultMaxSize>>20, "Cache size limit in MiB; least recently used entries are evicted")
+	fs.BoolVar(&o.noCache, "no-cache", false, "Do not read or fill the cache")
+	fs.StringVar(&o.workDir, "work-dir", "", "Directory for intermediate files, default the system temporary directory")
+	fs.BoolVar(&o.keepWork, "keep-work", false, "Keep the intermediate files of failed jobs for debugging")
+	fs.Int64Var(&o.minFreeMB, "min-free-mb", 0, "Disk space in MiB to leave free besides what a job needs")
+	fs.StringVar(&o.timeouts, "timeouts", "", "Per-operation timeouts overriding the defaults ("+policy.Default().String()+"), e.g. transcribe=10m+6x; Nx scales with the media duration")
+	fs.IntVar(&o.retries, "retries", policy.Default().Retry.Attempts-1, "Number of times an operation failing for a transient reason is retried")
+	fs.DurationVar(&o.retryBackoff, "retry-backoff", policy.Default().Retry.Backoff, "Delay before the first retry, doubled for each following one")
+	fs.StringVar(&o.captions, "captions", "", "Re-segment transcripts for readability: \"broadcast\" ("+subtitle.DefaultStyle().String()+"), optionally with overrides such as broadcast,cps=15")
+	fs.BoolVar(&o.filter, "filter", false, "Remove text whisper made up: loops of repeated text, boilerplate such as \"Thank you for watching\", cues over silence and low-probability cues")
+}
+
+// limits builds the timeout and retry policy from the flags. Retries are
+// logged and recorded as warnings in the report of ctx.
+func (o *whisperOptions) limits(ctx context.Context) (*policy.Policy, error) {
+	p := policy.Default()
+	if err := policy.ParseTimeouts(o.timeouts, p.Timeouts); err != nil {
+		return nil, usagef("%v", err)
+	}
+	if o.retries < 0 {
+		return nil, usagef("retries must not be negative")
+	}
+	if o.retryBackoff < 0 {
+		return nil, usagef("retry-backoff must not be negative")
+	}
+	p.Retry.Attempts = o.retries + 1
+	p.Retry.Backoff = o.retryBackoff
+	p.OnRetry = func(op policy.Operation, attempt int, err error, wait time.Duration) {
+		slog.Warn("retrying failed operation", "op", string(op), "attempt", attempt, "attempts", o.retries+1, "wait", wait, "error", err)
+		reportFrom(ctx).warn("%s attempt %d failed and was retried: %v", op, attempt, err)
+	}
+	return p, nil
+}
+
+// workspace builds the workspace configuration from the flags
+func (o *whisperOptions) workspace() workspace.Config {
+	return workspace.Config{Root: o.workDir, MinFree: o.minFreeMB << 20, KeepOnFailure: o.keepWork}
+}
+
+// setup attaches the cache and workspace selected by the flags to t
+func (o *whisperOptions) setup(ctx context.Context, t *translation.Translator) error {
+	if o.minFreeMB < 0 {
+		return usagef("min-free-mb must not be negative")
+	}
+	t.SetWorkspace(o.workspace())
+	limits, err := o.limits(ctx)
+	if err != nil {
+		return err
+	}
+	t.SetPolicy(limits)
+	if o.captions != "" {
+		style, err := subtitle.ParseStyle(o.captions)
+		if err != nil {
+			return usagef("%v", err)
+		}
+		t.SetCaptions(&style)
+	}
+	if o.filter {
+		t.SetFilter(&translation.FilterOptions{Silence: true})
+	}
+	if o.noCache {
+		return nil
+	}
+	if o.cacheMaxMB <= 0 {
+		return usagef("cache-max-mb must be positive")
+	}
+	c, err := cache.Open(o.cacheDir, o.cacheMaxMB<<20)
+	if err != nil {
+		return err
+	}
+	t.SetCache(c)
+	return nil
+}
+
+// config builds a whisper configuration from the flags
+func (o *whisperOptions) config() (whisper.Config, error) {
+	config := whisper.DefaultConfig()
+	if o.modelsDir != "" {
+		config.ModelDir = o.modelsDir
+	}
+	switch o.model {
+	case "":
+	case whisper.AutoModel:
+		config.Model = whisper.AutoModel
+	default:
+		config.ModelPath = o.model
+	}
+	switch p := whisper.Preference(o.prefer); p {
+	case whisper.PreferSpeed, whisper.PreferBalanced, whisper.PreferQuality:
+		config.Preference = p
+	default:
+		return config, usagef("unknown model preference: %s", o.prefer)
+	}
+	if o.threads < 0 {
+		return config, usagef("threads must not be negative")
+	}
+	if o.threads > 0 {
+		config.Threads = o.threads
+	}
+	return config, nil
+}
+
+// styleOptions holds the flags styling subtitles rendered as ASS
+type styleOptions struct {
+	style    string
+	speakers stringList
+}
+
+// register adds the style flags to fs
+func (o *styleOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.style, "style", "default", "ASS style: a preset ("+strings.Join(subtitle.ASSPresets(), ", ")+"), optionally with overrides such as top,font=Verdana,size=60,color=#ffdd00")
+	fs.Var(&o.speakers, "speaker-style", "Style of one speaker's cues as <speaker>:<overrides>, e.g. Ana:color=#80c0ff; repeatable")
+}
+
+// options builds the ASS styles from the flags
+func (o *styleOptions) options() (subtitle.ASSOptions, error) {
+	base, err := subtitle.ParseASSStyle(o.style, subtitle.DefaultASSStyle())
+	if err != nil {
+		return subtitle.ASSOptions{}, usagef("%v", err)
+	}
+	opts := subtitle.ASSOptions{Styles: []subtitle.ASSStyle{base}}
+	for _, s := range o.speakers {
+		name, spec, ok := strings.Cut(s, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.Contains(name, ",") {
+			return subtitle.ASSOptions{}, usagef("invalid speaker style %q, want <speaker>:<overrides>", s)
+		}
+		style, err := subtitle.ParseASSStyle(spec, base)
+		if err != nil {
+			return subtitle.ASSOptions{}, usagef("speaker %s: %v", name, err)
+		}
+		style.Name = name
+		opts.Styles = append(opts.Styles, style)
+	}
+	return opts, nil
+}
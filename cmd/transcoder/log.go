@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+)
+
+var (
+	// logLevel is set by -log-level, given before the command or among its flags
+	logLevel = new(slog.LevelVar)
+	// logFormat is set by -log-format, given before the command
+	logFormat = "text"
+)
+
+// newLogger returns the logger for the log messages of the commands and the
+// tools they run, written to w in logFormat
+func newLogger(w io.Writer) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	switch logFormat {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, usagef("invalid log format %q, want text or json", logFormat)
+}
+
+// globalFlags consumes the flags given before the command and returns the
+// remaining arguments
+func globalFlags(args []string) ([]string, error) {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[0], "-"), "=")
+		switch name {
+		case "json":
+			if hasValue {
+				return nil, usagef("flag -json takes no value")
+			}
+			jsonOutput = true
+		case "log-level", "log-format":
+			if !hasValue {
+				if len(args) < 2 {
+					return nil, usagef("flag needs an argument: -%s", name)
+				}
+				value = args[1]
+				args = args[1:]
+			}
+			if name == "log-format" {
+				logFormat = value
+			} else if err := logLevel.UnmarshalText([]byte(value)); err != nil {
+				return nil, usagef("invalid value %q for flag -log-level: %v", value, err)
+			}
+		default:
+			// -h and friends are handled by dispatch
+			return args, nil
+		}
+		args = args[1:]
+	}
+	return args, nil
+}
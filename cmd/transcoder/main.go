@@ -2,109 +2,160 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
+)
 
-	"github.com/gleicon/transcoder/pkg/translation"
+// Exit codes returned by the CLI
+const (
+	exitOK        = 0
+	exitFailure   = 1
+	exitUsage     = 2
+	exitCancelled = 130 // 128 + SIGINT, as shells report an interrupted command
 )
 
-func isVideoFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	return ext == ".mp4" || ext == ".avi" || ext == ".mkv" || ext == ".mov"
+// command is a transcoder subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
 }
 
-func isAudioFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	return ext == ".mp3" || ext == ".wav" || ext == ".ogg" || ext == ".flac"
+// usageError reports invalid command line arguments
+type usageError struct {
+	msg string
 }
 
-func processVideo(ctx context.Context, translator *translation.Translator, input, output, targetLang string, speed float64) error {
-	if speed <= 0 {
-		return fmt.Errorf("speed must be greater than 0")
-	}
-
-	// Extract audio from video
-	audioFile := filepath.Join(filepath.Dir(output), filepath.Base(input)+".wav")
-	if err := translator.FFmpegProcessor().ExtractAudio(ctx, input, audioFile); err != nil {
-		return fmt.Errorf("failed to extract audio: %w", err)
-	}
-
-	// Translate audio
-	translatedAudioFile := filepath.Join(filepath.Dir(output), filepath.Base(input)+".translated.wav")
-	if err := translator.Translate(ctx, audioFile, translatedAudioFile, targetLang); err != nil {
-		return fmt.Errorf("failed to translate audio: %w", err)
-	}
-
-	// Change video speed
-	if err := translator.FFmpegProcessor().ChangeSpeed(ctx, input, output, speed); err != nil {
-		return fmt.Errorf("failed to change video speed: %w", err)
-	}
+func (e *usageError) Error() string {
+	return e.msg
+}
 
-	return nil
+// usagef returns a usageError with a formatted message
+func usagef(format string, args ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
 }
 
-func processAudio(ctx context.Context, translator *translation.Translator, input, output, targetLang string) error {
-	if targetLang == "" {
-		return fmt.Errorf("target language is required")
-	}
+var commands = []command{
+	{name: "transcribe", summary: "Transcribe speech in an audio or video file to subtitles", run: runTranscribe},
+	{name: "translate", summary: "Transcribe and translate speech to subtitles", run: runTranslate},
+	{name: "extract-audio", summary: "Extract a 16kHz mono WAV track from a media file", run: runExtractAudio},
+	{name: "speed", summary: "Change the playback speed of a video", run: runSpeed},
+	{name: "probe", summary: "Show the format, duration and streams of a media file", run: runProbe},
+	{name: "burn", summary: "Render subtitles into the video frames", run: runBurn},
+	{name: "mux", summary: "Add subtitle tracks to a video container", run: runMux},
+	{name: "subtitle", summary: "Convert and retime subtitle files", run: runSubtitle},
+	{name: "batch", summary: "Process directories, globs or a manifest of files", run: runBatch},
+	{name: "watch", summary: "Write subtitles for files dropped into a folder", run: runWatch},
+	{name: "serve", summary: "Run the HTTP job API", run: runServe},
+	{name: "run", summary: "Run the steps of a YAML or JSON pipeline file", run: runPipeline},
+	{name: "cache", summary: "Inspect and prune the audio and transcript cache", run: runCache},
+}
 
-	// Translate audio
-	if err := translator.Translate(ctx, input, output, targetLang); err != nil {
-		return fmt.Errorf("failed to transcribe and translate audio: %w", err)
+// findCommand returns the command with the given name
+func findCommand(cmds []command, name string) (command, bool) {
+	for _, c := range cmds {
+		if c.name == name {
+			return c, true
+		}
 	}
-
-	return nil
+	return command{}, false
 }
 
-func main() {
-	input := flag.String("input", "", "Input file path")
-	output := flag.String("output", "", "Output file path")
-	targetLang := flag.String("lang", "", "Target language for translation")
-	speed := flag.Float64("speed", 1.0, "Speed factor for video (default: 1.0)")
-	flag.Parse()
-
-	if *input == "" || *output == "" {
-		log.Fatal("Input and output file paths are required")
+// printCommands writes the usage summary of a command group
+func printCommands(w io.Writer, prefix string, cmds []command) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", prefix)
+	sorted := make([]command, len(cmds))
+	copy(sorted, cmds)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+	for _, c := range sorted {
+		fmt.Fprintf(w, "  %-14s %s\n", c.name, c.summary)
 	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", prefix)
+}
 
-	if *speed <= 0 {
-		log.Fatal("Speed must be greater than 0")
+// dispatch runs the subcommand named by args[0]
+func dispatch(ctx context.Context, prefix string, cmds []command, args []string) error {
+	if len(args) == 0 {
+		printCommands(os.Stderr, prefix, cmds)
+		return usagef("missing command")
 	}
 
-	if _, err := os.Stat(*input); os.IsNotExist(err) {
-		log.Fatal("Input file not found")
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		printCommands(os.Stdout, prefix, cmds)
+		return nil
 	}
 
-	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(filepath.Dir(*output), 0755); err != nil {
-		log.Fatalf("Failed to create output directory: %v", err)
+	c, ok := findCommand(cmds, args[0])
+	if !ok {
+		printCommands(os.Stderr, prefix, cmds)
+		return usagef("unknown command %q", args[0])
 	}
+	reportFrom(ctx).Command = strings.TrimPrefix(prefix+" "+c.name, "transcoder ")
+	return c.run(ctx, args[1:])
+}
 
-	// Create translator
-	translator, err := translation.NewWithCmd()
-	if err != nil {
-		log.Fatalf("Failed to create translator: %v", err)
+// exitCode maps a command error to the process exit code. A command that
+// fails after ctx was cancelled by a signal was interrupted, whatever error
+// the interruption surfaced as.
+func exitCode(ctx context.Context, err error) int {
+	var usage *usageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usage):
+		return exitUsage
+	case ctx.Err() != nil, errors.Is(err, context.Canceled):
+		return exitCancelled
+	default:
+		return exitFailure
 	}
-	defer translator.Close()
-
-	ctx := context.Background()
+}
 
-	// Process file based on type
-	if isVideoFile(*input) {
-		if err := processVideo(ctx, translator, *input, *output, *targetLang, *speed); err != nil {
-			log.Fatal(err)
-		}
-	} else if isAudioFile(*input) {
-		if err := processAudio(ctx, translator, *input, *output, *targetLang); err != nil {
-			log.Fatal(err)
+func main() {
+	// SIGINT and SIGTERM cancel the context: running ffmpeg and whisper-cli
+	// processes are stopped and partial outputs discarded. A second signal
+	// kills the process right away.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	start := time.Now()
+	rep := &report{}
+	args, err := globalFlags(os.Args[1:])
+	if err == nil {
+		var logger *slog.Logger
+		if logger, err = newLogger(os.Stderr); err == nil {
+			slog.SetDefault(logger)
 		}
-	} else {
-		log.Fatal("Unsupported file type")
 	}
-
-	fmt.Println("Processing completed successfully!")
+	if jsonOutput {
+		reserveStdout()
+	}
+	if err == nil {
+		err = dispatch(withReport(ctx, rep), "transcoder", commands, args)
+	}
+	code := exitCode(ctx, err)
+	switch {
+	case jsonOutput && !errors.Is(err, flag.ErrHelp):
+		rep.finish(ctx, code, err, time.Since(start))
+		rep.write(jsonStdout)
+	case code == exitCancelled:
+		fmt.Fprintln(os.Stderr, "transcoder: interrupted")
+	case err != nil && code != exitOK:
+		fmt.Fprintf(os.Stderr, "transcoder: %v\n", err)
+	}
+	stop()
+	os.Exit(code)
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gleicon/transcoder/pkg/ffmpeg"
+)
+
+// newFFmpeg creates an FFmpeg processor for the media commands
+func newFFmpeg() (*ffmpeg.FFmpeg, error) {
+	f, err := ffmpeg.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create FFmpeg processor: %w", err)
+	}
+	return f, nil
+}
+
+func runExtractAudio(ctx context.Context, args []string) error {
+	fs := newFlagSet("extract-audio", "-input <media> -output <audio.wav>",
+		"Extract the audio of a media file as 16kHz mono PCM, the format whisper expects.")
+	input := stringFlag(fs, "input", "i", "Input audio or video file")
+	output := stringFlag(fs, "output", "o", "Output WAV file")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "output": *output}); err != nil {
+		return err
+	}
+
+	f, err := newFFmpeg()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return reportMedia(ctx, []string{*input}, *output, f.ExtractAudio(ctx, *input, *output))
+}
+
+// reportMedia records the inputs and, if err is nil, the output of a media
+// command in the report of ctx, and returns err
+func reportMedia(ctx context.Context, inputs []string, output string, err error) error {
+	rep := reportFrom(ctx)
+	rep.input(inputs...)
+	if err == nil {
+		rep.output(output)
+	}
+	return err
+}
+
+func runSpeed(ctx context.Context, args []string) error {
+	fs := newFlagSet("speed", "-input <video> -output <video> -speed <factor>",
+		"Change the playback speed of a video.")
+	input := stringFlag(fs, "input", "i", "Input video file")
+	output := stringFlag(fs, "output", "o", "Output video file")
+	speed := fs.Float64("speed", 1.0, "Speed factor, e.g. 1.5 for 50% faster")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "output": *output}); err != nil {
+		return err
+	}
+	if *speed <= 0 {
+		return usagef("speed must be greater than 0")
+	}
+
+	f, err := newFFmpeg()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return reportMedia(ctx, []string{*input}, *output, f.ChangeSpeed(ctx, *input, *output, *speed))
+}
+
+func runProbe(ctx context.Context, args []string) error {
+	fs := newFlagSet("probe", "-input <media> [-json]",
+		"Show the container format, duration and streams of a media file.")
+	input := stringFlag(fs, "input", "i", "Input audio or video file")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input}); err != nil {
+		return err
+	}
+
+	f, err := newFFmpeg()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rep := reportFrom(ctx)
+	rep.input(*input)
+	result, err := f.Probe(ctx, *input)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		rep.media("", result.Duration)
+		rep.result(result)
+		return nil
+	}
+
+	fmt.Printf("Format:   %s\n", result.Format)
+	fmt.Printf("Duration: %s\n", result.Duration)
+	for _, s := range result.Streams {
+		details := []string{s.CodecName}
+		switch s.CodecType {
+		case "video":
+			details = append(details, fmt.Sprintf("%dx%d", s.Width, s.Height))
+		case "audio":
+			details = append(details, fmt.Sprintf("%d Hz", s.SampleRate), fmt.Sprintf("%d channels", s.Channels))
+		}
+		if s.Language != "" {
+			details = append(details, s.Language)
+		}
+		fmt.Printf("Stream #%d: %s: %s\n", s.Index, s.CodecType, strings.Join(details, ", "))
+	}
+	return nil
+}
+
+func runBurn(ctx context.Context, args []string) error {
+	fs := newFlagSet("burn", "-input <video> -subtitles <file.srt> -output <video> [-style <style>]",
+		"Render a subtitle file into the video frames. Subtitles are converted to ASS with -style\n"+
+			"so they look the same with any ffmpeg build; .ass files are rendered with their own styles.")
+	input := stringFlag(fs, "input", "i", "Input video file")
+	subtitles := stringFlag(fs, "subtitles", "s", "Subtitle file to burn in")
+	output := stringFlag(fs, "output", "o", "Output video file")
+	var sopts styleOptions
+	sopts.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "subtitles": *subtitles, "output": *output}); err != nil {
+		return err
+	}
+	style, err := sopts.options()
+	if err != nil {
+		return err
+	}
+
+	f, err := newFFmpeg()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return reportMedia(ctx, []string{*input, *subtitles}, *output, f.BurnSubtitlesWith(ctx, *input, *subtitles, *output, style))
+}
+
+// subtitleStreams collects repeated -sub flags of the form [lang=]path
+type subtitleStreams []ffmpeg.SubtitleStream
+
+func (s *subtitleStreams) String() string {
+	var parts []string
+	for _, stream := range *s {
+		parts = append(parts, stream.Path)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *subtitleStreams) Set(value string) error {
+	stream := ffmpeg.SubtitleStream{Path: value}
+	if lang, path, ok := strings.Cut(value, "="); ok {
+		stream = ffmpeg.SubtitleStream{Path: path, Language: lang}
+	}
+	if stream.Path == "" {
+		return fmt.Errorf("empty subtitle path")
+	}
+	*s = append(*s, stream)
+	return nil
+}
+
+func runMux(ctx context.Context, args []string) error {
+	fs := newFlagSet("mux", "-input <video> -sub [lang=]<file.srt> [-sub ...] -output <video>",
+		"Add subtitle tracks to a video container without re-encoding it.")
+	input := stringFlag(fs, "input", "i", "Input video file")
+	output := stringFlag(fs, "output", "o", "Output video file (.mkv, .mp4, .mov or .webm)")
+	var subs subtitleStreams
+	fs.Var(&subs, "sub", "Subtitle file to add, optionally prefixed with its language (e.g. por=talk.pt.srt); repeatable")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "output": *output}); err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		fs.Usage()
+		return usagef("at least one -sub is required")
+	}
+
+	f, err := newFFmpeg()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	inputs := []string{*input}
+	for _, s := range subs {
+		inputs = append(inputs, s.Path)
+	}
+	return reportMedia(ctx, inputs, *output, f.MuxSubtitles(ctx, *input, *output, subs))
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/hallucination"
+	"github.com/gleicon/transcoder/pkg/mt"
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/proc"
+	"github.com/gleicon/transcoder/pkg/translation"
+)
+
+// jsonOutput is set by -json, given before the command or among its flags
+var jsonOutput bool
+
+// report is the single JSON document printed on stdout with -json
+type report struct {
+	mu sync.Mutex
+
+	Command       string             `json:"command"`
+	Status        string             `json:"status"` // succeeded, failed, cancelled or usage
+	ExitCode      int                `json:"exit_code"`
+	Inputs        []string           `json:"inputs,omitempty"`
+	Outputs       []string           `json:"outputs,omitempty"`
+	Language      string             `json:"language,omitempty"`       // spoken language, when known
+	MediaDuration float64            `json:"media_duration,omitempty"` // seconds
+	Elapsed       float64            `json:"elapsed"`                  // seconds
+	Stages        map[string]float64 `json:"stages,omitempty"`         // seconds per stage
+	Cached        []string           `json:"cached,omitempty"`         // stages whose result came from the cache
+	Removed       []removedCue       `json:"removed,omitempty"`        // cues removed by -filter
+	Glossary      []glossaryCue      `json:"glossary,omitempty"`       // translated cues that break -glossary
+	Memory        *mt.MemoryMatches  `json:"memory,omitempty"`         // cues translated from the -tm translation memory
+	Warnings      []string           `json:"warnings,omitempty"`
+	Error         *reportError       `json:"error,omitempty"`
+	Result        any                `json:"result,omitempty"` // command specific details
+}
+
+// removedCue is a cue the hallucination filter removed
+type removedCue struct {
+	Cue    int     `json:"cue"`
+	Start  float64 `json:"start"` // seconds
+	End    float64 `json:"end"`   // seconds
+	Text   string  `json:"text"`
+	Reason string  `json:"reason"` // repeated, boilerplate, silence or low-probability
+}
+
+// glossaryCue is a translated cue that lacks the translation of a glossary term
+type glossaryCue struct {
+	Cue   int     `json:"cue"`
+	Start float64 `json:"start"` // seconds
+	End   float64 `json:"end"`   // seconds
+	Lang  string  `json:"lang"`
+	Term  string  `json:"term"`
+	Want  string  `json:"want"` // required translation
+	Text  string  `json:"text"` // translated text
+}
+
+// reportError classifies the error a command failed with
+type reportError struct {
+	Class     string `json:"class"` // usage, cancelled, input, timeout, tool, transient or failure
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"` // running the command again may succeed
+}
+
+type reportKey struct{}
+
+// withReport returns a context carrying r
+func withReport(ctx context.Context, r *report) context.Context {
+	return context.WithValue(ctx, reportKey{}, r)
+}
+
+// reportFrom returns the report of the running command. Without one, a
+// throwaway report is returned so commands can record unconditionally.
+func reportFrom(ctx context.Context) *report {
+	if r, ok := ctx.Value(reportKey{}).(*report); ok {
+		return r
+	}
+	return &report{}
+}
+
+// input records the files a command read
+func (r *report) input(paths ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Inputs = append(r.Inputs, paths...)
+}
+
+// output records the files a command wrote
+func (r *report) output(paths ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Outputs = append(r.Outputs, paths...)
+}
+
+// warn records a problem that did not make the command fail
+func (r *report) warn(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// stage adds the wall time of a stage
+func (r *report) stage(name string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Stages == nil {
+		r.Stages = make(map[string]float64)
+	}
+	r.Stages[name] += d.Seconds()
+}
+
+// result sets the command specific details
+func (r *report) result(v any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Result = v
+}
+
+// media records the spoken language and duration of the input
+func (r *report) media(lang string, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if lang != "" {
+		r.Language = lang
+	}
+	if duration > 0 {
+		r.MediaDuration = duration.Seconds()
+	}
+}
+
+// stats records what a translator call did
+func (r *report) stats(s *translation.Stats) {
+	r.media(s.Language, s.Duration)
+	for name, d := range s.Stages {
+		r.stage(name, d)
+	}
+	r.mu.Lock()
+	r.Cached = append(r.Cached, s.Cached...)
+	r.mu.Unlock()
+	r.removed(s.Removed)
+	r.glossary(s.Glossary)
+	r.memory(s.Memory)
+}
+
+// removed records cues removed by the hallucination filter
+func (r *report) removed(rs []hallucination.Removal) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rm := range rs {
+		r.Removed = append(r.Removed, removedCue{Cue: rm.Cue, Start: rm.Start.Seconds(), End: rm.End.Seconds(), Text: rm.Text, Reason: rm.Reason})
+	}
+}
+
+// glossary records translated cues that break the glossary
+func (r *report) glossary(vs []mt.Violation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range vs {
+		r.Glossary = append(r.Glossary, glossaryCue{Cue: v.Cue, Start: v.Start.Seconds(), End: v.End.Seconds(), Lang: v.Lang, Term: v.Term, Want: v.Want, Text: v.Text})
+	}
+}
+
+// memory adds the cues a translation took from the translation memory
+func (r *report) memory(m mt.MemoryMatches) {
+	if m == (mt.MemoryMatches{}) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Memory == nil {
+		r.Memory = &mt.MemoryMatches{}
+	}
+	r.Memory.Add(m)
+}
+
+// finish fills in the outcome of the command
+func (r *report) finish(ctx context.Context, code int, err error, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ExitCode = code
+	r.Elapsed = elapsed.Seconds()
+	switch code {
+	case exitOK:
+		r.Status = "succeeded"
+	case exitUsage:
+		r.Status = "usage"
+	case exitCancelled:
+		r.Status = "cancelled"
+	default:
+		r.Status = "failed"
+	}
+	if err != nil && code != exitOK {
+		r.Error = classify(ctx, err)
+	}
+	sort.Strings(r.Warnings)
+}
+
+// write prints the report as JSON
+func (r *report) write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// classify describes err for scripts deciding what to do about a failure
+func classify(ctx context.Context, err error) *reportError {
+	e := &reportError{Class: "failure", Message: err.Error()}
+	var usage *usageError
+	var timeout *policy.TimeoutError
+	var tool *proc.ExitError
+	switch {
+	case errors.As(err, &usage):
+		e.Class = "usage"
+	case ctx.Err() != nil, errors.Is(err, context.Canceled):
+		e.Class = "cancelled"
+		e.Retryable = true
+	case errors.Is(err, fs.ErrNotExist):
+		e.Class = "input"
+	case errors.As(err, &timeout):
+		e.Class = "timeout"
+		e.Retryable = true
+	case errors.As(err, &tool):
+		e.Class = "tool"
+		e.Retryable = tool.Transient()
+	case policy.Transient(err):
+		e.Class = "transient"
+		e.Retryable = true
+	}
+	return e
+}
+
+var (
+	stdoutOnce sync.Once
+	jsonStdout = os.Stdout
+)
+
+// reserveStdout keeps stdout for the JSON report: from then on, text output
+// of the commands and of the tools they run goes to stderr
+func reserveStdout() {
+	stdoutOnce.Do(func() {
+		jsonStdout = os.Stdout
+		os.Stdout = os.Stderr
+	})
+}
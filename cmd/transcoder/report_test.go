@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gleicon/transcoder/pkg/mt"
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/proc"
+)
+
+func TestClassify(t *testing.T) {
+	tool := &proc.ExitError{Name: "ffmpeg", Err: errors.New("exit status 1"), Stderr: "Connection reset by peer"}
+	tests := []struct {
+		name      string
+		err       error
+		class     string
+		retryable bool
+	}{
+		{name: "usage", err: usagef("missing command"), class: "usage"},
+		{name: "input", err: &notFoundError{path: "talk.mp4"}, class: "input"},
+		{name: "timeout", err: fmt.Errorf("failed to transcribe audio: %w", &policy.TimeoutError{Err: context.DeadlineExceeded}), class: "timeout", retryable: true},
+		{name: "tool", err: fmt.Errorf("failed to extract audio: %w", tool), class: "tool", retryable: true},
+		{name: "backend", err: &mt.HTTPError{StatusCode: 503}, class: "transient", retryable: true},
+		{name: "other", err: errors.New("no media files found"), class: "failure"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classify(context.Background(), tt.err)
+			if got.Class != tt.class || got.Retryable != tt.retryable {
+				t.Errorf("classify() = %s (retryable %v), want %s (retryable %v)", got.Class, got.Retryable, tt.class, tt.retryable)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := classify(ctx, errors.New("signal: terminated")); got.Class != "cancelled" {
+		t.Errorf("classify() after cancellation = %s", got.Class)
+	}
+}
+
+func TestReportFinish(t *testing.T) {
+	r := &report{}
+	r.finish(context.Background(), exitOK, nil, 0)
+	if r.Status != "succeeded" || r.Error != nil {
+		t.Errorf("report = %+v", r)
+	}
+
+	r = &report{}
+	r.finish(context.Background(), exitFailure, errors.New("boom"), 0)
+	if r.Status != "failed" || r.Error == nil || r.Error.Message != "boom" {
+		t.Errorf("report = %+v", r)
+	}
+}
+
+func TestReportMemory(t *testing.T) {
+	r := &report{}
+	r.memory(mt.MemoryMatches{})
+	if r.Memory != nil {
+		t.Errorf("report memory = %+v without matches", r.Memory)
+	}
+	r.memory(mt.MemoryMatches{Exact: 1, Translated: 2})
+	r.memory(mt.MemoryMatches{Fuzzy: 1, Translated: 1})
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"memory":{"exact":1,"fuzzy":1,"translated":3}`; !strings.Contains(string(data), want) {
+		t.Errorf("report = %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/pipeline"
+	"github.com/gleicon/transcoder/pkg/translation"
+	"github.com/gleicon/transcoder/pkg/whisper"
+)
+
+func runPipeline(ctx context.Context, args []string) error {
+	fs := newFlagSet("run", "[flags] <pipeline.yaml|pipeline.json>",
+		"Run the steps of a pipeline file: probe, normalize, extract, transcribe, translate, bilingual,\n"+
+			"burn, mux and speed. The -model flags apply to transcribe steps that do not name a model.")
+	input := stringFlag(fs, "input", "i", "Input media file, overrides the pipeline's input")
+	outputDir := stringFlag(fs, "output-dir", "o", "Output directory, overrides the pipeline's output_dir")
+	var wopts whisperOptions
+	wopts.register(fs)
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usagef("expected exactly one pipeline file")
+	}
+
+	def, err := pipeline.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *input != "" {
+		def.Input = *input
+	}
+	if *outputDir != "" {
+		def.OutputDir = *outputDir
+	}
+	if err := def.Validate(); err != nil {
+		return err
+	}
+
+	config, err := wopts.config()
+	if err != nil {
+		return err
+	}
+	limits, err := wopts.limits(ctx)
+	if err != nil {
+		return err
+	}
+	runner := &pipeline.Runner{
+		Whisper:   config,
+		Workspace: wopts.workspace(),
+		Policy:    limits,
+		NewTranslator: func(config whisper.Config) (*translation.Translator, error) {
+			t, err := translation.NewWithConfig(config)
+			if err != nil {
+				return nil, err
+			}
+			if err := wopts.setup(ctx, t); err != nil {
+				t.Close()
+				return nil, err
+			}
+			return t, nil
+		},
+	}
+
+	rep := reportFrom(ctx)
+	rep.input(def.Input)
+	result, err := runner.Run(ctx, def)
+	if err != nil {
+		return err
+	}
+	rep.output(result.Subtitles...)
+	if result.Media != "" {
+		rep.output(result.Media)
+	}
+	for stage, d := range result.Timings {
+		rep.stage(stage, d)
+	}
+	var duration time.Duration
+	if result.Probe != nil {
+		duration = result.Probe.Duration
+	}
+	rep.media(result.Language, duration)
+	rep.glossary(result.Glossary)
+	if result.Memory != nil {
+		rep.memory(*result.Memory)
+	}
+	rep.result(result)
+
+	for _, path := range result.Subtitles {
+		fmt.Println(path)
+	}
+	if result.Media != "" {
+		fmt.Println(result.Media)
+	}
+	return nil
+}
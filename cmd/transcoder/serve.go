@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/server"
+	"github.com/gleicon/transcoder/pkg/translation"
+)
+
+// stringList collects a repeatable string flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+var _ flag.Value = (*stringList)(nil)
+
+func runServe(ctx context.Context, args []string) error {
+	fs := newFlagSet("serve", "[flags]",
+		"Run the HTTP job API. Jobs are submitted with POST /jobs, either as a multipart upload\n"+
+			"(\"file\" plus an \"options\" JSON field) or as JSON referencing a file under an -allow-dir.")
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	dataDir := fs.String("data-dir", "transcoder-data", "Directory for uploads and job outputs")
+	workers := fs.Int("workers", 2, "Number of jobs processed concurrently")
+	queueSize := fs.Int("queue", 100, "Number of jobs that may wait for a worker")
+	maxUpload := fs.Int64("max-upload-mb", 2048, "Maximum upload size in MiB")
+	var allowDirs stringList
+	fs.Var(&allowDirs, "allow-dir", "Directory whose files jobs may reference by path; repeatable")
+	var wopts whisperOptions
+	wopts.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *workers < 1 {
+		return usagef("workers must be at least 1")
+	}
+
+	config, err := wopts.config()
+	if err != nil {
+		return err
+	}
+	translator, err := translation.NewWithConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create translator: %w", err)
+	}
+	defer translator.Close()
+	if err := wopts.setup(ctx, translator); err != nil {
+		return err
+	}
+
+	scfg := server.DefaultConfig(*dataDir)
+	scfg.Workers = *workers
+	scfg.QueueSize = *queueSize
+	scfg.MaxUploadSize = *maxUpload << 20
+	scfg.InputRoots = allowDirs
+	scfg.Workspace = wopts.workspace()
+	srv, err := server.New(scfg, server.NewTranslatorProcessor(translator))
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	// The context ends on SIGINT or SIGTERM; queued and running jobs resume
+	// on the next start
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	srv.Start(ctx)
+	httpServer := &http.Server{Addr: *addr, Handler: srv.Handler()}
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", *addr)
+		errc <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		stop()
+		srv.Wait()
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = httpServer.Shutdown(shutdownCtx)
+	srv.Wait()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
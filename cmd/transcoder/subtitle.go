@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/align"
+	"github.com/gleicon/transcoder/pkg/ffmpeg"
+	"github.com/gleicon/transcoder/pkg/hallucination"
+	"github.com/gleicon/transcoder/pkg/mt"
+	"github.com/gleicon/transcoder/pkg/subtitle"
+	"github.com/gleicon/transcoder/pkg/translation"
+)
+
+var subtitleCommands = []command{
+	{name: "convert", summary: "Convert between SRT and WebVTT", run: runSubtitleConvert},
+	{name: "shift", summary: "Move every cue by a fixed offset and stretch timings", run: runSubtitleShift},
+	{name: "resync", summary: "Retime cues from two reference points", run: runSubtitleResync},
+	{name: "align", summary: "Snap cue timings to the speech in a media file", run: runSubtitleAlign},
+	{name: "resegment", summary: "Split and merge cues to follow caption readability rules", run: runSubtitleResegment},
+	{name: "lint", summary: "Check subtitle files for timing, text and readability problems", run: runSubtitleLint},
+	{name: "clean", summary: "Remove repeated, boilerplate and silent cues whisper made up", run: runSubtitleClean},
+	{name: "bilingual", summary: "Combine a transcript and its translation into dual-line cues", run: runSubtitleBilingual},
+	{name: "glossary", summary: "Check that a translation follows a glossary", run: runSubtitleGlossary},
+}
+
+func runSubtitle(ctx context.Context, args []string) error {
+	return dispatch(ctx, "transcoder subtitle", subtitleCommands, args)
+}
+
+func runSubtitleConvert(ctx context.Context, args []string) error {
+	fs := newFlagSet("subtitle convert", "-input <file> -output <file> [-style <style>]",
+		"Convert a subtitle file; formats are taken from the file extensions (.srt, .vtt, .json,\n"+
+			".ass, .ssa). ASS outputs are styled with -style and -speaker-style.")
+	input := stringFlag(fs, "input", "i", "Input subtitle file")
+	output := stringFlag(fs, "output", "o", "Output subtitle file")
+	var sopts styleOptions
+	sopts.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "output": *output}); err != nil {
+		return err
+	}
+	style, err := sopts.options()
+	if err != nil {
+		return err
+	}
+
+	track, err := subtitle.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+	if format, _ := subtitle.FormatFromPath(*output); format == subtitle.FormatASS {
+		return reportMedia(ctx, []string{*input}, *output, subtitle.WriteASSFile(*output, track, style))
+	}
+	return reportMedia(ctx, []string{*input}, *output, subtitle.WriteFile(*output, track))
+}
+
+func runSubtitleShift(ctx context.Context, args []string) error {
+	fs := newFlagSet("subtitle shift", "-input <file> -output <file> [-offset <duration>] [-stretch <factor>]",
+		"Move every cue by an offset such as 2.5s or -1m3s, after multiplying timestamps by\n"+
+			"-stretch (e.g. 1.0427 for 23.976 fps subtitles on 25 fps video). Cues moved before\n"+
+			"zero are clamped or dropped.")
+	input := stringFlag(fs, "input", "i", "Input subtitle file")
+	output := stringFlag(fs, "output", "o", "Output subtitle file")
+	offset := fs.Duration("offset", 0, "Offset to add to every cue, negative to move cues earlier")
+	stretch := fs.Float64("stretch", 1, "Factor to multiply every timestamp by")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "output": *output}); err != nil {
+		return err
+	}
+	if *stretch <= 0 {
+		return usagef("stretch must be greater than 0")
+	}
+
+	track, err := subtitle.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+	track.Transform(*stretch, *offset)
+	return reportMedia(ctx, []string{*input}, *output, subtitle.WriteFile(*output, track))
+}
+
+func runSubtitleResync(ctx context.Context, args []string) error {
+	fs := newFlagSet("subtitle resync", "-input <file> -output <file> -at <cue>=<time> -at <cue>=<time>",
+		"Retime every cue linearly so that two cues start at the given times, fixing both an\n"+
+			"offset and a drift. Pick cues far apart, e.g. -at 5=00:01:02 -at 300=00:58:10.")
+	input := stringFlag(fs, "input", "i", "Input subtitle file")
+	output := stringFlag(fs, "output", "o", "Output subtitle file")
+	var at stringList
+	fs.Var(&at, "at", "Reference point <cue number>=<timestamp>; given twice")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "output": *output}); err != nil {
+		return err
+	}
+	if len(at) != 2 {
+		fs.Usage()
+		return usagef("-at must be given exactly twice, got %d", len(at))
+	}
+	var points [2]subtitle.SyncPoint
+	for i, s := range at {
+		p, err := subtitle.ParseSyncPoint(s)
+		if err != nil {
+			return usagef("%v", err)
+		}
+		points[i] = p
+	}
+
+	track, err := subtitle.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+	if err := track.Resync(points[0], points[1]); err != nil {
+		return err
+	}
+	return reportMedia(ctx, []string{*input}, *output, subtitle.WriteFile(*output, track))
+}
+
+func runSubtitleAlign(ctx context.Context, args []string) error {
+	fs := newFlagSet("subtitle align", "-input <file> -media <media> -output <file> [flags]",
+		"Retime existing subtitles, such as a human transcript or a translation made for another\n"+
+			"cut of the video, to the speech in a media file. Cues are moved to cover speech, with\n"+
+			"offsets that may change where the edits differ, pinned to words transcribed by whisper\n"+
+			"where their text matches, and snapped to the start and end of speech.")
+	input := stringFlag(fs, "input", "i", "Input subtitle file")
+	media := stringFlag(fs, "media", "m", "Audio or video file to align to")
+	output := stringFlag(fs, "output", "o", "Output subtitle file")
+	words := fs.Bool("words", true, "Transcribe words to pin cues whose text matches the speech; disable for subtitles in another language")
+	maxShift := fs.Duration("max-shift", 2*time.Minute, "Farthest a cue may move")
+	snap := fs.Duration("snap", 400*time.Millisecond, "Move cue boundaries this close to the start or end of speech onto it, 0 to disable")
+	noise := fs.Float64("noise", -35, "Level in dB below which audio counts as silence")
+	var wopts whisperOptions
+	wopts.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "media": *media, "output": *output}); err != nil {
+		return err
+	}
+	if *maxShift <= 0 {
+		return usagef("max-shift must be greater than 0")
+	}
+
+	track, err := subtitle.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+	config, err := wopts.config()
+	if err != nil {
+		return err
+	}
+	translator, err := newTranslator(*media, *output, config)
+	if err != nil {
+		return err
+	}
+	defer translator.Close()
+	if err := wopts.setup(ctx, translator); err != nil {
+		return err
+	}
+
+	rep := reportFrom(ctx)
+	rep.input(*input, *media)
+	stats := &translation.Stats{}
+	defer rep.stats(stats)
+
+	opts := translation.AlignOptions{Words: *words}
+	opts.Align.MaxShift = *maxShift
+	opts.Align.Snap = *snap
+	if *snap == 0 {
+		opts.Align.Snap = -1
+	}
+	opts.Speech.Noise = *noise
+	result, err := translator.Align(translation.WithStats(ctx, stats), *media, track, opts)
+	if err != nil {
+		return err
+	}
+	if err := subtitle.WriteFile(*output, result.Track); err != nil {
+		return err
+	}
+	rep.output(*output)
+	rep.result(alignSummary(result))
+	return nil
+}
+
+func runSubtitleResegment(ctx context.Context, args []string) error {
+	fs := newFlagSet("subtitle resegment", "-input <file> -output <file> [-captions <rules>]",
+		"Split and merge cues into ones that follow caption guidelines: lines per cue, characters\n"+
+			"per line, reading speed, display time and the gap between cues. Cues break at the end of\n"+
+			"sentences and phrases where possible. Word timings are estimated from the cues; use\n"+
+			"-captions on transcribe to segment with the timestamps of transcribed words instead.")
+	input := stringFlag(fs, "input", "i", "Input subtitle file")
+	output := stringFlag(fs, "output", "o", "Output subtitle file")
+	captions := fs.String("captions", "broadcast", "Caption rules: \"broadcast\" ("+subtitle.DefaultStyle().String()+"), optionally with overrides such as broadcast,cps=15")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "output": *output}); err != nil {
+		return err
+	}
+	style, err := subtitle.ParseStyle(*captions)
+	if err != nil {
+		return usagef("%v", err)
+	}
+
+	track, err := subtitle.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+	track.Resegment(style)
+	return reportMedia(ctx, []string{*input}, *output, subtitle.WriteFile(*output, track))
+}
+
+func runSubtitleLint(ctx context.Context, args []string) error {
+	fs := newFlagSet("subtitle lint", "[flags] <file>...",
+		"Check subtitle files before publishing. Overlapping cues, cues without duration or text,\n"+
+			"cues past the end of the media, broken encoding and text repeated by a looping\n"+
+			"transcription are errors; cues too fast to read or with too many or too long lines are\n"+
+			"warnings. Exits with status 1 when errors are found, or warnings with -strict.")
+	media := stringFlag(fs, "media", "m", "Audio or video file the subtitles belong to; cues ending after it are errors")
+	duration := fs.Duration("duration", 0, "Duration of the media, instead of probing -media")
+	captions := fs.String("captions", "broadcast", "Caption rules for the warnings: \"broadcast\" ("+subtitle.DefaultStyle().String()+"), optionally with overrides such as broadcast,cps=20")
+	repeats := fs.Int("max-repeats", 3, "Consecutive cues with the same text reported as a transcription loop")
+	strict := fs.Bool("strict", false, "Fail on warnings as well as errors")
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return usagef("no subtitle files given")
+	}
+	if *duration < 0 {
+		return usagef("duration must not be negative")
+	}
+	if *repeats < 2 {
+		return usagef("max-repeats must be at least 2")
+	}
+	style, err := subtitle.ParseStyle(*captions)
+	if err != nil {
+		return usagef("%v", err)
+	}
+
+	rep := reportFrom(ctx)
+	opts := subtitle.LintOptions{Style: style, MediaDuration: *duration, MaxRepeats: *repeats}
+	if *media != "" && opts.MediaDuration == 0 {
+		f, err := newFFmpeg()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rep.input(*media)
+		probe, err := f.Probe(ctx, *media)
+		if err != nil {
+			return err
+		}
+		opts.MediaDuration = probe.Duration
+		rep.media("", probe.Duration)
+	}
+
+	var result lintResult
+	for _, path := range fs.Args() {
+		track, err := subtitle.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rep.input(path)
+		file := lintFile{File: path, Cues: len(track.Cues), Issues: subtitle.Validate(track, opts)}
+		if file.Issues == nil {
+			file.Issues = []subtitle.Issue{}
+		}
+		for _, issue := range file.Issues {
+			if issue.Severity == subtitle.SeverityError {
+				file.Errors++
+			} else {
+				file.Warnings++
+			}
+			if !jsonOutput {
+				fmt.Printf("%s: %s\n", path, issue)
+			}
+		}
+		result.Errors += file.Errors
+		result.Warnings += file.Warnings
+		result.Files = append(result.Files, file)
+	}
+	rep.result(result)
+
+	if !jsonOutput {
+		fmt.Printf("%d files checked: %d errors, %d warnings\n", len(result.Files), result.Errors, result.Warnings)
+	}
+	if result.Errors > 0 || *strict && result.Warnings > 0 {
+		return fmt.Errorf("subtitle check failed with %d errors and %d warnings", result.Errors, result.Warnings)
+	}
+	return nil
+}
+
+func runSubtitleClean(ctx context.Context, args []string) error {
+	fs := newFlagSet("subtitle clean", "-input <file> -output <file> [-media <media>] [flags]",
+		"Remove text whisper made up, typically over music and silence: loops of the same text,\n"+
+			"boilerplate such as \"Thank you for watching\" and, with -media, cues where the audio is\n"+
+			"silent. Transcribe with -filter to also remove cues whisper gave a low probability.")
+	input := stringFlag(fs, "input", "i", "Input subtitle file")
+	output := stringFlag(fs, "output", "o", "Output subtitle file")
+	media := stringFlag(fs, "media", "m", "Audio or video file the subtitles belong to, to remove cues over silence")
+	repeats := fs.Int("max-repeats", 3, "Consecutive cues with the same text from which all but the first are removed")
+	phrases := fs.String("phrases", "", "File of further boilerplate phrases to remove, one per line")
+	noise := fs.Float64("noise", -35, "Level in dB below which audio counts as silence")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "output": *output}); err != nil {
+		return err
+	}
+	if *repeats < 2 {
+		return usagef("max-repeats must be at least 2")
+	}
+
+	opts := hallucination.Options{Repeats: *repeats}
+	if *phrases != "" {
+		data, err := os.ReadFile(*phrases)
+		if err != nil {
+			return fmt.Errorf("failed to read phrases: %w", err)
+		}
+		opts.Phrases = append(slices.Clone(hallucination.DefaultPhrases), strings.Split(string(data), "\n")...)
+	}
+	track, err := subtitle.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+	rep := reportFrom(ctx)
+	rep.input(*input)
+	if *media != "" {
+		f, err := newFFmpeg()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rep.input(*media)
+		speech, err := f.DetectSpeech(ctx, *media, ffmpeg.SpeechOptions{Noise: *noise})
+		if err != nil {
+			return err
+		}
+		opts.Speech = make([]hallucination.Interval, len(speech))
+		for i, s := range speech {
+			opts.Speech[i] = hallucination.Interval{Start: s.Start, End: s.End}
+		}
+	}
+
+	result := hallucination.Filter(track, opts)
+	if err := subtitle.WriteFile(*output, result.Track); err != nil {
+		return err
+	}
+	rep.output(*output)
+	rep.removed(result.Removed)
+	if !jsonOutput {
+		for _, r := range result.Removed {
+			fmt.Println("removed", r)
+		}
+		fmt.Printf("%d of %d cues removed\n", len(result.Removed), len(track.Cues))
+	}
+	return nil
+}
+
+// lintResult is the -json result of subtitle lint
+type lintResult struct {
+	Errors   int        `json:"errors"`
+	Warnings int        `json:"warnings"`
+	Files    []lintFile `json:"files"`
+}
+
+// lintFile lists the issues found in one file
+type lintFile struct {
+	File     string           `json:"file"`
+	Cues     int              `json:"cues"`
+	Errors   int              `json:"errors"`
+	Warnings int              `json:"warnings"`
+	Issues   []subtitle.Issue `json:"issues"`
+}
+
+// alignResult is the -json result of subtitle align
+type alignResult struct {
+	Cues     int     `json:"cues"`
+	Anchored int     `json:"anchored"`  // cues pinned to transcribed words
+	Offsets  int     `json:"offsets"`   // distinct offsets, more than one where the edits differ
+	MinShift float64 `json:"min_shift"` // seconds
+	MaxShift float64 `json:"max_shift"` // seconds
+}
+
+// alignSummary describes the offsets given to the cues
+func alignSummary(r *align.Result) alignResult {
+	s := alignResult{Cues: len(r.Track.Cues), Anchored: r.Anchored}
+	for i, d := range r.Shifts {
+		if i == 0 || d != r.Shifts[i-1] {
+			s.Offsets++
+		}
+		if i == 0 || d.Seconds() < s.MinShift {
+			s.MinShift = d.Seconds()
+		}
+		if i == 0 || d.Seconds() > s.MaxShift {
+			s.MaxShift = d.Seconds()
+		}
+	}
+	return s
+}
+
+func runSubtitleBilingual(ctx context.Context, args []string) error {
+	fs := newFlagSet("subtitle bilingual", "-input <file> -translation <file> -output <file> [-plain]",
+		"Combine subtitles and their translation into one file whose cues show the original line\n"+
+			"with the translated line below it, set in italics in SRT and styled with the\n"+
+			"\"secondary\" class in WebVTT. Cues keep the timings of -input; translated cues are\n"+
+			"paired by position when timed alike and otherwise by their overlap in time.")
+	input := stringFlag(fs, "input", "i", "Subtitle file with the original language")
+	translation := stringFlag(fs, "translation", "t", "Subtitle file with the translation")
+	output := stringFlag(fs, "output", "o", "Output subtitle file")
+	plain := fs.Bool("plain", false, "Write the translated line as plain text, without styling")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "translation": *translation, "output": *output}); err != nil {
+		return err
+	}
+
+	primary, err := subtitle.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+	secondary, err := subtitle.ReadFile(*translation)
+	if err != nil {
+		return err
+	}
+	track := subtitle.Bilingual(primary, secondary)
+	if *plain {
+		track.Flatten()
+	}
+	return reportMedia(ctx, []string{*input, *translation}, *output, subtitle.WriteFile(*output, track))
+}
+
+func runSubtitleGlossary(ctx context.Context, args []string) error {
+	fs := newFlagSet("subtitle glossary", "-input <file> -translation <file> -glossary <file> -lang <code>",
+		"Check a translation against a glossary: every glossary term in a cue of -input must\n"+
+			"appear in the translated cue as the glossary translates it into -lang, or unchanged\n"+
+			"when it has no translation. Exits with status 1 when a cue breaks the glossary.")
+	input := stringFlag(fs, "input", "i", "Subtitle file with the original language")
+	translation := stringFlag(fs, "translation", "t", "Subtitle file with the translation")
+	glossary := stringFlag(fs, "glossary", "g", "Glossary file (.csv, .tsv or .tbx)")
+	lang := fs.String("lang", "", "Language of the translation")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "translation": *translation, "glossary": *glossary, "lang": *lang}); err != nil {
+		return err
+	}
+
+	g, err := mt.LoadGlossary(*glossary)
+	if err != nil {
+		return err
+	}
+	source, err := subtitle.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+	translated, err := subtitle.ReadFile(*translation)
+	if err != nil {
+		return err
+	}
+	rep := reportFrom(ctx)
+	rep.input(*input, *translation, *glossary)
+
+	violations := g.Check(source, translated, *lang)
+	rep.glossary(violations)
+	if !jsonOutput {
+		for _, v := range violations {
+			fmt.Printf("%s: %s\n", *translation, v)
+		}
+		fmt.Printf("%d cues checked: %d glossary violations\n", len(source.Cues), len(violations))
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("glossary check failed with %d violations", len(violations))
+	}
+	return nil
+}
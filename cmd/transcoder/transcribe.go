@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gleicon/transcoder/pkg/ffmpeg"
+	"github.com/gleicon/transcoder/pkg/mt"
+	"github.com/gleicon/transcoder/pkg/subtitle"
+	"github.com/gleicon/transcoder/pkg/translation"
+	"github.com/gleicon/transcoder/pkg/whisper"
+)
+
+func runTranscribe(ctx context.Context, args []string) error {
+	fs := newFlagSet("transcribe", "-input <media> -output <subtitles.srt|.vtt> [flags]",
+		"Transcribe the speech in an audio or video file into a subtitle file.")
+	input := stringFlag(fs, "input", "i", "Input audio or video file")
+	output := stringFlag(fs, "output", "o", "Output subtitle file (.srt or .vtt)")
+	lang := fs.String("lang", "auto", "Language spoken in the input, or auto to detect it")
+	var wopts whisperOptions
+	wopts.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "output": *output}); err != nil {
+		return err
+	}
+
+	config, err := wopts.config()
+	if err != nil {
+		return err
+	}
+	config.Language = *lang
+
+	translator, err := newTranslator(*input, *output, config)
+	if err != nil {
+		return err
+	}
+	defer translator.Close()
+	if err := wopts.setup(ctx, translator); err != nil {
+		return err
+	}
+
+	return reportSubtitles(ctx, *input, *output, func(ctx context.Context, output string) error {
+		return writeSubtitles(output, func(srt string) error {
+			return translator.Transcribe(ctx, *input, srt)
+		})
+	})
+}
+
+func runTranslate(ctx context.Context, args []string) error {
+	fs := newFlagSet("translate", "-input <media> -output <subtitles.srt|.vtt> -lang <code>[,<code>...] [flags]",
+		"Transcribe and translate the speech in an audio or video file into subtitle files.\n"+
+			"With several languages the speech is transcribed once and each translation is\n"+
+			"written next to -output as <base>.<lang><ext>.")
+	input := stringFlag(fs, "input", "i", "Input audio or video file")
+	output := stringFlag(fs, "output", "o", "Output subtitle file (.srt or .vtt)")
+	lang := fs.String("lang", "", "Target languages for translation, comma separated (e.g. es or es,fr,de)")
+	bilingual := fs.Bool("bilingual", false, "Show the transcript with the translation below it in every cue")
+	mtURL := fs.String("mt-url", "", "LibreTranslate server that translates the transcript, for targets other than English")
+	mtKey := fs.String("mt-api-key", "", "API key for the -mt-url server")
+	glossary := fs.String("glossary", "", "Glossary of required and protected term translations for -mt-url (.csv, .tsv or .tbx)")
+	tm := fs.String("tm", "", "Translation memory file: cues translated before are reused instead of asking -mt-url, new ones are added")
+	fuzzy := fs.Float64("tm-fuzzy", mt.DefaultFuzzy, "Similarity from 0 to 1 from which -tm reuses the translation of a different text as it is, so words or names that differ keep the stored translation; 1 reuses exact matches only")
+	mux := fs.String("mux", "", "Also add every translation to a copy of the input in this video file (.mkv or .mp4)")
+	var wopts whisperOptions
+	wopts.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := require(fs, map[string]string{"input": *input, "output": *output, "lang": *lang}); err != nil {
+		return err
+	}
+	langs := splitLangs(*lang)
+	if len(langs) == 0 {
+		return usagef("-lang is required")
+	}
+	if *bilingual && len(langs) > 1 {
+		return usagef("-bilingual takes a single -lang")
+	}
+	// Whisper only translates into English; other targets need a backend
+	if *mtURL == "" && (len(langs) > 1 || langs[0] != "en") {
+		return usagef("translating to %s requires -mt-url", strings.Join(langs, ","))
+	}
+	if *glossary != "" && *mtURL == "" {
+		return usagef("-glossary requires -mt-url")
+	}
+	if *tm != "" && *mtURL == "" {
+		return usagef("-tm requires -mt-url")
+	}
+	if *fuzzy < 0 || *fuzzy > 1 {
+		return usagef("tm-fuzzy must be between 0 and 1")
+	}
+
+	config, err := wopts.config()
+	if err != nil {
+		return err
+	}
+
+	translator, err := newTranslator(*input, *output, config)
+	if err != nil {
+		return err
+	}
+	defer translator.Close()
+	if err := wopts.setup(ctx, translator); err != nil {
+		return err
+	}
+	if *mtURL != "" {
+		backend, err := mt.New(mt.Config{URL: *mtURL, APIKey: *mtKey})
+		if err != nil {
+			return usagef("%v", err)
+		}
+		translator.SetBackend(backend)
+	}
+	if *glossary != "" {
+		g, err := mt.LoadGlossary(*glossary)
+		if err != nil {
+			return err
+		}
+		translator.SetGlossary(g)
+	}
+	if *tm != "" {
+		m, err := mt.OpenMemory(*tm)
+		if err != nil {
+			return err
+		}
+		m.Fuzzy = *fuzzy
+		translator.SetMemory(m)
+	}
+
+	outputs := map[string]string{langs[0]: *output}
+	if len(langs) > 1 {
+		outputs = languageOutputs(*output, langs)
+	}
+	return reportTranslations(ctx, translator, *input, langs, outputs, *mux, func(ctx context.Context) error {
+		switch {
+		case *bilingual:
+			// Bilingual tracks are written in the output format directly,
+			// keeping the styling of the translated lines
+			return translator.TranslateBilingual(ctx, *input, *output, langs[0])
+		case len(langs) == 1 && *mtURL == "":
+			return writeSubtitles(*output, func(srt string) error {
+				return translator.Translate(ctx, *input, srt, langs[0])
+			})
+		default:
+			return translator.TranslateAll(ctx, *input, outputs)
+		}
+	})
+}
+
+// splitLangs returns the languages of a comma separated -lang value, without
+// blanks or repeats
+func splitLangs(value string) []string {
+	var langs []string
+	for _, lang := range strings.Split(value, ",") {
+		lang = strings.TrimSpace(lang)
+		if lang != "" && !slices.Contains(langs, lang) {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// languageOutputs names the output of each language after output, as
+// talk.srt becomes talk.es.srt
+func languageOutputs(output string, langs []string) map[string]string {
+	ext := filepath.Ext(output)
+	base := strings.TrimSuffix(output, ext)
+	outputs := make(map[string]string, len(langs))
+	for _, lang := range langs {
+		outputs[lang] = base + "." + lang + ext
+	}
+	return outputs
+}
+
+// reportTranslations writes the outputs of langs for input with produce,
+// muxes them into a copy of input when mux is set and records the run in
+// the report of ctx
+func reportTranslations(ctx context.Context, t *translation.Translator, input string, langs []string, outputs map[string]string, mux string, produce func(ctx context.Context) error) error {
+	rep := reportFrom(ctx)
+	rep.input(input)
+	stats := &translation.Stats{}
+	ctx = translation.WithStats(ctx, stats)
+	defer rep.stats(stats)
+
+	if err := produce(ctx); err != nil {
+		return err
+	}
+	streams := make([]ffmpeg.SubtitleStream, len(langs))
+	for i, lang := range langs {
+		rep.output(outputs[lang])
+		streams[i] = ffmpeg.SubtitleStream{Path: outputs[lang], Language: lang}
+	}
+	if mux == "" {
+		return nil
+	}
+	if err := t.FFmpegProcessor().MuxSubtitles(ctx, input, mux, streams); err != nil {
+		return err
+	}
+	rep.output(mux)
+	return nil
+}
+
+// reportSubtitles writes subtitles for input to output with produce and
+// records the run in the report of ctx
+func reportSubtitles(ctx context.Context, input, output string, produce func(ctx context.Context, output string) error) error {
+	rep := reportFrom(ctx)
+	rep.input(input)
+	stats := &translation.Stats{}
+	ctx = translation.WithStats(ctx, stats)
+	defer rep.stats(stats)
+
+	if err := produce(ctx, output); err != nil {
+		return err
+	}
+	rep.output(output)
+	return nil
+}
+
+// operation produces subtitles for one input with a shared translator
+type operation func(ctx context.Context, t *translation.Translator, input, output string) error
+
+// subtitleOperation returns the operation for a -mode flag value. For
+// transcribe, lang is the source language and is stored in config; for
+// translate it is the target, which has to be English.
+func subtitleOperation(mode, lang string, config *whisper.Config) (operation, error) {
+	switch mode {
+	case "transcribe":
+		if lang != "" {
+			config.Language = lang
+		}
+		return func(ctx context.Context, t *translation.Translator, input, output string) error {
+			return t.Transcribe(ctx, input, output)
+		}, nil
+	case "translate":
+		if lang == "" {
+			return nil, usagef("-lang is required with -mode translate")
+		}
+		// Whisper translates into English only; other targets need translate -mt-url
+		if lang != "en" {
+			return nil, usagef("-mode translate only translates to en, not %s", lang)
+		}
+		return func(ctx context.Context, t *translation.Translator, input, output string) error {
+			return t.Translate(ctx, input, output, lang)
+		}, nil
+	default:
+		return nil, usagef("unknown mode: %s", mode)
+	}
+}
+
+// newTranslator validates the input and output paths and creates a translator
+func newTranslator(input, output string, config whisper.Config) (*translation.Translator, error) {
+	if err := requireInput(input); err != nil {
+		return nil, err
+	}
+	if _, err := subtitle.FormatFromPath(output); err != nil {
+		return nil, usagef("%v", err)
+	}
+
+	translator, err := translation.NewWithConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create translator: %w", err)
+	}
+	return translator, nil
+}
+
+// writeSubtitles runs produce, which writes SRT, and converts the result when
+// output asks for another subtitle format
+func writeSubtitles(output string, produce func(srt string) error) error {
+	if strings.ToLower(filepath.Ext(output)) == ".srt" {
+		return produce(output)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "transcoder-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srt := filepath.Join(tmpDir, "subtitles.srt")
+	if err := produce(srt); err != nil {
+		return err
+	}
+
+	track, err := subtitle.ReadFile(srt)
+	if err != nil {
+		return err
+	}
+	return subtitle.WriteFile(output, track)
+}
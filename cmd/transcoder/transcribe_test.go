@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gleicon/transcoder/pkg/whisper"
+)
+
+func TestTranslateRequiresBackend(t *testing.T) {
+	for _, lang := range []string{"es", "en,fr"} {
+		err := runTranslate(context.Background(), []string{"-i", "talk.mp4", "-o", "talk.srt", "-lang", lang})
+		var usage *usageError
+		if !errors.As(err, &usage) || !strings.Contains(err.Error(), "requires -mt-url") {
+			t.Errorf("translate -lang %s without -mt-url error = %v, want a usage error", lang, err)
+		}
+	}
+}
+
+func TestSubtitleOperationRequiresEnglish(t *testing.T) {
+	dir := t.TempDir()
+	for name, run := range map[string]func(context.Context, []string) error{
+		"batch": func(ctx context.Context, args []string) error {
+			return runBatch(ctx, append([]string{"-o", dir}, args...))
+		},
+		"watch": runWatch,
+	} {
+		err := run(context.Background(), []string{"-mode", "translate", "-lang", "es", dir})
+		var usage *usageError
+		if !errors.As(err, &usage) || !strings.Contains(err.Error(), "only translates to en") {
+			t.Errorf("%s -mode translate -lang es error = %v, want a usage error", name, err)
+		}
+	}
+
+	var config whisper.Config
+	if _, err := subtitleOperation("translate", "en", &config); err != nil {
+		t.Errorf("subtitleOperation(translate, en) error = %v", err)
+	}
+}
+
+func TestSplitLangs(t *testing.T) {
+	if got := splitLangs(" es, fr,,es ,de"); !reflect.DeepEqual(got, []string{"es", "fr", "de"}) {
+		t.Errorf("splitLangs() = %q", got)
+	}
+}
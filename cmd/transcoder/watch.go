@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/logging"
+	"github.com/gleicon/transcoder/pkg/translation"
+	"github.com/gleicon/transcoder/pkg/watch"
+)
+
+func runWatch(ctx context.Context, args []string) error {
+	fs := newFlagSet("watch", "[flags] <dir>",
+		"Monitor a drop folder and write subtitles for every media file dropped into it.\n"+
+			"Once a file stops changing it is processed, then moved with its subtitles and a\n"+
+			"sidecar log to <dir>/done, or to <dir>/failed with the log if processing failed.")
+	mode := fs.String("mode", "transcribe", "Operation to run on each file: transcribe or translate")
+	lang := fs.String("lang", "", "Source language for transcribe (default auto), target language for translate (en only)")
+	format := fs.String("format", "srt", "Subtitle format to write: srt or vtt")
+	doneDir := fs.String("done-dir", "", "Directory for processed files and their subtitles (default <dir>/done)")
+	failedDir := fs.String("failed-dir", "", "Directory for files that failed (default <dir>/failed)")
+	stableFor := fs.Duration("stable-for", 5*time.Second, "How long a file must stay unchanged before it is processed")
+	interval := fs.Duration("interval", time.Second, "How often pending files are checked")
+	poll := fs.Bool("poll", false, "Poll the folder instead of using filesystem notifications (e.g. on network shares)")
+	workers := fs.Int("workers", 1, "Number of files processed concurrently")
+	var wopts whisperOptions
+	wopts.register(fs)
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usagef("expected exactly one directory to watch")
+	}
+	if *format != "srt" && *format != "vtt" {
+		return usagef("unknown subtitle format: %s", *format)
+	}
+
+	config, err := wopts.config()
+	if err != nil {
+		return err
+	}
+	process, err := subtitleOperation(*mode, *lang, &config)
+	if err != nil {
+		return err
+	}
+
+	translator, err := translation.NewWithConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create translator: %w", err)
+	}
+	defer translator.Close()
+	if err := wopts.setup(ctx, translator); err != nil {
+		return err
+	}
+
+	handler := func(ctx context.Context, input, outDir string) error {
+		name := filepath.Base(input)
+		output := filepath.Join(outDir, strings.TrimSuffix(name, filepath.Ext(name))+"."+*format)
+		logging.From(ctx, nil).Info("writing subtitles", "mode", *mode, "output", output)
+		return writeSubtitles(output, func(srt string) error {
+			return process(ctx, translator, input, srt)
+		})
+	}
+
+	wcfg := watch.DefaultConfig(fs.Arg(0))
+	wcfg.StableFor = *stableFor
+	wcfg.Interval = *interval
+	wcfg.Poll = *poll
+	wcfg.Workers = *workers
+	if *doneDir != "" {
+		wcfg.DoneDir = *doneDir
+	}
+	if *failedDir != "" {
+		wcfg.FailedDir = *failedDir
+	}
+
+	w, err := watch.New(wcfg, handler)
+	if err != nil {
+		return err
+	}
+
+	// Run until interrupted; files being processed are left in the drop folder
+	slog.Info("watching", "dir", wcfg.Dir)
+	return w.Run(ctx)
+}
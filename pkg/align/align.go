@@ -0,0 +1,401 @@
+// Package align retimes existing subtitles to the speech in a recording. It
+// moves every cue by an offset chosen so that cues cover speech and the gaps
+// between them fall on silence, allowing the offset to change where the edit
+// differs from the one the subtitles were made for. Where the text of the
+// cues matches words transcribed with timestamps, those words pin the cues
+// in place; subtitles in another language than the audio are placed from the
+// speech intervals alone. Cue boundaries are finally snapped to nearby speech
+// boundaries.
+package align
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gleicon/transcoder/pkg/subtitle"
+)
+
+// Interval is a span of speech
+type Interval struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Word is a transcribed word with its timing
+type Word = subtitle.Word
+
+// Options tune the alignment. Zero values use the defaults.
+type Options struct {
+	MaxShift      time.Duration // farthest a cue may move, default 2m
+	Step          time.Duration // resolution of the offsets tried, default 100ms
+	ChangePenalty float64       // cost of changing the offset between cues, default 2
+	Snap          time.Duration // cue boundaries this close to a speech boundary move onto it, default 400ms, negative for none
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxShift <= 0 {
+		o.MaxShift = 2 * time.Minute
+	}
+	if o.Step <= 0 {
+		o.Step = 100 * time.Millisecond
+	}
+	if o.ChangePenalty <= 0 {
+		o.ChangePenalty = 2
+	}
+	if o.Snap < 0 {
+		o.Snap = 0
+	} else if o.Snap == 0 {
+		o.Snap = 400 * time.Millisecond
+	}
+	return o
+}
+
+// Result is an aligned track with what was done to it
+type Result struct {
+	Track    *subtitle.Track
+	Anchored int             // cues whose words were found in the transcript
+	Shifts   []time.Duration // offset given to each cue before snapping
+}
+
+// anchorWeight is the score of a cue placed exactly where its words were
+// heard, against at most 2 for speech coverage
+const anchorWeight = 5
+
+// Align retimes track to the given speech intervals and transcribed words,
+// either of which may be empty but not both. The input track is not modified.
+func Align(track *subtitle.Track, speech []Interval, words []Word, opts Options) (*Result, error) {
+	if len(speech) == 0 && len(words) == 0 {
+		return nil, fmt.Errorf("no speech or words to align the subtitles to")
+	}
+	opts = opts.withDefaults()
+	cues := track.Cues
+	if len(cues) == 0 {
+		return &Result{Track: &subtitle.Track{}}, nil
+	}
+
+	anchors := anchorCues(cues, words, opts.MaxShift)
+	shifts := fitShifts(cues, newSpeechMap(speech, opts.Step), anchors, opts)
+
+	out := &subtitle.Track{Cues: make([]subtitle.Cue, 0, len(cues))}
+	kept := make([]time.Duration, 0, len(cues))
+	for i, c := range cues {
+		c.Start += shifts[i]
+		c.End += shifts[i]
+		if c.End <= 0 {
+			continue
+		}
+		if c.Start < 0 {
+			c.Start = 0
+		}
+		out.Cues = append(out.Cues, c)
+		kept = append(kept, shifts[i])
+	}
+	snap(out.Cues, speech, opts.Snap)
+	out.Renumber()
+	return &Result{Track: out, Anchored: len(anchors), Shifts: kept}, nil
+}
+
+// speechMap marks speech in fixed steps, with prefix sums for fast overlaps
+type speechMap struct {
+	step   time.Duration
+	prefix []int // prefix[i] is the number of speech steps before step i
+}
+
+func newSpeechMap(speech []Interval, step time.Duration) *speechMap {
+	var end time.Duration
+	for _, s := range speech {
+		end = max(end, s.End)
+	}
+	n := int(end/step) + 1
+	marks := make([]bool, n)
+	for _, s := range speech {
+		for i := int(s.Start / step); i < n && time.Duration(i)*step < s.End; i++ {
+			marks[i] = true
+		}
+	}
+	m := &speechMap{step: step, prefix: make([]int, n+1)}
+	for i, speaking := range marks {
+		m.prefix[i+1] = m.prefix[i]
+		if speaking {
+			m.prefix[i+1]++
+		}
+	}
+	return m
+}
+
+// fraction returns the share of [start, end) that is speech
+func (m *speechMap) fraction(start, end time.Duration) float64 {
+	a, b := int(start/m.step), int(end/m.step)
+	if b <= a {
+		return 0
+	}
+	clamp := func(i int) int { return min(max(i, 0), len(m.prefix)-1) }
+	return float64(m.prefix[clamp(b)]-m.prefix[clamp(a)]) / float64(b-a)
+}
+
+// maxGap is how much of the gap after a cue counts towards its score
+const maxGap = time.Second
+
+// fitShifts picks an offset per cue maximizing how well cues cover speech and
+// gaps cover silence, plus agreement with anchors, minus a penalty for every
+// change of offset between consecutive cues (Viterbi over the offsets)
+func fitShifts(cues []subtitle.Cue, m *speechMap, anchors map[int]time.Duration, opts Options) []time.Duration {
+	k := int(opts.MaxShift / opts.Step)
+	n := 2*k + 1
+	offset := func(j int) time.Duration { return time.Duration(j-k) * opts.Step }
+	hasSpeech := m.prefix[len(m.prefix)-1] > 0
+
+	score := func(i, j int) float64 {
+		c, d := cues[i], offset(j)
+		// Prefer small offsets when nothing else decides
+		s := -1e-4 * math.Abs(float64(j-k))
+		if hasSpeech {
+			s += m.fraction(c.Start+d, c.End+d)
+			if i+1 < len(cues) {
+				gapEnd := min(cues[i+1].Start, c.End+maxGap)
+				if gapEnd > c.End {
+					s += 1 - m.fraction(c.End+d, gapEnd+d)
+				}
+			}
+		}
+		if a, ok := anchors[i]; ok {
+			dist := math.Abs(float64(d-a)) / float64(5*opts.Step)
+			s += anchorWeight * math.Max(0, 1-dist)
+		}
+		return s
+	}
+
+	best := make([]float64, n)
+	switched := make([][]bool, len(cues))
+	prevBest := make([]int, len(cues))
+	for j := range best {
+		best[j] = score(0, j)
+	}
+	for i := 1; i < len(cues); i++ {
+		arg := 0
+		for j := range best {
+			if best[j] > best[arg] {
+				arg = j
+			}
+		}
+		prevBest[i] = arg
+		switched[i] = make([]bool, n)
+		top := best[arg] - opts.ChangePenalty
+		next := make([]float64, n)
+		for j := range next {
+			from := best[j]
+			if top > from {
+				from = top
+				switched[i][j] = true
+			}
+			next[j] = from + score(i, j)
+		}
+		best = next
+	}
+
+	j := 0
+	for i := range best {
+		if best[i] > best[j] {
+			j = i
+		}
+	}
+	shifts := make([]time.Duration, len(cues))
+	for i := len(cues) - 1; i >= 0; i-- {
+		shifts[i] = offset(j)
+		if i > 0 && switched[i][j] {
+			j = prevBest[i]
+		}
+	}
+	return shifts
+}
+
+// token is a normalized word with the time it is spoken at, estimated for
+// words of cues, and the cue it belongs to
+type token struct {
+	text string
+	at   time.Duration
+	cue  int
+}
+
+// tokenize splits text into lowercase words without punctuation or markup
+func tokenize(text string) []string {
+	var words []string
+	inTag := false
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			words = append(words, b.String())
+			b.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case r == '<' || r == '{':
+			inTag = true
+			flush()
+		case r == '>' || r == '}':
+			inTag = false
+		case inTag:
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(unicode.ToLower(r))
+		case r == '\'':
+		default:
+			flush()
+		}
+	}
+	flush()
+	return words
+}
+
+// ngram is the length of the word sequences matched between cues and transcript
+const ngram = 3
+
+// anchorCues matches word sequences that occur exactly once in both the cues
+// and the transcript, keeps the longest run of matches in the same order and
+// returns, per cue with matches, the median offset from the cue to the words
+func anchorCues(cues []subtitle.Cue, words []Word, maxShift time.Duration) map[int]time.Duration {
+	var ref, hyp []token
+	for i, c := range cues {
+		toks := tokenize(c.Text)
+		for j, t := range toks {
+			at := c.Start + c.Duration()*time.Duration(2*j+1)/time.Duration(2*len(toks))
+			ref = append(ref, token{text: t, at: at, cue: i})
+		}
+	}
+	for _, w := range words {
+		for _, t := range tokenize(w.Text) {
+			hyp = append(hyp, token{text: t, at: w.Start})
+		}
+	}
+
+	refPos, hypPos := uniqueGrams(ref), uniqueGrams(hyp)
+	type match struct{ r, h int }
+	var matches []match
+	for gram, r := range refPos {
+		if h, ok := hypPos[gram]; ok {
+			matches = append(matches, match{r, h})
+		}
+	}
+	sort.Slice(matches, func(a, b int) bool { return matches[a].r < matches[b].r })
+
+	hs := make([]int, len(matches))
+	for i, m := range matches {
+		hs[i] = m.h
+	}
+	deltas := make(map[int][]time.Duration)
+	for _, i := range increasingRun(hs) {
+		m := matches[i]
+		d := hyp[m.h].at - ref[m.r].at
+		if d >= -maxShift && d <= maxShift {
+			cue := ref[m.r].cue
+			deltas[cue] = append(deltas[cue], d)
+		}
+	}
+
+	anchors := make(map[int]time.Duration, len(deltas))
+	for cue, ds := range deltas {
+		sort.Slice(ds, func(a, b int) bool { return ds[a] < ds[b] })
+		anchors[cue] = ds[len(ds)/2]
+	}
+	return anchors
+}
+
+// uniqueGrams returns the position of every word sequence occurring once
+func uniqueGrams(toks []token) map[string]int {
+	pos := make(map[string]int)
+	seen := make(map[string]int)
+	for i := 0; i+ngram <= len(toks); i++ {
+		parts := make([]string, ngram)
+		for j := range parts {
+			parts[j] = toks[i+j].text
+		}
+		gram := strings.Join(parts, " ")
+		seen[gram]++
+		pos[gram] = i
+	}
+	for gram, n := range seen {
+		if n > 1 {
+			delete(pos, gram)
+		}
+	}
+	return pos
+}
+
+// increasingRun returns the indexes of a longest strictly increasing
+// subsequence of xs
+func increasingRun(xs []int) []int {
+	var tails []int // tails[l] is the index ending the best run of length l+1
+	prev := make([]int, len(xs))
+	for i, x := range xs {
+		l := sort.Search(len(tails), func(l int) bool { return xs[tails[l]] >= x })
+		if l > 0 {
+			prev[i] = tails[l-1]
+		} else {
+			prev[i] = -1
+		}
+		if l == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[l] = i
+		}
+	}
+	run := make([]int, len(tails))
+	if len(tails) == 0 {
+		return run
+	}
+	i := tails[len(tails)-1]
+	for l := len(tails) - 1; l >= 0; l-- {
+		run[l] = i
+		i = prev[i]
+	}
+	return run
+}
+
+// minCue is the shortest a cue may become by snapping
+const minCue = 300 * time.Millisecond
+
+// snap moves cue boundaries within tolerance of a speech boundary onto it,
+// then trims cues that would overlap the next one
+func snap(cues []subtitle.Cue, speech []Interval, tolerance time.Duration) {
+	if tolerance > 0 && len(speech) > 0 {
+		starts := make([]time.Duration, len(speech))
+		ends := make([]time.Duration, len(speech))
+		for i, s := range speech {
+			starts[i], ends[i] = s.Start, s.End
+		}
+		sort.Slice(starts, func(a, b int) bool { return starts[a] < starts[b] })
+		sort.Slice(ends, func(a, b int) bool { return ends[a] < ends[b] })
+
+		for i := range cues {
+			start := nearest(starts, cues[i].Start, tolerance)
+			end := nearest(ends, cues[i].End, tolerance)
+			if end-start >= minCue {
+				cues[i].Start, cues[i].End = start, end
+			}
+		}
+	}
+	for i := 0; i+1 < len(cues); i++ {
+		if cues[i].End > cues[i+1].Start && cues[i+1].Start > cues[i].Start {
+			cues[i].End = cues[i+1].Start
+		}
+	}
+}
+
+// nearest returns the value of sorted closest to t if within tolerance, else t
+func nearest(sorted []time.Duration, t, tolerance time.Duration) time.Duration {
+	i := sort.Search(len(sorted), func(i int) bool { return sorted[i] >= t })
+	best, dist := t, tolerance+1
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(sorted) {
+			continue
+		}
+		if d := (sorted[j] - t).Abs(); d < dist {
+			best, dist = sorted[j], d
+		}
+	}
+	return best
+}
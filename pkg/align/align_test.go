@@ -0,0 +1,126 @@
+package align
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/subtitle"
+)
+
+// sampleTrack returns cues of varying length and spacing, so that only one
+// offset lines them up with their speech
+func sampleTrack(n int) *subtitle.Track {
+	track := &subtitle.Track{}
+	at := 2 * time.Second
+	for i := 0; i < n; i++ {
+		length := time.Duration(1200+(i*370)%1900) * time.Millisecond
+		gap := time.Duration(400+(i*530)%2300) * time.Millisecond
+		track.Cues = append(track.Cues, subtitle.Cue{
+			Start: at,
+			End:   at + length,
+			Text:  fmt.Sprintf("line number %d of the dialogue", i),
+		})
+		at += length + gap
+	}
+	track.Renumber()
+	return track
+}
+
+func TestAlignSpeech(t *testing.T) {
+	// The new edit adds 3s before the first half and 8s before the second
+	track := sampleTrack(40)
+	var speech []Interval
+	for i, c := range track.Cues {
+		shift := 3 * time.Second
+		if i >= 20 {
+			shift = 8 * time.Second
+		}
+		speech = append(speech, Interval{Start: c.Start + shift, End: c.End + shift})
+	}
+
+	result, err := Align(track, speech, nil, Options{})
+	if err != nil {
+		t.Fatalf("Align() error = %v", err)
+	}
+	for i, c := range result.Track.Cues {
+		want := speech[i]
+		if c.Start != want.Start || c.End != want.End {
+			t.Errorf("cue %d = %v --> %v, want %v --> %v (shift %v)", i+1, c.Start, c.End, want.Start, want.End, result.Shifts[i])
+		}
+	}
+	if track.Cues[0].Start != 2*time.Second {
+		t.Error("Align() modified its input")
+	}
+}
+
+func TestAlignWords(t *testing.T) {
+	// Words heard 5.25s after the cues, with no speech intervals to go by
+	track := sampleTrack(10)
+	var words []Word
+	for _, c := range track.Cues {
+		toks := strings.Fields(c.Text)
+		for j, w := range toks {
+			at := c.Start + c.Duration()*time.Duration(2*j+1)/time.Duration(2*len(toks)) + 5250*time.Millisecond
+			words = append(words, Word{Text: w, Start: at, End: at + 100*time.Millisecond})
+		}
+	}
+
+	result, err := Align(track, nil, words, Options{})
+	if err != nil {
+		t.Fatalf("Align() error = %v", err)
+	}
+	if result.Anchored != 10 {
+		t.Errorf("Anchored = %d, want 10", result.Anchored)
+	}
+	for i, shift := range result.Shifts {
+		if shift != 5300*time.Millisecond && shift != 5200*time.Millisecond {
+			t.Errorf("cue %d shift = %v, want 5.25s to the step", i+1, shift)
+		}
+	}
+}
+
+func TestAlignNothing(t *testing.T) {
+	if _, err := Align(sampleTrack(1), nil, nil, Options{}); err == nil {
+		t.Error("Align() without speech or words succeeded")
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("<i>Don't</i> go,\nJOHN! {\\an8}Now")
+	want := []string{"dont", "go", "john", "now"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenize() = %q, want %q", got, want)
+	}
+}
+
+func TestIncreasingRun(t *testing.T) {
+	xs := []int{3, 1, 4, 1, 5, 9, 2, 6}
+	run := increasingRun(xs)
+	if len(run) != 4 {
+		t.Fatalf("increasingRun() = %v, want 4 indexes", run)
+	}
+	for i := 1; i < len(run); i++ {
+		if run[i] <= run[i-1] || xs[run[i]] <= xs[run[i-1]] {
+			t.Errorf("increasingRun() = %v is not increasing", run)
+		}
+	}
+}
+
+func TestSnap(t *testing.T) {
+	cues := []subtitle.Cue{
+		{Start: 1100 * time.Millisecond, End: 2900 * time.Millisecond},
+		{Start: 2800 * time.Millisecond, End: 5 * time.Second},
+	}
+	speech := []Interval{{Start: time.Second, End: 3 * time.Second}, {Start: 10 * time.Second, End: 11 * time.Second}}
+	snap(cues, speech, 400*time.Millisecond)
+
+	if cues[0].Start != time.Second || cues[0].End != 2800*time.Millisecond {
+		t.Errorf("first cue = %v --> %v, want snapped and trimmed to the next cue", cues[0].Start, cues[0].End)
+	}
+	if cues[1].Start != 2800*time.Millisecond || cues[1].End != 5*time.Second {
+		t.Errorf("second cue = %v --> %v, want unchanged", cues[1].Start, cues[1].End)
+	}
+}
@@ -0,0 +1,79 @@
+// Package atomicfile writes output files through a temporary file in the same
+// directory that is renamed into place once complete, so an interrupted or
+// failed run never leaves a truncated output behind.
+package atomicfile
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Write calls write with a temporary path next to path and renames the
+// temporary file to path if write succeeds; otherwise it is removed. The
+// temporary path keeps the extension of path, so tools that pick the format
+// from the file name, such as ffmpeg, write the right format. The file gets
+// the mode of the file it replaces, or 0666 less the umask like any new file.
+func Write(path string, write func(tmp string) error) error {
+	return replace(path, 0, write)
+}
+
+// replace implements Write, setting the mode of the file to perm unless 0
+func replace(path string, perm os.FileMode, write func(tmp string) error) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := createTemp(dir, name)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+
+	if err := write(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if info, err := os.Stat(path); err == nil && perm == 0 {
+		perm = info.Mode().Perm()
+	}
+	if perm != 0 {
+		if err := os.Chmod(tmp, perm); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to set the mode of %s: %v", filepath.Base(path), err)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to move %s into place: %v", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// createTemp creates an empty temporary file for name in dir. Unlike
+// os.CreateTemp, which creates files readable by the owner only, the mode is
+// 0666 less the umask.
+func createTemp(dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	prefix := filepath.Join(dir, "."+strings.TrimSuffix(name, ext)+".tmp-")
+	for try := 0; ; try++ {
+		tmp := prefix + strconv.FormatUint(uint64(rand.Uint32()), 10) + ext
+		f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && try < 100 {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return tmp, f.Close()
+	}
+}
+
+// WriteFile writes data to path atomically with the given permissions
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return replace(path, perm, func(tmp string) error {
+		return os.WriteFile(tmp, data, perm)
+	})
+}
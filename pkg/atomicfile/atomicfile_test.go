@@ -0,0 +1,86 @@
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "talk.srt")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed write leaves the previous file and no temporary file
+	err := Write(path, func(tmp string) error {
+		if filepath.Dir(tmp) != dir || !strings.HasSuffix(tmp, ".srt") {
+			t.Errorf("temporary path %s is not an .srt next to the output", tmp)
+		}
+		os.WriteFile(tmp, []byte("partial"), 0644)
+		return errors.New("interrupted")
+	})
+	if err == nil || err.Error() != "interrupted" {
+		t.Errorf("Write() error = %v, want the write error", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old" {
+		t.Errorf("output = %q after a failed write", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary file left behind: %v", entries)
+	}
+
+	if err := WriteFile(path, []byte("new"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("output = %q, want new", data)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// Tools writing to the temporary path leave the mode to Write
+	fresh := filepath.Join(dir, "talk.vtt")
+	if err := Write(fresh, func(tmp string) error { return os.WriteFile(tmp, []byte("WEBVTT"), 0600) }); err != nil {
+		t.Fatal(err)
+	}
+	umask := 0777 &^ modeOf(t, createProbe(t, dir))
+	if got, want := modeOf(t, fresh), os.FileMode(0666)&^umask; got != want {
+		t.Errorf("new file mode = %v, want %v", got, want)
+	}
+
+	// A replaced file keeps its mode
+	if err := Write(path, func(tmp string) error { return os.WriteFile(tmp, []byte("newer"), 0644) }); err != nil {
+		t.Fatal(err)
+	}
+	if got := modeOf(t, path); got != 0600 {
+		t.Errorf("replaced file mode = %v, want 0600", got)
+	}
+}
+
+// createProbe creates a file with mode 0777, which the umask reduces
+func createProbe(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "probe")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	return path
+}
+
+func modeOf(t *testing.T, path string) os.FileMode {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
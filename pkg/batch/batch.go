@@ -0,0 +1,284 @@
+// Package batch runs a processing function over many media files with a worker pool.
+package batch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/atomicfile"
+	"github.com/gleicon/transcoder/pkg/logging"
+)
+
+// MediaExtensions lists the file extensions collected from directories
+var MediaExtensions = []string{
+	".mp4", ".mkv", ".mov", ".avi", ".webm",
+	".mp3", ".wav", ".m4a", ".ogg", ".flac",
+}
+
+// Item is an input file together with its path relative to the collected root,
+// which is mirrored into the output tree
+type Item struct {
+	Input string
+	Rel   string
+}
+
+// OutputPath returns the mirrored output path of the item under dir with the
+// input extension replaced by ext
+func (i Item) OutputPath(dir, ext string) string {
+	return filepath.Join(dir, strings.TrimSuffix(i.Rel, filepath.Ext(i.Rel))+ext)
+}
+
+// IsMedia reports whether path has one of the media extensions
+func IsMedia(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range MediaExtensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMeta reports whether path contains glob metacharacters
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// globRoot returns the leading directories of pattern that contain no glob metacharacters
+func globRoot(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for hasMeta(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// Collect expands directories, glob patterns and plain files into items. Sources
+// listed in manifest, one per line with '#' comments, are resolved relative to
+// the manifest's directory. Each input is returned once, in discovery order.
+func Collect(sources []string, manifest string) ([]Item, error) {
+	all := append([]string(nil), sources...)
+	if manifest != "" {
+		listed, err := readManifest(manifest)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, listed...)
+	}
+
+	var items []Item
+	seen := make(map[string]bool)
+	add := func(path, root string) error {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if seen[abs] {
+			return nil
+		}
+		seen[abs] = true
+		rel, err := filepath.Rel(root, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = filepath.Base(path)
+		}
+		items = append(items, Item{Input: path, Rel: rel})
+		return nil
+	}
+
+	for _, source := range all {
+		if hasMeta(source) {
+			matches, err := filepath.Glob(source)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", source, err)
+			}
+			root := globRoot(source)
+			for _, match := range matches {
+				if info, err := os.Stat(match); err != nil || info.IsDir() {
+					continue
+				}
+				if err := add(match, root); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		info, err := os.Stat(source)
+		if err != nil {
+			return nil, fmt.Errorf("input not found: %s", source)
+		}
+		if !info.IsDir() {
+			if err := add(source, filepath.Dir(source)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err = filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !IsMedia(path) {
+				return nil
+			}
+			return add(path, source)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %v", source, err)
+		}
+	}
+
+	return items, nil
+}
+
+// readManifest returns the sources listed in a manifest file
+func readManifest(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %v", err)
+	}
+	defer f.Close()
+
+	var sources []string
+	base := filepath.Dir(path)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(base, line)
+		}
+		sources = append(sources, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	return sources, nil
+}
+
+// Result is the outcome of processing a single item
+type Result struct {
+	Input    string        `json:"input"`
+	Output   string        `json:"output,omitempty"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Report summarizes a batch run
+type Report struct {
+	Started   time.Time     `json:"started"`
+	Duration  time.Duration `json:"duration"`
+	Total     int           `json:"total"`
+	Succeeded int           `json:"succeeded"`
+	Skipped   int           `json:"skipped"`
+	Failed    int           `json:"failed"`
+	Results   []Result      `json:"results"`
+}
+
+// ProcessFunc processes one item and returns the path of the output it wrote.
+// Returning ErrSkipped marks the item as skipped rather than failed.
+type ProcessFunc func(ctx context.Context, item Item) (string, error)
+
+// ErrSkipped is returned by a ProcessFunc to skip an item
+var ErrSkipped = errors.New("skipped")
+
+// Run processes items with the given number of workers and returns a report
+// with one result per item, in input order. Items not started before ctx is
+// cancelled are reported as failed with the context error.
+func Run(ctx context.Context, items []Item, workers int, process ProcessFunc) *Report {
+	if workers < 1 {
+		workers = 1
+	}
+
+	report := &Report{
+		Started: time.Now(),
+		Total:   len(items),
+		Results: make([]Result, len(items)),
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				report.Results[i] = runItem(ctx, items[i], process)
+			}
+		}()
+	}
+
+	for i := range items {
+		if ctx.Err() != nil {
+			report.Results[i] = Result{Input: items[i].Input, Error: ctx.Err().Error()}
+			continue
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			report.Results[i] = Result{Input: items[i].Input, Error: ctx.Err().Error()}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, r := range report.Results {
+		switch {
+		case r.Skipped:
+			report.Skipped++
+		case r.Error != "":
+			report.Failed++
+		default:
+			report.Succeeded++
+		}
+	}
+	report.Duration = time.Since(report.Started)
+	return report
+}
+
+// runItem processes a single item, recording its timing and outcome
+func runItem(ctx context.Context, item Item, process ProcessFunc) Result {
+	start := time.Now()
+	output, err := process(logging.WithAttrs(ctx, "input", item.Input), item)
+	result := Result{
+		Input:    item.Input,
+		Output:   output,
+		Duration: time.Since(start),
+	}
+	switch {
+	case errors.Is(err, ErrSkipped):
+		result.Skipped = true
+	case err != nil:
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// WriteJSON writes the report as indented JSON to path
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %v", err)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create report directory: %v", err)
+		}
+	}
+	if err := atomicfile.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write report: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func createFiles(t *testing.T, root string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("media"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func rels(items []Item) []string {
+	var out []string
+	for _, i := range items {
+		out = append(out, filepath.ToSlash(i.Rel))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestCollect(t *testing.T) {
+	root := t.TempDir()
+	createFiles(t, root,
+		"shows/ep1.mp4",
+		"shows/season2/ep2.MKV",
+		"shows/notes.txt",
+		"podcasts/a.mp3",
+		"podcasts/b.mp3",
+		"single.wav",
+	)
+	manifest := filepath.Join(root, "inputs.txt")
+	if err := os.WriteFile(manifest, []byte("# weekly\npodcasts/*.mp3\n\nsingle.wav\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		sources  []string
+		manifest string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:    "directory",
+			sources: []string{filepath.Join(root, "shows")},
+			want:    []string{"ep1.mp4", "season2/ep2.MKV"},
+		},
+		{
+			name:    "glob",
+			sources: []string{filepath.Join(root, "podcasts", "*.mp3")},
+			want:    []string{"a.mp3", "b.mp3"},
+		},
+		{
+			name:     "manifest with duplicates",
+			sources:  []string{filepath.Join(root, "single.wav")},
+			manifest: manifest,
+			want:     []string{"a.mp3", "b.mp3", "single.wav"},
+		},
+		{
+			name:    "missing input",
+			sources: []string{filepath.Join(root, "missing.mp4")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := Collect(tt.sources, tt.manifest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Collect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := strings.Join(rels(items), ","); !tt.wantErr && got != strings.Join(tt.want, ",") {
+				t.Errorf("Collect() = %s, want %s", got, strings.Join(tt.want, ","))
+			}
+		})
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	item := Item{Input: "/in/season2/ep2.mkv", Rel: filepath.Join("season2", "ep2.mkv")}
+	if got, want := item.OutputPath("/out", ".srt"), filepath.Join("/out", "season2", "ep2.srt"); got != want {
+		t.Errorf("OutputPath() = %s, want %s", got, want)
+	}
+}
+
+func TestRun(t *testing.T) {
+	items := []Item{
+		{Input: "a.mp3", Rel: "a.mp3"},
+		{Input: "b.mp3", Rel: "b.mp3"},
+		{Input: "c.mp3", Rel: "c.mp3"},
+		{Input: "d.mp3", Rel: "d.mp3"},
+	}
+
+	var running, peak int32
+	report := Run(context.Background(), items, 2, func(ctx context.Context, item Item) (string, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		switch item.Input {
+		case "b.mp3":
+			return "", errors.New("whisper failed")
+		case "c.mp3":
+			return "", ErrSkipped
+		}
+		return item.OutputPath("out", ".srt"), nil
+	})
+
+	if peak > 2 {
+		t.Errorf("Run() used %d concurrent workers, want at most 2", peak)
+	}
+	if report.Total != 4 || report.Succeeded != 2 || report.Failed != 1 || report.Skipped != 1 {
+		t.Errorf("Run() report = %+v", report)
+	}
+	if r := report.Results[1]; r.Input != "b.mp3" || r.Error != "whisper failed" {
+		t.Errorf("Run() result order or error wrong: %+v", r)
+	}
+	if r := report.Results[3]; r.Output != filepath.Join("out", "d.srt") {
+		t.Errorf("Run() output = %s", r.Output)
+	}
+
+	path := filepath.Join(t.TempDir(), "reports", "report.json")
+	if err := report.WriteJSON(path); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), `"failed": 1`) {
+		t.Errorf("WriteJSON() wrote %s, %v", data, err)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report := Run(ctx, []Item{{Input: "a.mp3"}, {Input: "b.mp3"}}, 1, func(ctx context.Context, item Item) (string, error) {
+		t.Errorf("process called for %s after cancellation", item.Input)
+		return "", nil
+	})
+	if report.Failed != 2 {
+		t.Errorf("Run() report = %+v, want 2 failures", report)
+	}
+}
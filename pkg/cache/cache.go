@@ -0,0 +1,294 @@
+// Package cache stores intermediate files such as extracted audio and
+// transcripts, keyed by a hash of the input content and the options that
+// produced them, so repeated runs over the same recording skip the work.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/atomicfile"
+)
+
+// DefaultMaxSize is the size limit of a cache opened without one
+const DefaultMaxSize = int64(10) << 30
+
+// DefaultDir returns the default cache location, e.g. ~/.cache/transcoder
+func DefaultDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = filepath.Join(os.TempDir(), "cache")
+	}
+	return filepath.Join(dir, "transcoder")
+}
+
+// fileHash memoizes the content hash of a file while it is unchanged
+type fileHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// Cache is a size-limited directory of files evicted least recently used first
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu     sync.Mutex // serializes eviction
+	hashMu sync.Mutex
+	hashes map[string]fileHash
+}
+
+// Open opens or creates a cache in dir holding at most maxSize bytes. A
+// maxSize of 0 uses DefaultMaxSize; a negative maxSize disables the limit.
+func Open(dir string, maxSize int64) (*Cache, error) {
+	if dir == "" {
+		dir = DefaultDir()
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	return &Cache{dir: dir, maxSize: maxSize, hashes: make(map[string]fileHash)}, nil
+}
+
+// Dir returns the cache directory
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Key derives a cache key from its parts, e.g. a stage name, an input hash
+// and the options that affect the result
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		io.WriteString(h, p)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// FileHash returns the SHA-256 of a file's content. Hashes are remembered
+// until the file's size or modification time changes.
+func (c *Cache) FileHash(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+
+	c.hashMu.Lock()
+	memo, ok := c.hashes[abs]
+	c.hashMu.Unlock()
+	if ok && memo.size == info.Size() && memo.modTime.Equal(info.ModTime()) {
+		return memo.sum, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %v", path, err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	c.hashMu.Lock()
+	c.hashes[abs] = fileHash{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	c.hashMu.Unlock()
+	return sum, nil
+}
+
+// path returns the location of an entry, sharded by the first byte of the key
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// Get copies the entry for key to dst and reports whether it was found. A hit
+// marks the entry as recently used.
+func (c *Cache) Get(key, dst string) (bool, error) {
+	src := c.path(key)
+	if _, err := os.Stat(src); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if dir := filepath.Dir(dst); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return false, err
+		}
+	}
+	err := atomicfile.Write(dst, func(tmp string) error {
+		return copyFile(src, tmp)
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Evicted between the check and the copy
+			return false, nil
+		}
+		return false, err
+	}
+	now := time.Now()
+	os.Chtimes(src, now, now)
+	return true, nil
+}
+
+// Put stores a copy of src under key, then evicts the least recently used
+// entries beyond the size limit
+func (c *Cache) Put(key, src string) error {
+	dst := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := copyFile(src, tmp.Name()); err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+
+	if c.maxSize > 0 {
+		if _, err := c.Prune(c.maxSize, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats describes the contents of a cache
+type Stats struct {
+	Entries int   `json:"entries"`
+	Size    int64 `json:"size"`
+}
+
+// entry is a cached file
+type entry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// entries lists the cached files, least recently used first
+func (c *Cache) entries() ([]entry, error) {
+	var list []entry
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		list = append(list, entry{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache: %v", err)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].modTime.Before(list[j].modTime) })
+	return list, nil
+}
+
+// Stats returns the number of entries and their total size
+func (c *Cache) Stats() (Stats, error) {
+	list, err := c.entries()
+	if err != nil {
+		return Stats{}, err
+	}
+	var s Stats
+	for _, e := range list {
+		s.Entries++
+		s.Size += e.size
+	}
+	return s, nil
+}
+
+// Prune removes entries not used for longer than maxAge (if maxAge > 0), then
+// the least recently used entries until the cache holds at most maxSize bytes
+// (if maxSize >= 0). It returns what was removed.
+func (c *Cache) Prune(maxSize int64, maxAge time.Duration) (Stats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list, err := c.entries()
+	if err != nil {
+		return Stats{}, err
+	}
+	var total int64
+	for _, e := range list {
+		total += e.size
+	}
+
+	var removed Stats
+	cutoff := time.Now().Add(-maxAge)
+	for _, e := range list {
+		expired := maxAge > 0 && e.modTime.Before(cutoff)
+		oversize := maxSize >= 0 && total > maxSize
+		if !expired && !oversize {
+			continue
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove cache entry: %v", err)
+		}
+		total -= e.size
+		removed.Entries++
+		removed.Size += e.size
+	}
+	return removed, nil
+}
+
+// copyFile copies src to dst, creating the directory of dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if dir := filepath.Dir(dst); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	if Key("audio", "abc") == Key("audioabc") {
+		t.Error("Key() does not separate its parts")
+	}
+	if Key("a", "b") != Key("a", "b") {
+		t.Error("Key() is not deterministic")
+	}
+}
+
+func TestFileHash(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.mp3")
+	b := filepath.Join(dir, "b.mp3")
+	writeFile(t, a, "same content")
+	writeFile(t, b, "same content")
+
+	c, err := Open(filepath.Join(dir, "cache"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ha, err := c.FileHash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb, _ := c.FileHash(b)
+	if ha != hb {
+		t.Error("identical files hash differently")
+	}
+
+	// A rewritten file is hashed again
+	writeFile(t, a, "different content")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(a, later, later)
+	if h, _ := c.FileHash(a); h == ha {
+		t.Error("FileHash() returned a stale hash after the file changed")
+	}
+}
+
+func TestGetPut(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(filepath.Join(dir, "cache"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key("transcript", "abc")
+	dst := filepath.Join(dir, "out", "talk.srt")
+
+	if ok, err := c.Get(key, dst); ok || err != nil {
+		t.Fatalf("Get() on an empty cache = %v, %v", ok, err)
+	}
+
+	src := filepath.Join(dir, "talk.srt")
+	writeFile(t, src, "1\n00:00:00,000 --> 00:00:01,000\nHello\n")
+	if err := c.Put(key, src); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	ok, err := c.Get(key, dst)
+	if !ok || err != nil {
+		t.Fatalf("Get() = %v, %v, want a hit", ok, err)
+	}
+	got, _ := os.ReadFile(dst)
+	if string(got) != "1\n00:00:00,000 --> 00:00:01,000\nHello\n" {
+		t.Errorf("Get() copied %q", got)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(filepath.Join(dir, "cache"), 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(dir, "src")
+	writeFile(t, src, "0123456789")
+	keys := []string{Key("a"), Key("b")}
+	for i, key := range keys {
+		if err := c.Put(key, src); err != nil {
+			t.Fatal(err)
+		}
+		// Make the access order unambiguous
+		at := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(c.path(key), at, at)
+	}
+
+	// Using a makes b the least recently used entry
+	if ok, _ := c.Get(keys[0], filepath.Join(dir, "out")); !ok {
+		t.Fatal("Get(a) missed")
+	}
+	if err := c.Put(Key("c"), src); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := c.Get(keys[1], filepath.Join(dir, "out")); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if ok, _ := c.Get(keys[0], filepath.Join(dir, "out")); !ok {
+		t.Error("recently used entry was evicted")
+	}
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 2 || stats.Size != 20 {
+		t.Errorf("Stats() = %+v, want 2 entries of 20 bytes", stats)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(filepath.Join(dir, "cache"), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "src")
+	writeFile(t, src, "0123456789")
+	old, recent := Key("old"), Key("recent")
+	c.Put(old, src)
+	c.Put(recent, src)
+	past := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(c.path(old), past, past)
+
+	removed, err := c.Prune(-1, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed.Entries != 1 || removed.Size != 10 {
+		t.Errorf("Prune(age) removed %+v, want 1 entry", removed)
+	}
+
+	removed, err = c.Prune(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed.Entries != 1 {
+		t.Errorf("Prune(0) removed %+v, want the remaining entry", removed)
+	}
+}
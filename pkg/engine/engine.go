@@ -0,0 +1,317 @@
+// Package engine runs processing stages as a directed acyclic graph. Stages
+// declare the typed artifacts they need and make; stages whose inputs are
+// ready run concurrently, a failure cancels the rest, and intermediate files
+// in the workspace are removed as soon as no remaining stage needs them.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/workspace"
+)
+
+// Kind is the type of an artifact
+type Kind string
+
+const (
+	KindMedia     Kind = "media"     // an audio or video container
+	KindAudio     Kind = "audio"     // a 16kHz mono WAV file for whisper
+	KindSubtitles Kind = "subtitles" // an SRT file
+	KindFile      Kind = "file"      // any other file
+)
+
+// Port declares an artifact a stage needs or makes
+type Port struct {
+	Name string
+	Kind Kind
+}
+
+// Artifact is a file produced by a stage or provided to the graph
+type Artifact struct {
+	Name string
+	Kind Kind
+	Path string
+}
+
+// StageFunc runs a stage. in holds the artifacts listed in Needs; dir is the
+// workspace directory for intermediate files. It returns the path of every
+// artifact listed in Makes.
+type StageFunc func(ctx context.Context, in map[string]Artifact, dir string) (map[string]string, error)
+
+// Stage is a node of the graph
+type Stage struct {
+	Name  string
+	Needs []Port
+	Makes []Port
+	Run   StageFunc
+}
+
+// Graph is a set of stages connected by the artifacts they exchange
+type Graph struct {
+	// Parallel limits the number of stages running at once, 0 means no limit
+	Parallel int
+	// Workspace holds the intermediate files. Its owner closes it; if nil,
+	// Run creates a temporary one and removes it when done.
+	Workspace *workspace.Workspace
+
+	stages   []Stage
+	provided map[string]Artifact
+}
+
+// New creates an empty graph
+func New() *Graph {
+	return &Graph{provided: make(map[string]Artifact)}
+}
+
+// Provide makes an existing file available to stages as an artifact
+func (g *Graph) Provide(name string, kind Kind, path string) {
+	g.provided[name] = Artifact{Name: name, Kind: kind, Path: path}
+}
+
+// Add adds a stage to the graph
+func (g *Graph) Add(stage Stage) {
+	g.stages = append(g.stages, stage)
+}
+
+// Stages returns the stages in the order they were added
+func (g *Graph) Stages() []Stage {
+	return append([]Stage(nil), g.stages...)
+}
+
+// Result describes a completed run
+type Result struct {
+	// Artifacts holds the artifacts stages wrote outside the workspace
+	Artifacts map[string]Artifact
+	// Timings holds the wall time of every stage
+	Timings map[string]time.Duration
+}
+
+// Validate checks that stage names are unique, every artifact has a single
+// source, every need is met by an artifact of the right kind and that the
+// stages form no cycle
+func (g *Graph) Validate() error {
+	_, err := g.plan()
+	return err
+}
+
+// plan validates the graph and returns, for every stage, the stages it depends on
+func (g *Graph) plan() ([][]int, error) {
+	names := make(map[string]bool)
+	producer := make(map[string]int)
+	kinds := make(map[string]Kind)
+	for name, a := range g.provided {
+		producer[name] = -1
+		kinds[name] = a.Kind
+	}
+	for i, s := range g.stages {
+		if s.Name == "" || s.Run == nil {
+			return nil, fmt.Errorf("stage %d has no name or function", i+1)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate stage %q", s.Name)
+		}
+		names[s.Name] = true
+		for _, p := range s.Makes {
+			if _, ok := producer[p.Name]; ok {
+				return nil, fmt.Errorf("artifact %q is made by more than one source", p.Name)
+			}
+			producer[p.Name] = i
+			kinds[p.Name] = p.Kind
+		}
+	}
+
+	deps := make([][]int, len(g.stages))
+	for i, s := range g.stages {
+		seen := make(map[int]bool)
+		for _, p := range s.Needs {
+			src, ok := producer[p.Name]
+			if !ok {
+				return nil, fmt.Errorf("stage %q needs %q, which nothing makes", s.Name, p.Name)
+			}
+			if kinds[p.Name] != p.Kind {
+				return nil, fmt.Errorf("stage %q needs %q as %s, but it is %s", s.Name, p.Name, p.Kind, kinds[p.Name])
+			}
+			if src >= 0 && !seen[src] {
+				seen[src] = true
+				deps[i] = append(deps[i], src)
+			}
+		}
+	}
+
+	// Kahn's algorithm: a cycle leaves stages that never become ready
+	pending := make([]int, len(g.stages))
+	dependents := make([][]int, len(g.stages))
+	var ready []int
+	for i, d := range deps {
+		pending[i] = len(d)
+		for _, src := range d {
+			dependents[src] = append(dependents[src], i)
+		}
+		if len(d) == 0 {
+			ready = append(ready, i)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, next := range dependents[i] {
+			if pending[next]--; pending[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+	if visited != len(g.stages) {
+		var cyclic []string
+		for i, p := range pending {
+			if p > 0 {
+				cyclic = append(cyclic, g.stages[i].Name)
+			}
+		}
+		sort.Strings(cyclic)
+		return nil, fmt.Errorf("stages form a cycle: %s", strings.Join(cyclic, ", "))
+	}
+	return deps, nil
+}
+
+// completion is the outcome of a stage run
+type completion struct {
+	stage    int
+	paths    map[string]string
+	err      error
+	duration time.Duration
+}
+
+// Run executes the graph. The first failing stage cancels the others and its
+// error is returned once they have stopped.
+func (g *Graph) Run(ctx context.Context) (*Result, error) {
+	deps, err := g.plan()
+	if err != nil {
+		return nil, err
+	}
+
+	ws := g.Workspace
+	if ws == nil {
+		if ws, err = workspace.Create(workspace.Config{}, 0); err != nil {
+			return nil, err
+		}
+		defer ws.Remove()
+	}
+	work := ws.Dir()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	artifacts := make(map[string]Artifact, len(g.provided))
+	for name, a := range g.provided {
+		artifacts[name] = a
+	}
+	// consumers counts the stages still to run that need each artifact
+	consumers := make(map[string]int)
+	for _, s := range g.stages {
+		for _, p := range s.Needs {
+			consumers[p.Name]++
+		}
+	}
+	pending := make([]int, len(g.stages))
+	dependents := make([][]int, len(g.stages))
+	var ready []int
+	for i, d := range deps {
+		pending[i] = len(d)
+		for _, src := range d {
+			dependents[src] = append(dependents[src], i)
+		}
+		if len(d) == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	result := &Result{Artifacts: make(map[string]Artifact), Timings: make(map[string]time.Duration)}
+	done := make(chan completion)
+	running := 0
+	var firstErr error
+
+	launch := func() {
+		for len(ready) > 0 && (g.Parallel <= 0 || running < g.Parallel) {
+			i := ready[0]
+			ready = ready[1:]
+			s := g.stages[i]
+			in := make(map[string]Artifact, len(s.Needs))
+			for _, p := range s.Needs {
+				in[p.Name] = artifacts[p.Name]
+			}
+			running++
+			go func() {
+				start := time.Now()
+				paths, err := s.Run(ctx, in, work)
+				done <- completion{stage: i, paths: paths, err: err, duration: time.Since(start)}
+			}()
+		}
+	}
+
+	launch()
+	for running > 0 {
+		c := <-done
+		running--
+		s := g.stages[c.stage]
+		result.Timings[s.Name] = c.duration
+
+		err := c.err
+		if err == nil {
+			for _, p := range s.Makes {
+				path, ok := c.paths[p.Name]
+				if !ok || path == "" {
+					err = fmt.Errorf("did not produce %q", p.Name)
+					break
+				}
+				artifacts[p.Name] = Artifact{Name: p.Name, Kind: p.Kind, Path: path}
+				if !within(work, path) {
+					result.Artifacts[p.Name] = artifacts[p.Name]
+				}
+			}
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", s.Name, err)
+				cancel()
+			}
+			continue
+		}
+
+		// Intermediate files are removed once nothing else needs them
+		for _, p := range s.Needs {
+			if consumers[p.Name]--; consumers[p.Name] == 0 && within(work, artifacts[p.Name].Path) {
+				os.Remove(artifacts[p.Name].Path)
+			}
+		}
+		for _, next := range dependents[c.stage] {
+			if pending[next]--; pending[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+		if firstErr == nil && ctx.Err() == nil {
+			launch()
+		}
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// within reports whether path is inside dir
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
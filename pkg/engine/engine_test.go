@@ -0,0 +1,293 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeStage returns a stage function that writes its name into a work file
+// for every artifact it makes
+func writeStage(makes ...string) StageFunc {
+	return func(ctx context.Context, in map[string]Artifact, dir string) (map[string]string, error) {
+		out := make(map[string]string)
+		for _, name := range makes {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+				return nil, err
+			}
+			out[name] = path
+		}
+		return out, nil
+	}
+}
+
+func TestValidate(t *testing.T) {
+	noop := writeStage()
+	tests := []struct {
+		name    string
+		stages  []Stage
+		wantErr string
+	}{
+		{
+			name: "valid",
+			stages: []Stage{
+				{Name: "extract", Needs: []Port{{"media", KindMedia}}, Makes: []Port{{"audio", KindAudio}}, Run: noop},
+				{Name: "transcribe", Needs: []Port{{"audio", KindAudio}}, Makes: []Port{{"srt", KindSubtitles}}, Run: noop},
+			},
+		},
+		{
+			name: "missing",
+			stages: []Stage{
+				{Name: "transcribe", Needs: []Port{{"audio", KindAudio}}, Run: noop},
+			},
+			wantErr: `needs "audio", which nothing makes`,
+		},
+		{
+			name: "kind",
+			stages: []Stage{
+				{Name: "transcribe", Needs: []Port{{"media", KindAudio}}, Run: noop},
+			},
+			wantErr: "needs \"media\" as audio, but it is media",
+		},
+		{
+			name: "duplicate producer",
+			stages: []Stage{
+				{Name: "a", Makes: []Port{{"audio", KindAudio}}, Run: noop},
+				{Name: "b", Makes: []Port{{"audio", KindAudio}}, Run: noop},
+			},
+			wantErr: "more than one source",
+		},
+		{
+			name: "duplicate name",
+			stages: []Stage{
+				{Name: "a", Run: noop},
+				{Name: "a", Run: noop},
+			},
+			wantErr: "duplicate stage",
+		},
+		{
+			name: "cycle",
+			stages: []Stage{
+				{Name: "a", Needs: []Port{{"y", KindFile}}, Makes: []Port{{"x", KindFile}}, Run: noop},
+				{Name: "b", Needs: []Port{{"x", KindFile}}, Makes: []Port{{"y", KindFile}}, Run: noop},
+			},
+			wantErr: "cycle: a, b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := New()
+			g.Provide("media", KindMedia, "talk.mp4")
+			for _, s := range tt.stages {
+				g.Add(s)
+			}
+			err := g.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunConcurrently(t *testing.T) {
+	g := New()
+	g.Provide("srt", KindSubtitles, "talk.srt")
+
+	// Both translations must be running at the same time to pass the barrier
+	var barrier sync.WaitGroup
+	barrier.Add(2)
+	translate := func(lang string) StageFunc {
+		return func(ctx context.Context, in map[string]Artifact, dir string) (map[string]string, error) {
+			barrier.Done()
+			waited := make(chan struct{})
+			go func() { barrier.Wait(); close(waited) }()
+			select {
+			case <-waited:
+			case <-time.After(5 * time.Second):
+				return nil, errors.New("stages did not run concurrently")
+			}
+			return writeStage(lang)(ctx, in, dir)
+		}
+	}
+	g.Add(Stage{Name: "translate(es)", Needs: []Port{{"srt", KindSubtitles}}, Makes: []Port{{"es", KindSubtitles}}, Run: translate("es")})
+	g.Add(Stage{Name: "translate(fr)", Needs: []Port{{"srt", KindSubtitles}}, Makes: []Port{{"fr", KindSubtitles}}, Run: translate("fr")})
+
+	var order []string
+	var mu sync.Mutex
+	g.Add(Stage{
+		Name:  "mux",
+		Needs: []Port{{"es", KindSubtitles}, {"fr", KindSubtitles}},
+		Run: func(ctx context.Context, in map[string]Artifact, dir string) (map[string]string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, name := range []string{"es", "fr"} {
+				data, err := os.ReadFile(in[name].Path)
+				if err != nil {
+					return nil, err
+				}
+				order = append(order, string(data))
+			}
+			return nil, nil
+		},
+	})
+
+	result, err := g.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if strings.Join(order, ",") != "es,fr" {
+		t.Errorf("mux read %v", order)
+	}
+	if len(result.Timings) != 3 {
+		t.Errorf("Timings = %v, want one per stage", result.Timings)
+	}
+}
+
+func TestRunCleanup(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "talk.srt")
+	var audio, work string
+
+	g := New()
+	g.Add(Stage{
+		Name:  "extract",
+		Makes: []Port{{"audio", KindAudio}},
+		Run: func(ctx context.Context, in map[string]Artifact, dir string) (map[string]string, error) {
+			work = dir
+			paths, err := writeStage("audio.wav")(ctx, in, dir)
+			audio = paths["audio.wav"]
+			return map[string]string{"audio": audio}, err
+		},
+	})
+	g.Add(Stage{
+		Name:  "transcribe",
+		Needs: []Port{{"audio", KindAudio}},
+		Makes: []Port{{"srt", KindSubtitles}},
+		Run: func(ctx context.Context, in map[string]Artifact, dir string) (map[string]string, error) {
+			return map[string]string{"srt": out}, os.WriteFile(out, []byte("srt"), 0644)
+		},
+	})
+	g.Add(Stage{
+		Name:  "check",
+		Needs: []Port{{"srt", KindSubtitles}},
+		Run: func(ctx context.Context, in map[string]Artifact, dir string) (map[string]string, error) {
+			if _, err := os.Stat(audio); !os.IsNotExist(err) {
+				return nil, errors.New("audio was kept after its last consumer finished")
+			}
+			return nil, nil
+		},
+	})
+
+	result, err := g.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if a, ok := result.Artifacts["srt"]; !ok || a.Path != out {
+		t.Errorf("Artifacts = %v, want the srt written outside the work directory", result.Artifacts)
+	}
+	if _, ok := result.Artifacts["audio"]; ok {
+		t.Error("intermediate audio reported as an output")
+	}
+	if _, err := os.Stat(work); !os.IsNotExist(err) {
+		t.Error("work directory was not removed")
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Errorf("output removed: %v", err)
+	}
+}
+
+func TestRunFailureCancels(t *testing.T) {
+	g := New()
+	cancelled := make(chan struct{})
+	g.Add(Stage{
+		Name: "slow",
+		Run: func(ctx context.Context, in map[string]Artifact, dir string) (map[string]string, error) {
+			select {
+			case <-ctx.Done():
+				close(cancelled)
+				return nil, ctx.Err()
+			case <-time.After(5 * time.Second):
+				return nil, nil
+			}
+		},
+	})
+	g.Add(Stage{
+		Name: "broken",
+		Run: func(ctx context.Context, in map[string]Artifact, dir string) (map[string]string, error) {
+			return nil, errors.New("whisper-cli crashed")
+		},
+	})
+	ran := false
+	g.Add(Stage{
+		Name:  "after",
+		Needs: []Port{{"x", KindFile}},
+		Run: func(ctx context.Context, in map[string]Artifact, dir string) (map[string]string, error) {
+			ran = true
+			return nil, nil
+		},
+	})
+	g.Add(Stage{Name: "make-x", Makes: []Port{{"x", KindFile}}, Run: func(ctx context.Context, in map[string]Artifact, dir string) (map[string]string, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}})
+
+	_, err := g.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "broken: whisper-cli crashed") {
+		t.Fatalf("Run() error = %v, want the failing stage's error", err)
+	}
+	select {
+	case <-cancelled:
+	default:
+		t.Error("running stage was not cancelled")
+	}
+	if ran {
+		t.Error("a dependent stage ran after a failure")
+	}
+}
+
+func TestRunMissingOutput(t *testing.T) {
+	g := New()
+	g.Add(Stage{Name: "extract", Makes: []Port{{"audio", KindAudio}}, Run: writeStage()})
+	_, err := g.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `did not produce "audio"`) {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestRunParallelLimit(t *testing.T) {
+	g := New()
+	g.Parallel = 1
+	var mu sync.Mutex
+	active, peak := 0, 0
+	for _, name := range []string{"a", "b", "c"} {
+		g.Add(Stage{Name: name, Run: func(ctx context.Context, in map[string]Artifact, dir string) (map[string]string, error) {
+			mu.Lock()
+			active++
+			peak = max(peak, active)
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			return nil, nil
+		}})
+	}
+	if _, err := g.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if peak != 1 {
+		t.Errorf("peak concurrency = %d, want 1", peak)
+	}
+}
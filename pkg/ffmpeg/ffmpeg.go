@@ -4,9 +4,18 @@ package ffmpeg
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/atomicfile"
+	"github.com/gleicon/transcoder/pkg/logging"
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/proc"
+	"github.com/gleicon/transcoder/pkg/subtitle"
 )
 
 // Progress represents the progress of an FFmpeg operation
@@ -16,7 +25,9 @@ type Progress struct {
 
 // FFmpeg represents an FFmpeg processor
 type FFmpeg struct {
-	Cmd *exec.Cmd // For testing purposes
+	Cmd    *exec.Cmd      // For testing purposes
+	Policy *policy.Policy // timeouts and retries of ffmpeg runs, nil for none
+	Logger *slog.Logger   // receives runs and, at debug level, ffmpeg output; defaults to slog.Default()
 }
 
 // New creates a new FFmpeg processor
@@ -27,7 +38,7 @@ func New() (*FFmpeg, error) {
 	}
 
 	// Initialize without a command - we'll create new commands for each operation
-	return &FFmpeg{}, nil
+	return &FFmpeg{Policy: policy.Default()}, nil
 }
 
 // NewWithCmd creates a new FFmpeg processor with the given command
@@ -59,24 +70,20 @@ func (f *FFmpeg) ExtractAudio(ctx context.Context, input, output string) error {
 	}
 
 	// Build ffmpeg command
-	args := []string{
-		"-i", input,
-		"-vn",                  // No video
-		"-acodec", "pcm_s16le", // PCM 16-bit
-		"-ar", "16000", // 16kHz sample rate
-		"-ac", "1", // Mono audio
-		"-y", // Overwrite output file
-		output,
+	args := func(output string) []string {
+		return []string{
+			"-i", input,
+			"-vn",                  // No video
+			"-acodec", "pcm_s16le", // PCM 16-bit
+			"-ar", "16000", // 16kHz sample rate
+			"-ac", "1", // Mono audio
+			"-y", // Overwrite output file
+			output,
+		}
 	}
 
-	// Create a new command for this operation
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	// Run the command
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to extract audio: %v", err)
+	if err := f.run(ctx, policy.Extract, input, output, args); err != nil {
+		return fmt.Errorf("failed to extract audio: %w", err)
 	}
 
 	return nil
@@ -103,26 +110,279 @@ func (f *FFmpeg) ChangeSpeed(ctx context.Context, input, output string, speed fl
 	}
 
 	// Build ffmpeg command
-	args := []string{
+	args := func(output string) []string {
+		return []string{
+			"-i", input,
+			"-filter:v", fmt.Sprintf("setpts=PTS/%f", speed),
+			"-y", // Overwrite output file
+			output,
+		}
+	}
+
+	if err := f.run(ctx, policy.Encode, input, output, args); err != nil {
+		return fmt.Errorf("failed to change video speed: %w", err)
+	}
+
+	return nil
+}
+
+// DefaultLoudness is the integrated loudness target of NormalizeAudio in LUFS
+const DefaultLoudness = -16.0
+
+// NormalizeAudio evens out the loudness of input's audio with the EBU R128
+// loudnorm filter, copying any video stream unchanged. A loudness of 0 uses
+// DefaultLoudness.
+func (f *FFmpeg) NormalizeAudio(ctx context.Context, input, output string, loudness float64) error {
+	// Validate input file
+	if _, err := os.Stat(input); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("input file not found: %s", input)
+		}
+		return fmt.Errorf("error checking input file: %v", err)
+	}
+
+	// Ensure output directory exists
+	if err := EnsureOutputDir(output); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	args := func(output string) []string {
+		return normalizeArgs(input, output, loudness)
+	}
+	if err := f.run(ctx, policy.Encode, input, output, args); err != nil {
+		return fmt.Errorf("failed to normalize audio: %w", err)
+	}
+
+	return nil
+}
+
+// normalizeArgs builds the ffmpeg arguments for NormalizeAudio
+func normalizeArgs(input, output string, loudness float64) []string {
+	if loudness == 0 {
+		loudness = DefaultLoudness
+	}
+	return []string{
 		"-i", input,
-		"-filter:v", fmt.Sprintf("setpts=PTS/%f", speed),
+		"-c:v", "copy", // Only the audio is re-encoded
+		"-af", fmt.Sprintf("loudnorm=I=%g:TP=-1.5:LRA=11", loudness),
 		"-y", // Overwrite output file
 		output,
 	}
+}
 
-	// Create a new command for this operation
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// BurnSubtitles renders a subtitle file into the video frames of input with
+// the default ASS style
+func (f *FFmpeg) BurnSubtitles(ctx context.Context, input, subtitles, output string) error {
+	return f.BurnSubtitlesWith(ctx, input, subtitles, output, subtitle.ASSOptions{})
+}
 
-	// Run the command
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to change video speed: %v", err)
+// BurnSubtitlesWith renders a subtitle file into the video frames of input.
+// Subtitles in other formats than ASS are converted to ASS with the styles
+// of opts first, so they look the same whatever ffmpeg build renders them;
+// ASS files keep their own styles. The canvas of opts defaults to 1080 lines
+// at the aspect ratio of the video.
+func (f *FFmpeg) BurnSubtitlesWith(ctx context.Context, input, subtitles, output string, opts subtitle.ASSOptions) error {
+	// Validate input files
+	for _, path := range []string{input, subtitles} {
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("input file not found: %s", path)
+			}
+			return fmt.Errorf("error checking input file: %v", err)
+		}
+	}
+
+	// Ensure output directory exists
+	if err := EnsureOutputDir(output); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	if format, _ := subtitle.FormatFromPath(subtitles); format != subtitle.FormatASS {
+		ass, err := f.convertToASS(ctx, input, subtitles, opts)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(ass)
+		subtitles = ass
+	}
+
+	// Build ffmpeg command
+	args := func(output string) []string {
+		return []string{
+			"-i", input,
+			"-vf", "ass=" + escapeFilterPath(subtitles),
+			"-c:a", "copy", // Keep the original audio
+			"-y", // Overwrite output file
+			output,
+		}
+	}
+
+	if err := f.run(ctx, policy.Encode, input, output, args); err != nil {
+		return fmt.Errorf("failed to burn subtitles: %w", err)
+	}
+
+	return nil
+}
+
+// convertToASS writes subtitles to a temporary ASS file styled by opts and
+// returns its path
+func (f *FFmpeg) convertToASS(ctx context.Context, input, subtitles string, opts subtitle.ASSOptions) (string, error) {
+	track, err := subtitle.ReadFile(subtitles)
+	if err != nil {
+		return "", err
+	}
+	if opts.PlayResX <= 0 || opts.PlayResY <= 0 {
+		// Fonts are sized against the height; a canvas of the video's
+		// aspect ratio keeps margins and positions in proportion
+		opts.PlayResX, opts.PlayResY = 1920, 1080
+		if probe, err := f.Probe(ctx, input); err == nil {
+			for _, s := range probe.Streams {
+				if s.CodecType == "video" && s.Width > 0 && s.Height > 0 {
+					opts.PlayResX = opts.PlayResY * s.Width / s.Height
+					break
+				}
+			}
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "transcoder-*.ass")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	err = subtitle.WriteASSWith(tmp, track, opts)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write ASS subtitles: %v", err)
+	}
+	return tmp.Name(), nil
+}
+
+// SubtitleStream is a subtitle file to be muxed into a container
+type SubtitleStream struct {
+	Path     string
+	Language string // ISO 639 code stored as stream metadata, optional
+}
+
+// MuxSubtitles adds subtitle streams to input without re-encoding audio or video
+func (f *FFmpeg) MuxSubtitles(ctx context.Context, input, output string, subtitles []SubtitleStream) error {
+	if len(subtitles) == 0 {
+		return fmt.Errorf("at least one subtitle file is required")
+	}
+
+	// Validate input files
+	paths := []string{input}
+	for _, s := range subtitles {
+		paths = append(paths, s.Path)
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("input file not found: %s", path)
+			}
+			return fmt.Errorf("error checking input file: %v", err)
+		}
+	}
+
+	// Ensure output directory exists
+	if err := EnsureOutputDir(output); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	args := func(output string) []string {
+		return muxArgs(input, output, subtitles)
+	}
+	if err := f.run(ctx, policy.Encode, input, output, args); err != nil {
+		return fmt.Errorf("failed to mux subtitles: %w", err)
 	}
 
 	return nil
 }
 
+// muxArgs builds the ffmpeg arguments for MuxSubtitles
+func muxArgs(input, output string, subtitles []SubtitleStream) []string {
+	args := []string{"-i", input}
+	for _, s := range subtitles {
+		args = append(args, "-i", s.Path)
+	}
+
+	args = append(args, "-map", "0")
+	for i := range subtitles {
+		args = append(args, "-map", fmt.Sprintf("%d", i+1))
+	}
+
+	// MP4 and MOV only accept mov_text subtitles, Matroska takes SRT as is
+	codec := "srt"
+	switch strings.ToLower(filepath.Ext(output)) {
+	case ".mp4", ".m4v", ".mov":
+		codec = "mov_text"
+	case ".webm":
+		codec = "webvtt"
+	}
+	args = append(args, "-c", "copy", "-c:s", codec)
+
+	for i, s := range subtitles {
+		if s.Language != "" {
+			args = append(args, fmt.Sprintf("-metadata:s:s:%d", i), "language="+s.Language)
+		}
+	}
+
+	return append(args, "-y", output)
+}
+
+// run runs ffmpeg with the arguments built for a temporary output, which is
+// renamed to output once ffmpeg succeeds. Attempts are limited and retried
+// by the policy for op, scaled by the duration of input.
+func (f *FFmpeg) run(ctx context.Context, op policy.Operation, input, output string, args func(output string) []string) error {
+	logger := logging.From(ctx, f.Logger).With("tool", "ffmpeg", "op", string(op))
+	return f.Policy.Run(ctx, op, f.duration(ctx, op, input), func(ctx context.Context) error {
+		return atomicfile.Write(output, func(tmp string) error {
+			cmd := proc.Command(ctx, "ffmpeg", args(tmp)...)
+			out := logging.NewWriter(ctx, logger, slog.LevelDebug, "ffmpeg output")
+			defer out.Close()
+			cmd.Stdout = out
+			cmd.Stderr = out
+
+			logger.Debug("running ffmpeg", "cmd", cmd.String())
+			start := time.Now()
+			err := proc.Run(cmd)
+			if err != nil {
+				logger.Warn("ffmpeg failed", "cmd", cmd.String(), "duration", time.Since(start), "error", err)
+				return err
+			}
+			logger.Debug("ffmpeg finished", "output", output, "duration", time.Since(start))
+			return nil
+		})
+	})
+}
+
+// probeTimeout bounds the probe that finds the duration of an input
+const probeTimeout = time.Minute
+
+// duration returns the duration of input if the timeout of op depends on it,
+// 0 if it does not or the duration is unknown
+func (f *FFmpeg) duration(ctx context.Context, op policy.Operation, input string) time.Duration {
+	if f.Policy == nil || f.Policy.Timeouts[op].Factor == 0 {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	probe, err := f.Probe(ctx, input)
+	if err != nil {
+		return 0
+	}
+	return probe.Duration
+}
+
+// escapeFilterPath escapes a file path for use as a filter option value
+// inside a filtergraph, which ffmpeg unescapes twice
+func escapeFilterPath(path string) string {
+	option := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(path)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(option)
+}
+
 // EnsureOutputDir ensures the output directory exists
 func EnsureOutputDir(output string) error {
 	dir := filepath.Dir(output)
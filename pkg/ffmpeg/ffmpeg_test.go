@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/gleicon/transcoder/pkg/subtitle"
 )
 
 // mockCommand is a helper function to create a mock command for testing
@@ -229,3 +231,88 @@ func TestEnsureOutputDir(t *testing.T) {
 		t.Error("Directory was not created")
 	}
 }
+
+func TestMuxArgs(t *testing.T) {
+	subtitles := []SubtitleStream{
+		{Path: "talk.en.srt", Language: "eng"},
+		{Path: "talk.fr.srt"},
+	}
+
+	tests := []struct {
+		name   string
+		output string
+		codec  string
+	}{
+		{name: "mp4", output: "out.mp4", codec: "mov_text"},
+		{name: "mkv", output: "out.mkv", codec: "srt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(muxArgs("talk.mp4", tt.output, subtitles), " ")
+			want := "-i talk.mp4 -i talk.en.srt -i talk.fr.srt -map 0 -map 1 -map 2 -c copy -c:s " + tt.codec +
+				" -metadata:s:s:0 language=eng -y " + tt.output
+			if got != want {
+				t.Errorf("muxArgs() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNormalizeArgs(t *testing.T) {
+	got := strings.Join(normalizeArgs("talk.mp4", "norm.mp4", 0), " ")
+	want := "-i talk.mp4 -c:v copy -af loudnorm=I=-16:TP=-1.5:LRA=11 -y norm.mp4"
+	if got != want {
+		t.Errorf("normalizeArgs() = %q, want %q", got, want)
+	}
+
+	got = strings.Join(normalizeArgs("talk.mp4", "norm.mp4", -23), " ")
+	if !strings.Contains(got, "loudnorm=I=-23:") {
+		t.Errorf("normalizeArgs() ignored the loudness target: %q", got)
+	}
+}
+
+func TestEscapeFilterPath(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "/tmp/subs.srt", want: "/tmp/subs.srt"},
+		{input: `C:\subs.srt`, want: `C\\:\\\\subs.srt`},
+		{input: "it's [1].srt", want: `it\\\'s \[1\].srt`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeFilterPath(tt.input); got != tt.want {
+			t.Errorf("escapeFilterPath(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestConvertToASS(t *testing.T) {
+	dir := t.TempDir()
+	srt := filepath.Join(dir, "talk.srt")
+	if err := os.WriteFile(srt, []byte("1\n00:00:01,000 --> 00:00:02,000\nHello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The video cannot be probed here, so the canvas keeps its default size
+	f := &FFmpeg{}
+	style := subtitle.DefaultASSStyle()
+	style.Alignment = 8
+	ass, err := f.convertToASS(context.Background(), filepath.Join(dir, "missing.mp4"), srt, subtitle.ASSOptions{Styles: []subtitle.ASSStyle{style}})
+	if err != nil {
+		t.Fatalf("convertToASS() error = %v", err)
+	}
+	defer os.Remove(ass)
+
+	data, err := os.ReadFile(ass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"PlayResX: 1920\n", ",8,80,80,60,1\n", "Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,Hello\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("converted subtitles lack %q:\n%s", want, data)
+		}
+	}
+}
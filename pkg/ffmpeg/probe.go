@@ -0,0 +1,142 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/proc"
+)
+
+// Stream describes a single stream of a media file
+type Stream struct {
+	Index      int    `json:"index"`
+	CodecType  string `json:"codec_type"` // "audio", "video", "subtitle", ...
+	CodecName  string `json:"codec_name"`
+	Language   string `json:"language,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+}
+
+// ProbeResult describes a media file as reported by ffprobe
+type ProbeResult struct {
+	Format   string        `json:"format"`
+	Duration time.Duration `json:"duration"`
+	BitRate  int64         `json:"bit_rate,omitempty"`
+	Streams  []Stream      `json:"streams"`
+}
+
+// HasVideo reports whether the file contains a video stream
+func (p *ProbeResult) HasVideo() bool {
+	return p.hasStream("video")
+}
+
+// HasAudio reports whether the file contains an audio stream
+func (p *ProbeResult) HasAudio() bool {
+	return p.hasStream("audio")
+}
+
+func (p *ProbeResult) hasStream(codecType string) bool {
+	for _, s := range p.Streams {
+		if s.CodecType == codecType {
+			return true
+		}
+	}
+	return false
+}
+
+// ffprobeOutput mirrors the subset of `ffprobe -print_format json` we use
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		Index      int               `json:"index"`
+		CodecType  string            `json:"codec_type"`
+		CodecName  string            `json:"codec_name"`
+		Channels   int               `json:"channels"`
+		SampleRate string            `json:"sample_rate"`
+		Width      int               `json:"width"`
+		Height     int               `json:"height"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"streams"`
+}
+
+// parseProbe converts ffprobe JSON output into a ProbeResult
+func parseProbe(data []byte) (*ProbeResult, error) {
+	var out ffprobeOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+
+	result := &ProbeResult{Format: out.Format.FormatName}
+	if out.Format.Duration != "" {
+		seconds, err := strconv.ParseFloat(out.Format.Duration, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q: %v", out.Format.Duration, err)
+		}
+		result.Duration = time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+	}
+	if out.Format.BitRate != "" {
+		result.BitRate, _ = strconv.ParseInt(out.Format.BitRate, 10, 64)
+	}
+
+	for _, s := range out.Streams {
+		stream := Stream{
+			Index:     s.Index,
+			CodecType: s.CodecType,
+			CodecName: s.CodecName,
+			Language:  s.Tags["language"],
+			Channels:  s.Channels,
+			Width:     s.Width,
+			Height:    s.Height,
+		}
+		if s.SampleRate != "" {
+			stream.SampleRate, _ = strconv.Atoi(s.SampleRate)
+		}
+		result.Streams = append(result.Streams, stream)
+	}
+
+	return result, nil
+}
+
+// Probe inspects a media file with ffprobe
+func (f *FFmpeg) Probe(ctx context.Context, input string) (*ProbeResult, error) {
+	// Validate input file
+	if _, err := os.Stat(input); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("input file not found: %s", input)
+		}
+		return nil, fmt.Errorf("error checking input file: %v", err)
+	}
+
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return nil, fmt.Errorf("ffprobe command not found. Please install it using: brew install ffmpeg")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := proc.Command(ctx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		input,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to probe media: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return parseProbe(stdout.Bytes())
+}
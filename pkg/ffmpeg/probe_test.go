@@ -0,0 +1,48 @@
+package ffmpeg
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleProbe = `{
+	"streams": [
+		{"index": 0, "codec_type": "video", "codec_name": "h264", "width": 1280, "height": 720},
+		{"index": 1, "codec_type": "audio", "codec_name": "aac", "channels": 2, "sample_rate": "44100", "tags": {"language": "eng"}}
+	],
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "30.041667", "bit_rate": "1048576"}
+}`
+
+func TestParseProbe(t *testing.T) {
+	result, err := parseProbe([]byte(sampleProbe))
+	if err != nil {
+		t.Fatalf("parseProbe() error = %v", err)
+	}
+
+	if result.Duration != 30042*time.Millisecond {
+		t.Errorf("parseProbe() duration = %v", result.Duration)
+	}
+	if result.BitRate != 1048576 {
+		t.Errorf("parseProbe() bit rate = %d", result.BitRate)
+	}
+	if !result.HasVideo() || !result.HasAudio() {
+		t.Errorf("parseProbe() streams = %+v", result.Streams)
+	}
+	if audio := result.Streams[1]; audio.SampleRate != 44100 || audio.Channels != 2 || audio.Language != "eng" {
+		t.Errorf("parseProbe() audio stream = %+v", audio)
+	}
+
+	if _, err := parseProbe([]byte("not json")); err == nil {
+		t.Error("parseProbe() expected error for invalid output")
+	}
+}
+
+func TestProbeMissingInput(t *testing.T) {
+	f := &FFmpeg{}
+	_, err := f.Probe(context.Background(), "nonexistent.mp4")
+	if err == nil || !strings.Contains(err.Error(), "input file not found") {
+		t.Errorf("Probe() error = %v, want input file not found", err)
+	}
+}
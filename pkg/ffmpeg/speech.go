@@ -0,0 +1,143 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/logging"
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/proc"
+)
+
+// Interval is a span of time within a media file
+type Interval struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// SpeechOptions tune DetectSpeech. Zero values use the defaults.
+type SpeechOptions struct {
+	Noise      float64       // level in dB below which audio is silence, default -35
+	MinSilence time.Duration // shortest pause that separates speech, default 300ms
+}
+
+// DetectSpeech returns the intervals of input that are not silent, found with
+// ffmpeg's silencedetect filter. It is a simple voice activity detector that
+// works best on dialogue without loud music or background noise.
+func (f *FFmpeg) DetectSpeech(ctx context.Context, input string, opts SpeechOptions) ([]Interval, error) {
+	if _, err := os.Stat(input); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("input file not found: %s", input)
+		}
+		return nil, fmt.Errorf("error checking input file: %v", err)
+	}
+	if opts.Noise == 0 {
+		opts.Noise = -35
+	}
+	if opts.MinSilence <= 0 {
+		opts.MinSilence = 300 * time.Millisecond
+	}
+
+	logger := logging.From(ctx, f.Logger).With("tool", "ffmpeg", "op", string(policy.Extract))
+	var speech []Interval
+	err := f.Policy.Run(ctx, policy.Extract, f.duration(ctx, policy.Extract, input), func(ctx context.Context) error {
+		var stderr bytes.Buffer
+		out := logging.NewWriter(ctx, logger, slog.LevelDebug, "ffmpeg output")
+		defer out.Close()
+		cmd := proc.Command(ctx, "ffmpeg",
+			"-i", input,
+			"-vn",
+			"-af", fmt.Sprintf("silencedetect=noise=%gdB:d=%g", opts.Noise, opts.MinSilence.Seconds()),
+			"-f", "null", "-",
+		)
+		cmd.Stderr = io.MultiWriter(&stderr, out)
+		logger.Debug("running ffmpeg", "cmd", cmd.String())
+		if err := proc.Run(cmd); err != nil {
+			return err
+		}
+		var err error
+		speech, err = parseSilence(&stderr)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect speech: %w", err)
+	}
+	return speech, nil
+}
+
+var (
+	durationRe     = regexp.MustCompile(`Duration: (\d+):(\d+):(\d+(?:\.\d+)?)`)
+	silenceStartRe = regexp.MustCompile(`silence_start: (-?\d+(?:\.\d+)?)`)
+	silenceEndRe   = regexp.MustCompile(`silence_end: (-?\d+(?:\.\d+)?)`)
+	timeRe         = regexp.MustCompile(`time=(\d+):(\d+):(\d+(?:\.\d+)?)`)
+)
+
+// parseSilence turns the silencedetect log of ffmpeg into the intervals
+// between silences, up to the end of the media
+func parseSilence(r io.Reader) ([]Interval, error) {
+	var (
+		speech   []Interval
+		start    time.Duration // start of the current speech
+		silent   bool
+		duration time.Duration
+	)
+	seconds := func(s string) time.Duration {
+		v, _ := strconv.ParseFloat(s, 64)
+		return max(time.Duration(v*float64(time.Second)), 0).Round(time.Millisecond)
+	}
+	clock := func(m []string) time.Duration {
+		h, _ := strconv.Atoi(m[1])
+		min, _ := strconv.Atoi(m[2])
+		return time.Duration(h)*time.Hour + time.Duration(min)*time.Minute + seconds(m[3])
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := durationRe.FindStringSubmatch(line); m != nil && duration == 0 {
+			duration = clock(m)
+		}
+		// The progress line holds how far decoding got, which is more exact
+		// than the container duration
+		if m := timeRe.FindStringSubmatch(line); m != nil {
+			duration = max(duration, clock(m))
+		}
+		if m := silenceStartRe.FindStringSubmatch(line); m != nil && !silent {
+			if at := seconds(m[1]); at > start {
+				speech = append(speech, Interval{Start: start, End: at})
+			}
+			silent = true
+		}
+		if m := silenceEndRe.FindStringSubmatch(line); m != nil && silent {
+			start = seconds(m[1])
+			silent = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !silent && duration > start {
+		speech = append(speech, Interval{Start: start, End: duration})
+	}
+	return speech, nil
+}
+
+// scanLines splits on both newlines and the carriage returns of progress lines
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
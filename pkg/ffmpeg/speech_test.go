@@ -0,0 +1,47 @@
+package ffmpeg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSilence(t *testing.T) {
+	log := `Input #0, wav, from 'talk.wav':
+  Duration: 00:00:20.00, bitrate: 256 kb/s
+[silencedetect @ 0x600] silence_start: 0
+[silencedetect @ 0x600] silence_end: 1.5 | silence_duration: 1.5
+size=N/A time=00:00:09.50 bitrate=N/A speed= 900x` + "\r" + `[silencedetect @ 0x600] silence_start: 4.25
+[silencedetect @ 0x600] silence_end: 6 | silence_duration: 1.75
+[silencedetect @ 0x600] silence_start: 12.5
+[silencedetect @ 0x600] silence_end: 13 | silence_duration: 0.5
+size=N/A time=00:00:20.04 bitrate=N/A speed= 950x
+`
+	got, err := parseSilence(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Interval{
+		{Start: 1500 * time.Millisecond, End: 4250 * time.Millisecond},
+		{Start: 6 * time.Second, End: 12500 * time.Millisecond},
+		{Start: 13 * time.Second, End: 20040 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSilence() = %v, want %v", got, want)
+	}
+}
+
+func TestParseSilenceTrailing(t *testing.T) {
+	log := `  Duration: 00:00:10.00, bitrate: 256 kb/s
+[silencedetect @ 0x600] silence_start: 8
+`
+	got, err := parseSilence(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Interval{{Start: 0, End: 8 * time.Second}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSilence() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,230 @@
+// Package hallucination removes text whisper made up from a transcript.
+// Whisper fills music and silence with phantom text: the last phrase it
+// heard repeated over and over, boilerplate from the video subtitles it was
+// trained on ("Thank you for watching", "Subtitles by the Amara.org
+// community"), and words it is unsure of. Cues are removed when they repeat
+// the cues before them, hold a known boilerplate phrase, fall on silence, or
+// were transcribed with a low probability, and every removal is reported.
+package hallucination
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gleicon/transcoder/pkg/subtitle"
+)
+
+// Interval is a span of speech
+type Interval struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Score is the probability whisper gave the text it transcribed for a span
+// of the audio, such as a segment or a word
+type Score struct {
+	Start       time.Duration
+	End         time.Duration
+	Probability float64
+}
+
+// Reasons a cue is removed for
+const (
+	Repeated       = "repeated"
+	Boilerplate    = "boilerplate"
+	Silence        = "silence"
+	LowProbability = "low-probability"
+)
+
+// DefaultPhrases are boilerplate whisper is known to make up, compared with
+// cue text in lower case without punctuation
+var DefaultPhrases = []string{
+	"thank you for watching",
+	"thanks for watching",
+	"thank you so much for watching",
+	"thank you very much for watching",
+	"please subscribe",
+	"subscribe to my channel",
+	"like and subscribe",
+	"dont forget to subscribe",
+	"see you in the next video",
+	"subtitles by the amara org community",
+	"amara org",
+	"blank audio",
+}
+
+// Options tune Filter. Zero values use the defaults.
+type Options struct {
+	// Repeats is the number of consecutive cues with the same text from
+	// which all but the first are removed, default 3. Speakers do repeat
+	// themselves once.
+	Repeats int
+	// Phrases are removed wherever they make up a cue, default
+	// DefaultPhrases; a nil slice uses the defaults and an empty one none
+	Phrases []string
+	// Speech are the intervals of speech in the audio. Cues that overlap
+	// speech for less than MinSpeech of their duration are removed. Nil
+	// skips the check.
+	Speech    []Interval
+	MinSpeech float64 // default 0.1
+	// Scores are the probabilities of the transcribed text. Cues whose
+	// mean probability, weighted by overlap, is below MinProbability are
+	// removed. Nil skips the check.
+	Scores         []Score
+	MinProbability float64 // default 0.4
+}
+
+func (o Options) withDefaults() Options {
+	if o.Repeats <= 1 {
+		o.Repeats = 3
+	}
+	if o.Phrases == nil {
+		o.Phrases = DefaultPhrases
+	}
+	if o.MinSpeech <= 0 {
+		o.MinSpeech = 0.1
+	}
+	if o.MinProbability <= 0 {
+		o.MinProbability = 0.4
+	}
+	return o
+}
+
+// Removal is a cue removed by Filter
+type Removal struct {
+	Cue    int // 1-based position in the track given to Filter
+	Start  time.Duration
+	End    time.Duration
+	Text   string
+	Reason string
+}
+
+func (r Removal) String() string {
+	return fmt.Sprintf("cue %d at %s (%s): %q", r.Cue, r.Start, r.Reason, strings.ReplaceAll(r.Text, "\n", " "))
+}
+
+// Result is the outcome of Filter
+type Result struct {
+	Track   *subtitle.Track // the cues that were kept, renumbered
+	Removed []Removal
+}
+
+// Filter removes the cues of track that look made up. The track itself is
+// not modified.
+func Filter(track *subtitle.Track, opts Options) *Result {
+	opts = opts.withDefaults()
+	phrases := make([]string, 0, len(opts.Phrases))
+	for _, p := range opts.Phrases {
+		if p = normalize(p); p != "" {
+			phrases = append(phrases, p)
+		}
+	}
+
+	cues := track.Cues
+	reasons := make([]string, len(cues))
+
+	// Loops first, so that the cue starting one is kept unless it is
+	// removed on its own account
+	for i := 0; i < len(cues); {
+		key := normalize(cues[i].Text)
+		j := i + 1
+		for j < len(cues) && key != "" && normalize(cues[j].Text) == key {
+			j++
+		}
+		if j-i >= opts.Repeats {
+			for k := i + 1; k < j; k++ {
+				reasons[k] = Repeated
+			}
+		}
+		i = j
+	}
+
+	for i, c := range cues {
+		if reasons[i] != "" {
+			continue
+		}
+		switch {
+		case matchesPhrase(normalize(c.Text), phrases):
+			reasons[i] = Boilerplate
+		case opts.Speech != nil && speechFraction(c, opts.Speech) < opts.MinSpeech:
+			reasons[i] = Silence
+		case opts.Scores != nil && lowProbability(c, opts.Scores, opts.MinProbability):
+			reasons[i] = LowProbability
+		}
+	}
+
+	result := &Result{Track: &subtitle.Track{}}
+	for i, c := range cues {
+		if reasons[i] == "" {
+			result.Track.Cues = append(result.Track.Cues, c)
+			continue
+		}
+		result.Removed = append(result.Removed, Removal{Cue: i + 1, Start: c.Start, End: c.End, Text: c.Text, Reason: reasons[i]})
+	}
+	result.Track.Renumber()
+	return result
+}
+
+// matchesPhrase reports whether text consists of one of phrases, allowing a
+// couple of other words around it such as "Thank you for watching, bye"
+func matchesPhrase(text string, phrases []string) bool {
+	if text == "" {
+		return false
+	}
+	words := len(strings.Fields(text))
+	padded := " " + text + " "
+	for _, p := range phrases {
+		if strings.Contains(padded, " "+p+" ") && words-len(strings.Fields(p)) <= 2 {
+			return true
+		}
+	}
+	return false
+}
+
+// speechFraction returns how much of the cue overlaps speech
+func speechFraction(c subtitle.Cue, speech []Interval) float64 {
+	if c.End <= c.Start {
+		return 0
+	}
+	var covered time.Duration
+	for _, s := range speech {
+		if s.End > c.Start && s.Start < c.End {
+			covered += min(s.End, c.End) - max(s.Start, c.Start)
+		}
+	}
+	return float64(covered) / float64(c.End-c.Start)
+}
+
+// lowProbability reports whether the scores overlapping the cue average
+// below threshold. Cues without scores are kept.
+func lowProbability(c subtitle.Cue, scores []Score, threshold float64) bool {
+	var sum, weight float64
+	for _, s := range scores {
+		if s.Probability <= 0 || s.End <= c.Start || s.Start >= c.End {
+			continue
+		}
+		// Zero length scores, such as single tokens, still count
+		w := float64(max(min(s.End, c.End)-max(s.Start, c.Start), time.Millisecond))
+		sum += s.Probability * w
+		weight += w
+	}
+	return weight > 0 && sum/weight < threshold
+}
+
+// normalize reduces text to lower case words of letters and digits, dropping
+// apostrophes so that "don't" matches "dont"
+func normalize(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			b.WriteRune(r)
+		case r == '\'' || r == '’':
+		default:
+			b.WriteByte(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
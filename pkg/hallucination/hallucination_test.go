@@ -0,0 +1,81 @@
+package hallucination
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/subtitle"
+)
+
+func track(texts ...string) *subtitle.Track {
+	t := &subtitle.Track{}
+	for i, text := range texts {
+		at := time.Duration(i) * 2 * time.Second
+		t.Cues = append(t.Cues, subtitle.Cue{Start: at, End: at + 1500*time.Millisecond, Text: text})
+	}
+	t.Renumber()
+	return t
+}
+
+// removed returns the reasons of the removals by cue
+func removed(r *Result) map[int]string {
+	m := make(map[int]string)
+	for _, rm := range r.Removed {
+		m[rm.Cue] = rm.Reason
+	}
+	return m
+}
+
+func TestFilterRepeats(t *testing.T) {
+	in := track("We sail at dawn.", "No, no.", "No, no.", "The sea is calm.", "The sea is calm.", "the sea is calm", "The sea\nis calm!", "Goodbye.")
+	result := Filter(in, Options{})
+
+	want := map[int]string{5: Repeated, 6: Repeated, 7: Repeated}
+	if got := removed(result); !reflect.DeepEqual(got, want) {
+		t.Errorf("Filter() removed %v, want %v", got, want)
+	}
+	if len(result.Track.Cues) != 5 || result.Track.Cues[4].Index != 5 || result.Track.Cues[4].Text != "Goodbye." {
+		t.Errorf("Filter() kept %+v", result.Track.Cues)
+	}
+	if len(in.Cues) != 8 {
+		t.Error("Filter() modified its input")
+	}
+}
+
+func TestFilterBoilerplate(t *testing.T) {
+	in := track("Thank you for watching!", "Thanks for watching, see you.", "I was thanking you for watching over my dog all week long.", "[BLANK_AUDIO]", "Don't forget to subscribe.")
+	want := map[int]string{1: Boilerplate, 2: Boilerplate, 4: Boilerplate, 5: Boilerplate}
+	if got := removed(Filter(in, Options{})); !reflect.DeepEqual(got, want) {
+		t.Errorf("Filter() removed %v, want %v", got, want)
+	}
+	if got := removed(Filter(in, Options{Phrases: []string{}})); len(got) != 0 {
+		t.Errorf("Filter() without phrases removed %v", got)
+	}
+}
+
+func TestFilterSilence(t *testing.T) {
+	// Cues at 0s, 2s and 4s; speech only under the first and a sliver of the third
+	in := track("one", "two", "three")
+	speech := []Interval{{Start: 0, End: 1500 * time.Millisecond}, {Start: 5200 * time.Millisecond, End: 6 * time.Second}}
+	want := map[int]string{2: Silence}
+	if got := removed(Filter(in, Options{Speech: speech})); !reflect.DeepEqual(got, want) {
+		t.Errorf("Filter() removed %v, want %v", got, want)
+	}
+	if got := removed(Filter(in, Options{Speech: []Interval{}})); len(got) != 3 {
+		t.Errorf("Filter() over silent audio removed %v, want every cue", got)
+	}
+}
+
+func TestFilterProbability(t *testing.T) {
+	in := track("one", "two", "three")
+	scores := []Score{
+		{Start: 0, End: time.Second, Probability: 0.9},
+		{Start: 2 * time.Second, End: 2500 * time.Millisecond, Probability: 0.5},
+		{Start: 2500 * time.Millisecond, End: 3500 * time.Millisecond, Probability: 0.1},
+	}
+	want := map[int]string{2: LowProbability}
+	if got := removed(Filter(in, Options{Scores: scores})); !reflect.DeepEqual(got, want) {
+		t.Errorf("Filter() removed %v, want %v", got, want)
+	}
+}
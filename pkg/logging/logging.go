@@ -0,0 +1,142 @@
+// Package logging carries log attributes such as job IDs and stage names
+// through contexts, so that the tool wrappers shared by many jobs log with
+// the attributes of the job they are running for, and turns the output of
+// external tools into log records.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+type (
+	attrsKey   struct{}
+	handlerKey struct{}
+)
+
+// WithAttrs returns a context whose loggers, as returned by From, include
+// args, given as slog key-value pairs or attributes
+func WithAttrs(ctx context.Context, args ...any) context.Context {
+	attrs, _ := ctx.Value(attrsKey{}).([]any)
+	merged := make([]any, 0, len(attrs)+len(args))
+	merged = append(append(merged, attrs...), args...)
+	return context.WithValue(ctx, attrsKey{}, merged)
+}
+
+// WithHandler returns a context whose loggers, as returned by From, also
+// send their records to h, such as a log kept for a single file
+func WithHandler(ctx context.Context, h slog.Handler) context.Context {
+	handlers, _ := ctx.Value(handlerKey{}).([]slog.Handler)
+	return context.WithValue(ctx, handlerKey{}, append(handlers[:len(handlers):len(handlers)], h))
+}
+
+// From returns logger, or the default logger if nil, with the attributes
+// and handlers attached to ctx
+func From(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if handlers, ok := ctx.Value(handlerKey{}).([]slog.Handler); ok && len(handlers) > 0 {
+		logger = slog.New(teeHandler(append([]slog.Handler{logger.Handler()}, handlers...)))
+	}
+	if attrs, ok := ctx.Value(attrsKey{}).([]any); ok && len(attrs) > 0 {
+		return logger.With(attrs...)
+	}
+	return logger
+}
+
+// teeHandler sends records to each of its handlers that is enabled for them
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			err = errors.Join(err, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return err
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
+
+// Writer logs each line written to it as a record at level, with the line in
+// the "line" attribute. Carriage returns also end lines, so progress updates
+// redrawn in place become separate records. Close logs a final unterminated
+// line.
+type Writer struct {
+	ctx    context.Context
+	logger *slog.Logger
+	level  slog.Level
+	msg    string
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewWriter returns a Writer logging lines with msg at level
+func NewWriter(ctx context.Context, logger *slog.Logger, level slog.Level, msg string) *Writer {
+	return &Writer{ctx: ctx, logger: logger, level: level, msg: msg}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if !w.logger.Enabled(w.ctx, w.level) {
+		return len(p), nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.log(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Close logs what is left of an unterminated line
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.log(w.buf)
+	w.buf = nil
+	return nil
+}
+
+// log logs a line unless it is blank
+func (w *Writer) log(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	w.logger.Log(w.ctx, w.level, w.msg, "line", string(line))
+}
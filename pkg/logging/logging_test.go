@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestFrom(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	ctx := WithAttrs(context.Background(), "job", "j1")
+	ctx = WithAttrs(ctx, slog.String("stage", "extract"))
+	From(ctx, logger).Info("done")
+
+	if got := buf.String(); !strings.Contains(got, "job=j1 stage=extract") {
+		t.Errorf("From() logged %q, want the context attributes", got)
+	}
+}
+
+func TestFromHandler(t *testing.T) {
+	var main, file bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&main, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	ctx := WithAttrs(context.Background(), "file", "talk.mp4")
+	ctx = WithHandler(ctx, slog.NewTextHandler(&file, nil))
+	From(ctx, logger).Info("processing")
+	From(context.Background(), logger).Warn("unrelated")
+
+	if got := file.String(); !strings.Contains(got, "msg=processing file=talk.mp4") || strings.Contains(got, "unrelated") {
+		t.Errorf("context handler logged %q, want only the record of the context", got)
+	}
+	if got := main.String(); strings.Contains(got, "processing") {
+		t.Errorf("logger logged %q below its level", got)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	w := NewWriter(context.Background(), logger, slog.LevelDebug, "ffmpeg output")
+	w.Write([]byte("banner\n\nframe=1\rframe="))
+	w.Write([]byte("2\r"))
+	w.Write([]byte("done"))
+	w.Close()
+
+	var lines []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record struct{ Line string }
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, record.Line)
+	}
+	want := []string{"banner", "frame=1", "frame=2", "done"}
+	if strings.Join(lines, ",") != strings.Join(want, ",") {
+		t.Errorf("Writer logged lines %q, want %q", lines, want)
+	}
+}
+
+func TestWriterDisabled(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	w := NewWriter(context.Background(), logger, slog.LevelDebug, "ffmpeg output")
+	w.Write([]byte("banner\n"))
+	w.Close()
+	if buf.Len() > 0 {
+		t.Errorf("Writer logged %q below the handler level", buf.String())
+	}
+}
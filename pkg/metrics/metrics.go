@@ -0,0 +1,281 @@
+// Package metrics keeps counters, gauges and histograms and serves them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics in registration order
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// metric is a family of series sharing a name
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes every metric in the Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the metrics for scraping
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// family holds the series of a metric, keyed by their label values
+type family[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*T
+	values map[string][]string
+}
+
+func newFamily[T any](name, help, kind string, labels []string) *family[T] {
+	return &family[T]{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*T), values: make(map[string][]string)}
+}
+
+// get returns the series for the label values, creating it with init; f.mu
+// must be held
+func (f *family[T]) get(values []string, init func() *T) *T {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = init()
+		f.series[key] = s
+		f.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// each calls fn for every series ordered by label values; f.mu must be held
+func (f *family[T]) each(fn func(labels string, s *T)) {
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fn(formatLabels(f.labels, f.values[key]), f.series[key])
+	}
+}
+
+func (f *family[T]) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+}
+
+// Counter is a family of values that only go up
+type Counter struct {
+	f *family[float64]
+}
+
+// Counter registers a counter with the given label names
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{f: newFamily[float64](name, help, "counter", labels)}
+	r.add(c)
+	return c
+}
+
+// Add adds v, which must not be negative, to the series with the label values
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.f.name + " cannot decrease")
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	*c.f.get(values, newFloat) += v
+}
+
+// Inc adds one to the series with the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Value returns the value of the series with the label values
+func (c *Counter) Value(values ...string) float64 {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	if v, ok := c.f.series[strings.Join(values, "\xff")]; ok {
+		return *v
+	}
+	return 0
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.header(w)
+	c.f.each(func(labels string, v *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", c.f.name, labels, formatValue(*v))
+	})
+}
+
+// gaugeFunc is a gauge without labels read when the metrics are written
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+// GaugeFunc registers a gauge whose value is returned by fn at scrape time
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.add(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, escapeHelp(g.help), g.name, g.name, formatValue(g.fn()))
+}
+
+// Histogram is a family of distributions counted into buckets
+type Histogram struct {
+	f       *family[histogram]
+	buckets []float64
+}
+
+// histogram is the state of a single series
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Histogram registers a histogram with the given upper bucket bounds, in
+// increasing order, and label names
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{f: newFamily[histogram](name, help, "histogram", labels), buckets: buckets}
+	r.add(h)
+	return h
+}
+
+// Observe adds v to the distribution of the series with the label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(values, func() *histogram {
+		return &histogram{counts: make([]uint64, len(h.buckets))}
+	})
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// Count returns the number of observations of the series with the label values
+func (h *Histogram) Count(values ...string) uint64 {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	if s, ok := h.f.series[strings.Join(values, "\xff")]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	h.f.header(w)
+	h.f.each(func(labels string, s *histogram) {
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.f.name, withLabel(labels, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.f.name, withLabel(labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.f.name, labels, formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.f.name, labels, s.count)
+	})
+}
+
+// newFloat creates the value of a counter series
+func newFloat() *float64 {
+	return new(float64)
+}
+
+// formatLabels formats label pairs as {name="value",...}, empty without labels
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabel(values[i]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// withLabel appends a label to formatted labels
+func withLabel(labels, name, value string) string {
+	pair := fmt.Sprintf("%s=\"%s\"", name, value)
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+// formatValue formats a sample value
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	jobs := r.Counter("jobs_total", "Jobs run.", "status")
+	r.GaugeFunc("queue_depth", "Jobs waiting.", func() float64 { return 3 })
+	latency := r.Histogram("stage_seconds", "Stage latency.", []float64{1, 10}, "stage")
+
+	jobs.Inc("succeeded")
+	jobs.Add(2, "failed")
+	jobs.Inc(`say "hi"`)
+	latency.Observe(0.5, "extract")
+	latency.Observe(10, "extract")
+	latency.Observe(60, "extract")
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP jobs_total Jobs run.
+# TYPE jobs_total counter
+jobs_total{status="failed"} 2
+jobs_total{status="say \"hi\""} 1
+jobs_total{status="succeeded"} 1
+# HELP queue_depth Jobs waiting.
+# TYPE queue_depth gauge
+queue_depth 3
+# HELP stage_seconds Stage latency.
+# TYPE stage_seconds histogram
+stage_seconds_bucket{stage="extract",le="1"} 1
+stage_seconds_bucket{stage="extract",le="10"} 2
+stage_seconds_bucket{stage="extract",le="+Inf"} 3
+stage_seconds_sum{stage="extract"} 70.5
+stage_seconds_count{stage="extract"} 3
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteText() =\n%s\nwant\n%s", got, want)
+	}
+
+	if v := jobs.Value("failed"); v != 2 {
+		t.Errorf("Value() = %v, want 2", v)
+	}
+	if n := latency.Count("extract"); n != 3 {
+		t.Errorf("Count() = %d, want 3", n)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests_total", "Requests.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "requests_total 1\n") {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,402 @@
+package mt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gleicon/transcoder/pkg/subtitle"
+)
+
+// Term is a glossary entry: a term of the source language and its required
+// translation per target language. A term has to be kept as it is in
+// languages without a translation, as product names, people and acronyms.
+type Term struct {
+	Source  string
+	Targets map[string]string // by language code
+}
+
+// Target returns the translation of the term into lang, matching regional
+// variants such as pt-BR to pt, or the term itself
+func (t Term) Target(lang string) string {
+	lang = strings.ToLower(lang)
+	if s, ok := t.Targets[lang]; ok {
+		return s
+	}
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		if s, ok := t.Targets[base]; ok {
+			return s
+		}
+	}
+	return t.Source
+}
+
+// Glossary holds the terms a translation must follow
+type Glossary struct {
+	terms []glossaryTerm // longest first, so phrases win over their words
+}
+
+type glossaryTerm struct {
+	Term
+	pattern *regexp.Regexp
+}
+
+// NewGlossary creates a glossary of terms. Terms match case insensitively
+// and as whole words.
+func NewGlossary(terms []Term) *Glossary {
+	g := &Glossary{}
+	for _, t := range terms {
+		t.Source = strings.TrimSpace(t.Source)
+		if t.Source == "" {
+			continue
+		}
+		targets := make(map[string]string, len(t.Targets))
+		for lang, s := range t.Targets {
+			if s = strings.TrimSpace(s); s != "" {
+				targets[strings.ToLower(lang)] = s
+			}
+		}
+		t.Targets = targets
+		g.terms = append(g.terms, glossaryTerm{Term: t, pattern: termPattern(t.Source)})
+	}
+	slices.SortStableFunc(g.terms, func(a, b glossaryTerm) int {
+		return len(b.Source) - len(a.Source)
+	})
+	return g
+}
+
+// Terms returns the terms of the glossary
+func (g *Glossary) Terms() []Term {
+	terms := make([]Term, len(g.terms))
+	for i, t := range g.terms {
+		terms[i] = t.Term
+	}
+	return terms
+}
+
+// Fingerprint identifies the terms of the glossary and their translations;
+// it is empty for a nil or empty glossary
+func (g *Glossary) Fingerprint() string {
+	if g == nil || len(g.terms) == 0 {
+		return ""
+	}
+	// Sorted, so the order of the glossary file does not matter
+	lines := make([]string, len(g.terms))
+	for i, t := range g.terms {
+		lines[i] = strconv.Quote(t.Source)
+		for _, lang := range slices.Sorted(maps.Keys(t.Targets)) {
+			lines[i] += " " + lang + "=" + strconv.Quote(t.Targets[lang])
+		}
+	}
+	slices.Sort(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// LoadGlossary reads a glossary file in the format of its extension:
+//   - .csv and .tsv: a header row naming the source column first and a
+//     language code per further column, then a term per row; empty cells
+//     keep the term as it is in that language
+//   - .tbx: TermBase eXchange, with the source language taken from the
+//     xml:lang of the document and entries in a single language protected
+func LoadGlossary(path string) (*Glossary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read glossary: %v", err)
+	}
+	defer f.Close()
+
+	var terms []Term
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		terms, err = parseDelimited(f, ',')
+	case ".tsv":
+		terms, err = parseDelimited(f, '\t')
+	case ".tbx":
+		terms, err = parseTBX(f)
+	default:
+		return nil, fmt.Errorf("unsupported glossary format: %s (want .csv, .tsv or .tbx)", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid glossary %s: %w", filepath.Base(path), err)
+	}
+	return NewGlossary(terms), nil
+}
+
+func parseDelimited(r io.Reader, sep rune) ([]Term, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = sep
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = sep == '\t'
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("missing header row")
+	}
+
+	langs := rows[0][1:]
+	for i, lang := range langs {
+		langs[i] = strings.TrimSpace(lang)
+		if langs[i] == "" {
+			return nil, fmt.Errorf("header column %d has no language", i+2)
+		}
+	}
+	var terms []Term
+	for n, row := range rows[1:] {
+		if len(row) > len(langs)+1 {
+			return nil, fmt.Errorf("row %d has %d columns, the header %d", n+2, len(row), len(langs)+1)
+		}
+		t := Term{Source: row[0], Targets: make(map[string]string)}
+		for i, s := range row[1:] {
+			t.Targets[langs[i]] = s
+		}
+		terms = append(terms, t)
+	}
+	return terms, nil
+}
+
+func parseTBX(r io.Reader) ([]Term, error) {
+	d := xml.NewDecoder(r)
+	var (
+		source string
+		terms  []Term
+		langs  []string          // languages of the current entry, in order
+		entry  map[string]string // first term per language
+		lang   string
+	)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "martif", "tbx":
+				source = strings.ToLower(xmlLang(el))
+			case "termEntry", "conceptEntry":
+				langs, entry = nil, make(map[string]string)
+			case "langSet", "langSec":
+				lang = strings.ToLower(xmlLang(el))
+			case "term":
+				var text string
+				if err := d.DecodeElement(&text, &el); err != nil {
+					return nil, err
+				}
+				if _, ok := entry[lang]; !ok && entry != nil {
+					langs = append(langs, lang)
+					entry[lang] = strings.TrimSpace(text)
+				}
+			}
+		case xml.EndElement:
+			if el.Name.Local != "termEntry" && el.Name.Local != "conceptEntry" || len(langs) == 0 {
+				continue
+			}
+			src := source
+			if _, ok := entry[src]; !ok {
+				src = langs[0]
+			}
+			t := Term{Source: entry[src], Targets: make(map[string]string)}
+			for _, l := range langs {
+				if l != src {
+					t.Targets[l] = entry[l]
+				}
+			}
+			terms = append(terms, t)
+			entry = nil
+		}
+	}
+	if terms == nil {
+		return nil, fmt.Errorf("no term entries")
+	}
+	return terms, nil
+}
+
+// xmlLang returns the xml:lang attribute of an element
+func xmlLang(el xml.StartElement) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == "lang" && (a.Name.Space == "xml" || a.Name.Space == "http://www.w3.org/XML/1998/namespace") {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// termPattern matches a term case insensitively; find checks word boundaries,
+// which \b cannot for terms like C++
+func termPattern(term string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)` + regexp.QuoteMeta(term))
+}
+
+// find returns the locations of whole word matches of pattern in text
+func find(pattern *regexp.Regexp, text string) [][]int {
+	var found [][]int
+	for _, loc := range pattern.FindAllStringIndex(text, -1) {
+		before, _ := utf8.DecodeLastRuneInString(text[:loc[0]])
+		after, _ := utf8.DecodeRuneInString(text[loc[1]:])
+		if !isWordRune(before) && !isWordRune(after) {
+			found = append(found, loc)
+		}
+	}
+	return found
+}
+
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+}
+
+// placeholder stands in for a glossary term while a text is translated;
+// backends copy it through, at times with spaces added inside
+var placeholder = regexp.MustCompile(`\[\[\s*(\d+)\s*\]\]`)
+
+// termMatch is an occurrence of a glossary term in a text
+type termMatch struct {
+	start, end int
+	term       *glossaryTerm
+}
+
+// match returns the glossary terms in text in order. Words of a longer term
+// are not matched on their own.
+func (g *Glossary) match(text string) []termMatch {
+	var matches []termMatch
+	for i := range g.terms {
+		t := &g.terms[i]
+		for _, loc := range find(t.pattern, text) {
+			overlaps := slices.ContainsFunc(matches, func(m termMatch) bool {
+				return loc[0] < m.end && m.start < loc[1]
+			})
+			if !overlaps {
+				matches = append(matches, termMatch{start: loc[0], end: loc[1], term: t})
+			}
+		}
+	}
+	slices.SortFunc(matches, func(a, b termMatch) int { return a.start - b.start })
+	return matches
+}
+
+// mask replaces the glossary terms in text with placeholders and returns the
+// translations into target that replace them afterwards
+func (g *Glossary) mask(text, target string) (string, []string) {
+	matches := g.match(text)
+	if matches == nil {
+		return text, nil
+	}
+	var b strings.Builder
+	replacements := make([]string, len(matches))
+	last := 0
+	for i, m := range matches {
+		b.WriteString(text[last:m.start])
+		b.WriteString("[[" + strconv.Itoa(i) + "]]")
+		replacements[i] = m.term.Target(target)
+		last = m.end
+	}
+	b.WriteString(text[last:])
+	return b.String(), replacements
+}
+
+// unmask puts the replacements of mask in place of their placeholders
+func unmask(text string, replacements []string) string {
+	if len(replacements) == 0 {
+		return text
+	}
+	return placeholder.ReplaceAllStringFunc(text, func(s string) string {
+		i, err := strconv.Atoi(placeholder.FindStringSubmatch(s)[1])
+		if err != nil || i >= len(replacements) {
+			return s
+		}
+		return replacements[i]
+	})
+}
+
+// WithGlossary returns a backend that keeps the terms of g away from backend
+// and puts their required translations into its results
+func WithGlossary(backend Backend, g *Glossary) Backend {
+	if g == nil || len(g.terms) == 0 {
+		return backend
+	}
+	return &glossaryBackend{backend: backend, glossary: g}
+}
+
+type glossaryBackend struct {
+	backend  Backend
+	glossary *Glossary
+}
+
+// Translate implements Backend
+func (b *glossaryBackend) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	masked := make([]string, len(texts))
+	replacements := make([][]string, len(texts))
+	for i, text := range texts {
+		masked[i], replacements[i] = b.glossary.mask(text, target)
+	}
+	out, err := b.backend.Translate(ctx, masked, source, target)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i] = unmask(out[i], replacements[i])
+	}
+	return out, nil
+}
+
+// Violation is a cue whose translation lacks the translation the glossary
+// requires for a term of the source cue
+type Violation struct {
+	Cue   int // source cue, from 1
+	Start time.Duration
+	End   time.Duration
+	Lang  string
+	Term  string // term in the source cue
+	Want  string // required translation
+	Text  string // translated text
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("cue %d at %s: %q should be %q in %s: %q", v.Cue, v.Start, v.Term, v.Want, v.Lang, strings.ReplaceAll(v.Text, "\n", " "))
+}
+
+// Check compares a translation into target with its source and returns the
+// cues that break the glossary. Cues are paired as subtitle.Bilingual pairs
+// them, so translations segmented on their own are checked too.
+func (g *Glossary) Check(source, translated *subtitle.Track, target string) []Violation {
+	var violations []Violation
+	for i, cue := range subtitle.Bilingual(source, translated).Cues {
+		seen := make(map[*glossaryTerm]bool)
+		for _, m := range g.match(cue.Text) {
+			t := m.term
+			if seen[t] {
+				continue
+			}
+			seen[t] = true
+			want := t.Target(target)
+			if find(termPattern(want), cue.Secondary) != nil {
+				continue
+			}
+			violations = append(violations, Violation{
+				Cue: i + 1, Start: cue.Start, End: cue.End, Lang: target,
+				Term: t.Source, Want: want, Text: cue.Secondary,
+			})
+		}
+	}
+	return violations
+}
@@ -0,0 +1,148 @@
+package mt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/subtitle"
+)
+
+const sampleTBX = `<?xml version="1.0" encoding="UTF-8"?>
+<martif type="TBX" xml:lang="en">
+  <text><body>
+    <termEntry id="1">
+      <langSet xml:lang="fr"><tig><term>nuage Acme</term></tig></langSet>
+      <langSet xml:lang="en"><tig><term>Acme Cloud</term></tig></langSet>
+      <langSet xml:lang="es"><tig><term>Nube Acme</term></tig></langSet>
+    </termEntry>
+    <termEntry id="2">
+      <langSet xml:lang="en"><ntig><termGrp><term>Ana Souza</term></termGrp></ntig></langSet>
+    </termEntry>
+  </body></text>
+</martif>
+`
+
+func TestLoadGlossary(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"terms.csv": "# product names\nterm,es,pt-BR\nAcme Cloud,Nube Acme,\nAna Souza\n\"dashboard, classic\",panel clásico,painel clássico\n",
+		"terms.tsv": "term\tes\nAcme Cloud\tNube Acme\nAna Souza\t\n",
+		"terms.tbx": sampleTBX,
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		g, err := LoadGlossary(path)
+		if err != nil {
+			t.Fatalf("LoadGlossary(%s) error = %v", name, err)
+		}
+		terms := make(map[string]Term)
+		for _, term := range g.Terms() {
+			terms[term.Source] = term
+		}
+		if got := terms["Acme Cloud"].Target("es"); got != "Nube Acme" {
+			t.Errorf("%s: Acme Cloud in es = %q, want Nube Acme", name, got)
+		}
+		if got := terms["Ana Souza"].Target("es"); got != "Ana Souza" {
+			t.Errorf("%s: Ana Souza in es = %q, want it kept", name, got)
+		}
+	}
+
+	g, _ := LoadGlossary(filepath.Join(dir, "terms.csv"))
+	if got := g.Terms()[0]; got.Source != "dashboard, classic" || got.Target("pt-br") != "painel clássico" || got.Target("pt-PT") != got.Source {
+		t.Errorf("Terms()[0] = %+v, want the longest term with its pt-BR translation", got)
+	}
+
+	for name, data := range map[string]string{
+		"extra.csv": "term,es\nAcme,Acme,Acmé\n",
+		"blank.csv": "term,,es\nAcme\n",
+		"empty.tbx": "<martif xml:lang=\"en\"></martif>",
+		"terms.txt": "Acme\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadGlossary(path); err == nil {
+			t.Errorf("LoadGlossary(%s) succeeded", name)
+		}
+	}
+}
+
+// recordBackend uppercases texts and records what it was sent
+type recordBackend struct {
+	sent []string
+}
+
+func (b *recordBackend) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	b.sent = append(b.sent, texts...)
+	out := make([]string, len(texts))
+	for i, text := range texts {
+		// Backends tend to space out unknown tokens
+		out[i] = strings.ReplaceAll(strings.ToUpper(text), "[[1]]", "[[ 1 ]]")
+	}
+	return out, nil
+}
+
+func TestWithGlossary(t *testing.T) {
+	g := NewGlossary([]Term{
+		{Source: "Acme", Targets: map[string]string{}},
+		{Source: "Acme Cloud", Targets: map[string]string{"es": "Nube Acme"}},
+		{Source: "C++"},
+	})
+	backend := &recordBackend{}
+	got, err := WithGlossary(backend, g).Translate(context.Background(),
+		[]string{"Welcome to acme cloud by Acme.", "Acmeville likes C++, not C."}, "en", "es")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantSent := []string{"Welcome to [[0]] by [[1]].", "Acmeville likes [[0]], not C."}
+	if !reflect.DeepEqual(backend.sent, wantSent) {
+		t.Errorf("backend was sent %q, want %q", backend.sent, wantSent)
+	}
+	want := []string{"WELCOME TO Nube Acme BY Acme.", "ACMEVILLE LIKES C++, NOT C."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Translate() = %q, want %q", got, want)
+	}
+
+	if b := WithGlossary(backend, NewGlossary(nil)); b != Backend(backend) {
+		t.Error("WithGlossary() wrapped the backend in an empty glossary")
+	}
+}
+
+func TestGlossaryCheck(t *testing.T) {
+	g := NewGlossary([]Term{
+		{Source: "Acme"},
+		{Source: "Acme Cloud", Targets: map[string]string{"es": "Nube Acme"}},
+	})
+	s := time.Second
+	source := &subtitle.Track{Cues: []subtitle.Cue{
+		{Start: 0, End: 2 * s, Text: "Acme Cloud is here."},
+		{Start: 2 * s, End: 4 * s, Text: "Made by Acme."},
+		{Start: 4 * s, End: 6 * s, Text: "Thanks to ACME and Acme Cloud."},
+	}}
+	translated := &subtitle.Track{Cues: []subtitle.Cue{
+		{Start: 0, End: 2 * s, Text: "Nube Acme está aquí."},
+		{Start: 2 * s, End: 4 * s, Text: "Hecho por Acmé."},
+		{Start: 4 * s, End: 6 * s, Text: "Gracias a Acme y la Nube de Acme."},
+	}}
+
+	got := g.Check(source, translated, "es")
+	if len(got) != 2 {
+		t.Fatalf("Check() = %v, want 2 violations", got)
+	}
+	if v := got[0]; v.Cue != 2 || v.Term != "Acme" || v.Want != "Acme" || v.Start != 2*s || v.Lang != "es" {
+		t.Errorf("Check()[0] = %+v", v)
+	}
+	if v := got[1]; v.Cue != 3 || v.Term != "Acme Cloud" || v.Want != "Nube Acme" {
+		t.Errorf("Check()[1] = %+v", v)
+	}
+}
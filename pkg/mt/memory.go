@@ -0,0 +1,330 @@
+package mt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gleicon/transcoder/pkg/logging"
+)
+
+// DefaultFuzzy is the similarity from which a memory entry is reused for a
+// text that differs from it
+const DefaultFuzzy = 0.9
+
+// Memory is a translation memory: translations of cue texts per language
+// pair, kept in a file and reused before asking a backend, so recurring
+// lines such as intros and disclaimers are translated once
+type Memory struct {
+	// Fuzzy is the similarity, from 0 to 1, a stored text needs for its
+	// translation to be reused for another text; 1 reuses exact matches only
+	Fuzzy float64
+
+	path  string
+	mu    sync.Mutex
+	units map[string]map[string]memoryUnit // by language pair and glossary, then normalized text
+}
+
+// memoryUnit is a stored translation, one JSON line in the memory file
+type memoryUnit struct {
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Glossary    string `json:"glossary,omitempty"` // fingerprint of the glossary the translation follows
+	Text        string `json:"text"`
+	Translation string `json:"translation"`
+}
+
+// OpenMemory opens the translation memory in the file at path, which is
+// created with the first translation added
+func OpenMemory(path string) (*Memory, error) {
+	m := &Memory{Fuzzy: DefaultFuzzy, path: path, units: make(map[string]map[string]memoryUnit)}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read translation memory: %v", err)
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var u memoryUnit
+		if err := json.Unmarshal(sc.Bytes(), &u); err != nil {
+			return nil, fmt.Errorf("invalid translation memory %s: line %d: %v", filepath.Base(path), n, err)
+		}
+		// Later lines replace earlier translations of the same text
+		m.put(u)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read translation memory: %v", err)
+	}
+	return m, nil
+}
+
+// Len returns the number of stored translations
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, units := range m.units {
+		n += len(units)
+	}
+	return n
+}
+
+// pairKey identifies a language pair and the fingerprint of a glossary; an
+// unknown source is kept apart
+func pairKey(source, target, glossary string) string {
+	if source == "auto" {
+		source = ""
+	}
+	return strings.ToLower(source) + ">" + strings.ToLower(target) + "/" + glossary
+}
+
+// normalize makes texts that differ in case and spacing only match exactly
+func normalize(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+func (m *Memory) put(u memoryUnit) {
+	key := pairKey(u.Source, u.Target, u.Glossary)
+	if m.units[key] == nil {
+		m.units[key] = make(map[string]memoryUnit)
+	}
+	m.units[key][normalize(u.Text)] = u
+}
+
+// Match is a translation found in the memory
+type Match struct {
+	Text        string  // stored text
+	Translation string  // its translation
+	Similarity  float64 // 1 for an exact match
+}
+
+// Lookup returns the stored translation of text from source into target, or
+// of the most similar stored text at least as similar as m.Fuzzy, among the
+// translations made without a glossary. Fuzzy matches must have the same
+// numbers as text, so "episode 12" does not reuse the translation of
+// "episode 11", but are otherwise returned as they are: a name or word that
+// differs from the stored text keeps its stored translation.
+func (m *Memory) Lookup(text, source, target string) (Match, bool) {
+	return m.lookup(text, source, target, "")
+}
+
+// lookup is Lookup among the translations made with the glossary of a
+// fingerprint
+func (m *Memory) lookup(text, source, target, glossary string) (Match, bool) {
+	norm := normalize(text)
+	if norm == "" {
+		return Match{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	units := m.units[pairKey(source, target, glossary)]
+	if u, ok := units[norm]; ok {
+		return Match{Text: u.Text, Translation: u.Translation, Similarity: 1}, true
+	}
+	if m.Fuzzy >= 1 {
+		return Match{}, false
+	}
+
+	var best Match
+	bestKey := ""
+	a, digits := []rune(norm), numbers(norm)
+	for key, u := range units {
+		b := []rune(key)
+		// The edit distance is at least the difference in length
+		longest := max(len(a), len(b))
+		if float64(longest-min(len(a), len(b))) > (1-m.Fuzzy)*float64(longest) {
+			continue
+		}
+		sim := 1 - float64(editDistance(a, b))/float64(longest)
+		if sim < m.Fuzzy || sim < best.Similarity || sim == best.Similarity && key > bestKey {
+			continue
+		}
+		if numbers(key) != digits {
+			continue
+		}
+		best, bestKey = Match{Text: u.Text, Translation: u.Translation, Similarity: sim}, key
+	}
+	return best, bestKey != ""
+}
+
+// numbers returns the digits of text, which fuzzy matches have to share
+func numbers(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, text)
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Add stores translations of texts from source into target, made without a
+// glossary, and appends them to the memory file
+func (m *Memory) Add(source, target string, texts, translations []string) error {
+	return m.add(source, target, "", texts, translations)
+}
+
+// add is Add for translations made with the glossary of a fingerprint
+func (m *Memory) add(source, target, glossary string, texts, translations []string) error {
+	if len(texts) != len(translations) {
+		return fmt.Errorf("%d translations for %d texts", len(translations), len(texts))
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	var units []memoryUnit
+	for i, text := range texts {
+		if normalize(text) == "" || strings.TrimSpace(translations[i]) == "" {
+			continue
+		}
+		u := memoryUnit{Source: source, Target: target, Glossary: glossary, Text: text, Translation: translations[i]}
+		if err := enc.Encode(u); err != nil {
+			return err
+		}
+		units = append(units, u)
+	}
+	if units == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if dir := filepath.Dir(m.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create translation memory directory: %v", err)
+		}
+	}
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write translation memory: %v", err)
+	}
+	// One write per batch, so concurrent jobs appending do not interleave lines
+	_, err = f.Write(buf.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write translation memory: %v", err)
+	}
+	for _, u := range units {
+		m.put(u)
+	}
+	return nil
+}
+
+// MemoryMatches counts how the texts given to a MemoryBackend were translated
+type MemoryMatches struct {
+	Exact      int `json:"exact"`      // reused from an exact match
+	Fuzzy      int `json:"fuzzy"`      // reused from a fuzzy match
+	Translated int `json:"translated"` // sent to the backend
+}
+
+// Add adds the counts of o to mm
+func (mm *MemoryMatches) Add(o MemoryMatches) {
+	mm.Exact += o.Exact
+	mm.Fuzzy += o.Fuzzy
+	mm.Translated += o.Translated
+}
+
+// MemoryBackend translates with a memory first and a backend for the rest,
+// whose translations it adds to the memory
+type MemoryBackend struct {
+	backend  Backend
+	memory   *Memory
+	glossary string // fingerprint of the glossary backend follows
+
+	mu      sync.Mutex
+	matches MemoryMatches
+}
+
+// WithMemory returns a backend that reuses translations from m and only asks
+// backend for texts m does not hold. g is the glossary backend follows, nil
+// if none: translations are only reused under the glossary they were made
+// with, so a changed glossary does not serve translations of the old terms.
+func WithMemory(backend Backend, m *Memory, g *Glossary) *MemoryBackend {
+	return &MemoryBackend{backend: backend, memory: m, glossary: g.Fingerprint()}
+}
+
+// Matches returns how the texts translated so far were translated
+func (b *MemoryBackend) Matches() MemoryMatches {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.matches
+}
+
+// Translate implements Backend
+func (b *MemoryBackend) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	out := make([]string, len(texts))
+	var found MemoryMatches
+	var missing []int
+	for i, text := range texts {
+		match, ok := b.memory.lookup(text, source, target, b.glossary)
+		switch {
+		case !ok:
+			missing = append(missing, i)
+			continue
+		case match.Similarity == 1:
+			found.Exact++
+		default:
+			found.Fuzzy++
+		}
+		out[i] = match.Translation
+	}
+
+	if len(missing) > 0 {
+		query := make([]string, len(missing))
+		for i, j := range missing {
+			query[i] = texts[j]
+		}
+		translated, err := b.backend.Translate(ctx, query, source, target)
+		if err != nil {
+			return nil, err
+		}
+		if len(translated) != len(query) {
+			return nil, fmt.Errorf("translation returned %d texts for %d inputs", len(translated), len(query))
+		}
+		for i, j := range missing {
+			out[j] = translated[i]
+		}
+		// The memory is a cache; failing to update it loses no translation
+		if err := b.memory.add(source, target, b.glossary, query, translated); err != nil {
+			logging.From(ctx, nil).Warn("failed to update translation memory", "error", err)
+		}
+		found.Translated = len(missing)
+	}
+
+	b.mu.Lock()
+	b.matches.Add(found)
+	b.mu.Unlock()
+	return out, nil
+}
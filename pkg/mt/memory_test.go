@@ -0,0 +1,138 @@
+package mt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryLookup(t *testing.T) {
+	m, err := OpenMemory(filepath.Join(t.TempDir(), "tm.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Add("en", "es", []string{"Welcome to the Harbor Show.", "This is episode 12.", ""}, []string{"Bienvenidos al Harbor Show.", "Este es el episodio 12.", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text, source, target string
+		want                 string
+		similarity           float64
+	}{
+		{text: "welcome to the  Harbor show.", source: "en", target: "es", want: "Bienvenidos al Harbor Show.", similarity: 1},
+		{text: "Welcome to the Harbor Show!", source: "en", target: "es", want: "Bienvenidos al Harbor Show."},
+		{text: "This is episode 13.", source: "en", target: "es"},
+		{text: "Welcome to the Harbor Show.", source: "en", target: "fr"},
+		{text: "Welcome to the Harbor Show.", source: "auto", target: "es"},
+		{text: "Goodbye.", source: "en", target: "es"},
+	}
+	for _, tt := range tests {
+		match, ok := m.Lookup(tt.text, tt.source, tt.target)
+		if ok != (tt.want != "") || match.Translation != tt.want {
+			t.Errorf("Lookup(%q, %s, %s) = %+v, %v; want %q", tt.text, tt.source, tt.target, match, ok, tt.want)
+		}
+		if ok && tt.similarity == 1 && match.Similarity != 1 {
+			t.Errorf("Lookup(%q) similarity = %v, want an exact match", tt.text, match.Similarity)
+		}
+		if ok && tt.similarity == 0 && (match.Similarity >= 1 || match.Similarity < DefaultFuzzy) {
+			t.Errorf("Lookup(%q) similarity = %v, want a fuzzy match", tt.text, match.Similarity)
+		}
+	}
+
+	m.Fuzzy = 1
+	if _, ok := m.Lookup("Welcome to the Harbor Show!", "en", "es"); ok {
+		t.Error("Lookup() matched fuzzily with Fuzzy = 1")
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory", "tm.jsonl")
+	m, err := OpenMemory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &recordBackend{}
+	mb := WithMemory(backend, m, nil)
+	got, err := mb.Translate(context.Background(), []string{"Hello.", "Stay tuned."}, "en", "es")
+	if err != nil || !reflect.DeepEqual(got, []string{"HELLO.", "STAY TUNED."}) {
+		t.Fatalf("Translate() = %q, %v", got, err)
+	}
+
+	// A later job reads the memory from the file
+	m, err = OpenMemory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", m.Len())
+	}
+	backend = &recordBackend{}
+	mb = WithMemory(backend, m, nil)
+	got, err = mb.Translate(context.Background(), []string{"Stay tuned!", "New line.", "hello."}, "en", "es")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"STAY TUNED.", "NEW LINE.", "HELLO."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Translate() = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(backend.sent, []string{"New line."}) {
+		t.Errorf("backend was sent %q, want only the new line", backend.sent)
+	}
+	if want := (MemoryMatches{Exact: 1, Fuzzy: 1, Translated: 1}); mb.Matches() != want {
+		t.Errorf("Matches() = %+v, want %+v", mb.Matches(), want)
+	}
+
+	if err := os.WriteFile(path, []byte("{\"text\": \"a\"}\nnot json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenMemory(path); err == nil {
+		t.Error("OpenMemory() read an invalid file")
+	}
+}
+
+func TestMemoryBackendGlossary(t *testing.T) {
+	m, err := OpenMemory(filepath.Join(t.TempDir(), "tm.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := NewGlossary([]Term{{Source: "Acme", Targets: map[string]string{"es": "Acmé"}}})
+	if _, err := WithMemory(&recordBackend{}, m, old).Translate(context.Background(), []string{"Made by Acme."}, "en", "es"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Translations made under another glossary, or none, are not reused
+	for _, g := range []*Glossary{NewGlossary([]Term{{Source: "Acme"}}), nil} {
+		backend := &recordBackend{}
+		if _, err := WithMemory(backend, m, g).Translate(context.Background(), []string{"Made by Acme."}, "en", "es"); err != nil {
+			t.Fatal(err)
+		}
+		if len(backend.sent) != 1 {
+			t.Errorf("glossary %v: backend was sent %q, want the text translated again", g.Terms(), backend.sent)
+		}
+	}
+	same := NewGlossary([]Term{{Source: "Acme", Targets: map[string]string{"ES": "Acmé"}}})
+	if mb := WithMemory(&recordBackend{}, m, same); mb.glossary != old.Fingerprint() {
+		t.Errorf("Fingerprint() differs for the same terms")
+	}
+}
+
+func TestMemoryBackendAddFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "memory")
+	m, err := OpenMemory(filepath.Join(dir, "tm.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The memory file cannot be created below a regular file
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := WithMemory(&recordBackend{}, m, nil).Translate(context.Background(), []string{"Hello."}, "en", "es")
+	if err != nil || !reflect.DeepEqual(got, []string{"HELLO."}) {
+		t.Errorf("Translate() = %q, %v; want the translation despite the memory", got, err)
+	}
+}
@@ -0,0 +1,185 @@
+// Package mt translates subtitle text with machine translation backends.
+// Whisper can only translate speech into English; these backends translate
+// a transcript into any other language.
+package mt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/subtitle"
+)
+
+// Backend translates a batch of texts from a source to a target language.
+// The result has one translation per input text, in order.
+type Backend interface {
+	Translate(ctx context.Context, texts []string, source, target string) ([]string, error)
+}
+
+// Config selects and configures a backend
+type Config struct {
+	Backend string `json:"backend" yaml:"backend"` // "libretranslate"
+	URL     string `json:"url" yaml:"url"`
+	APIKey  string `json:"api_key" yaml:"api_key"`
+}
+
+// New creates the backend described by config
+func New(config Config) (Backend, error) {
+	switch config.Backend {
+	case "", "libretranslate":
+		if config.URL == "" {
+			return nil, fmt.Errorf("libretranslate backend requires a url")
+		}
+		return NewLibreTranslate(config.URL, config.APIKey), nil
+	default:
+		return nil, fmt.Errorf("unknown translation backend: %s", config.Backend)
+	}
+}
+
+// LibreTranslate is a client for the LibreTranslate HTTP API
+type LibreTranslate struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+// NewLibreTranslate creates a client for the LibreTranslate server at url
+func NewLibreTranslate(url, apiKey string) *LibreTranslate {
+	return &LibreTranslate{
+		URL:    strings.TrimSuffix(url, "/"),
+		APIKey: apiKey,
+		Client: &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+type libreRequest struct {
+	Q      []string `json:"q"`
+	Source string   `json:"source"`
+	Target string   `json:"target"`
+	Format string   `json:"format"`
+	APIKey string   `json:"api_key,omitempty"`
+}
+
+type libreResponse struct {
+	TranslatedText []string `json:"translatedText"`
+	Error          string   `json:"error"`
+}
+
+// Translate implements Backend
+func (l *LibreTranslate) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	if source == "" {
+		source = "auto"
+	}
+
+	body, err := json.Marshal(libreRequest{Q: texts, Source: source, Target: target, Format: "text", APIKey: l.APIKey})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.URL+"/translate", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create translation request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("translation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read translation response: %v", err)
+	}
+	var result libreResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			// Proxies in front of the backend answer errors with HTML
+			return nil, &HTTPError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return nil, fmt.Errorf("invalid translation response (HTTP %d): %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Message: result.Error}
+	}
+	if len(result.TranslatedText) != len(texts) {
+		return nil, fmt.Errorf("translation returned %d texts for %d inputs", len(result.TranslatedText), len(texts))
+	}
+	return result.TranslatedText, nil
+}
+
+// HTTPError is an error response of a translation backend
+type HTTPError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("translation failed (HTTP %d): %s", e.StatusCode, e.Message)
+}
+
+// Transient reports whether the request may succeed later: the backend was
+// rate limiting, overloaded or failing
+func (e *HTTPError) Transient() bool {
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// WithPolicy returns a backend that limits and retries every request to
+// backend according to p
+func WithPolicy(backend Backend, p *policy.Policy) Backend {
+	if p == nil {
+		return backend
+	}
+	return &policyBackend{backend: backend, policy: p}
+}
+
+type policyBackend struct {
+	backend Backend
+	policy  *policy.Policy
+}
+
+// Translate implements Backend
+func (b *policyBackend) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	var out []string
+	err := b.policy.Run(ctx, policy.Translate, 0, func(ctx context.Context) error {
+		var err error
+		out, err = b.backend.Translate(ctx, texts, source, target)
+		return err
+	})
+	return out, err
+}
+
+// batchSize is the number of cues sent to a backend per request
+const batchSize = 50
+
+// TranslateTrack translates the text of every cue, keeping the timings
+func TranslateTrack(ctx context.Context, backend Backend, track *subtitle.Track, source, target string) (*subtitle.Track, error) {
+	out := &subtitle.Track{Cues: make([]subtitle.Cue, len(track.Cues))}
+	copy(out.Cues, track.Cues)
+
+	for start := 0; start < len(out.Cues); start += batchSize {
+		end := min(start+batchSize, len(out.Cues))
+		texts := make([]string, 0, end-start)
+		for _, cue := range out.Cues[start:end] {
+			texts = append(texts, cue.Text)
+		}
+		translated, err := backend.Translate(ctx, texts, source, target)
+		if err != nil {
+			return nil, err
+		}
+		for i, text := range translated {
+			out.Cues[start+i].Text = text
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,139 @@
+package mt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/subtitle"
+)
+
+func TestLibreTranslate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/translate" {
+			http.NotFound(w, r)
+			return
+		}
+		var req libreRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(libreResponse{Error: err.Error()})
+			return
+		}
+		if req.Target == "xx" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(libreResponse{Error: "xx is not supported"})
+			return
+		}
+		var resp libreResponse
+		for _, q := range req.Q {
+			resp.TranslatedText = append(resp.TranslatedText, fmt.Sprintf("[%s>%s] %s", req.Source, req.Target, q))
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer ts.Close()
+
+	backend, err := New(Config{URL: ts.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := backend.Translate(context.Background(), []string{"Hello", "World"}, "en", "es")
+	if err != nil {
+		t.Fatalf("Translate() error = %v", err)
+	}
+	if strings.Join(got, "|") != "[en>es] Hello|[en>es] World" {
+		t.Errorf("Translate() = %q", got)
+	}
+
+	_, err = backend.Translate(context.Background(), []string{"Hello"}, "en", "xx")
+	if err == nil || !strings.Contains(err.Error(), "xx is not supported") {
+		t.Errorf("Translate() error = %v, want the server's error", err)
+	}
+}
+
+// upperBackend records the batch sizes it receives
+type upperBackend struct {
+	batches []int
+}
+
+func (b *upperBackend) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	b.batches = append(b.batches, len(texts))
+	out := make([]string, len(texts))
+	for i, text := range texts {
+		out[i] = strings.ToUpper(text)
+	}
+	return out, nil
+}
+
+func TestTranslateTrack(t *testing.T) {
+	var track subtitle.Track
+	for i := 0; i < batchSize+3; i++ {
+		track.Cues = append(track.Cues, subtitle.Cue{
+			Index: i + 1,
+			Start: time.Duration(i) * time.Second,
+			End:   time.Duration(i+1) * time.Second,
+			Text:  fmt.Sprintf("cue %d", i+1),
+		})
+	}
+
+	backend := &upperBackend{}
+	got, err := TranslateTrack(context.Background(), backend, &track, "en", "fr")
+	if err != nil {
+		t.Fatalf("TranslateTrack() error = %v", err)
+	}
+	if len(backend.batches) != 2 || backend.batches[0] != batchSize || backend.batches[1] != 3 {
+		t.Errorf("batches = %v", backend.batches)
+	}
+	if got.Cues[52].Text != "CUE 53" || got.Cues[52].Start != 52*time.Second {
+		t.Errorf("cue 53 = %+v", got.Cues[52])
+	}
+	if track.Cues[0].Text != "cue 1" {
+		t.Error("TranslateTrack() modified the source track")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{Backend: "libretranslate"}); err == nil {
+		t.Error("New() without a url succeeded")
+	}
+	if _, err := New(Config{Backend: "nope", URL: "http://localhost"}); err == nil {
+		t.Error("New() with an unknown backend succeeded")
+	}
+}
+
+func TestWithPolicy(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			// An overloaded proxy answers with HTML
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, "<html>502 Bad Gateway</html>")
+		default:
+			json.NewEncoder(w).Encode(libreResponse{TranslatedText: []string{"Hola"}})
+		}
+	}))
+	defer ts.Close()
+
+	p := &policy.Policy{Retry: policy.Retry{Attempts: 2, Backoff: time.Millisecond}}
+	backend := WithPolicy(NewLibreTranslate(ts.URL, ""), p)
+	got, err := backend.Translate(context.Background(), []string{"Hello"}, "en", "es")
+	if err != nil || len(got) != 1 || got[0] != "Hola" {
+		t.Errorf("Translate() = %v, %v after a retried 502", got, err)
+	}
+	if requests != 2 {
+		t.Errorf("requests = %d, want 2", requests)
+	}
+
+	if (&HTTPError{StatusCode: http.StatusBadRequest}).Transient() {
+		t.Error("HTTP 400 is transient")
+	}
+}
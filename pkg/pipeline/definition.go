@@ -0,0 +1,318 @@
+// Package pipeline runs multi-step media pipelines described declaratively in
+// YAML or JSON files, e.g.
+//
+//	input: talk.mp4
+//	output_dir: out
+//	steps:
+//	  - probe
+//	  - normalize
+//	  - extract
+//	  - transcribe: small
+//	  - translate: [es, fr]
+//	  - bilingual: es
+//	  - burn: es.bilingual
+//	  - mux: [en, fr]
+//	  - speed: 1.25
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/gleicon/transcoder/pkg/mt"
+	"github.com/gleicon/transcoder/pkg/subtitle"
+)
+
+// Step kinds
+const (
+	StepProbe      = "probe"
+	StepNormalize  = "normalize"
+	StepExtract    = "extract"
+	StepTranscribe = "transcribe"
+	StepTranslate  = "translate"
+	StepBilingual  = "bilingual"
+	StepBurn       = "burn"
+	StepMux        = "mux"
+	StepSpeed      = "speed"
+)
+
+// stepParams lists the parameters each step accepts; the first one is set by
+// the shorthand form, e.g. "speed: 1.25" or "translate: [es, fr]"
+var stepParams = map[string][]string{
+	StepProbe:      nil,
+	StepNormalize:  {"loudness"},
+	StepExtract:    nil,
+	StepTranscribe: {"model", "lang"},
+	StepTranslate:  {"langs"},
+	StepBilingual:  {"langs"},
+	StepBurn:       {"lang", "style"},
+	StepMux:        {"langs"},
+	StepSpeed:      {"factor"},
+}
+
+// BilingualTrack names the track of a bilingual step showing the transcript
+// with its translation into lang, for burn and mux steps, e.g. es.bilingual
+func BilingualTrack(lang string) string {
+	return lang + ".bilingual"
+}
+
+// Step is one operation of a pipeline
+type Step struct {
+	Kind     string
+	Model    string   // transcribe: model file, name in the models directory, or "auto"
+	Lang     string   // transcribe: spoken language; burn: subtitle track to render
+	Langs    []string // translate: target languages; bilingual: translations to pair with the transcript; mux: subtitle tracks to add
+	Style    string   // burn: ASS style preset and overrides, see subtitle.ParseASSStyle
+	Factor   float64  // speed: playback speed multiplier
+	Loudness float64  // normalize: integrated loudness target in LUFS
+}
+
+// String returns the step in the shorthand notation used in error messages
+func (s Step) String() string {
+	switch s.Kind {
+	case StepTranscribe:
+		if s.Model != "" {
+			return s.Kind + "(" + s.Model + ")"
+		}
+	case StepTranslate, StepBilingual, StepMux:
+		return s.Kind + "(" + strings.Join(s.Langs, ", ") + ")"
+	case StepBurn:
+		return s.Kind + "(" + s.Lang + ")"
+	case StepSpeed:
+		return s.Kind + "(" + strconv.FormatFloat(s.Factor, 'g', -1, 64) + ")"
+	}
+	return s.Kind
+}
+
+// UnmarshalYAML accepts a bare step name, a step with a shorthand value or a
+// step with a mapping of parameters
+func (s *Step) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		s.Kind = node.Value
+		if _, ok := stepParams[s.Kind]; !ok {
+			return fmt.Errorf("line %d: unknown step %q", node.Line, s.Kind)
+		}
+		return nil
+	case yaml.MappingNode:
+		if len(node.Content) != 2 {
+			return fmt.Errorf("line %d: a step must have exactly one name", node.Line)
+		}
+	default:
+		return fmt.Errorf("line %d: invalid step", node.Line)
+	}
+
+	s.Kind = node.Content[0].Value
+	params, ok := stepParams[s.Kind]
+	if !ok {
+		return fmt.Errorf("line %d: unknown step %q", node.Line, s.Kind)
+	}
+	value := node.Content[1]
+
+	if value.Kind != yaml.MappingNode {
+		if len(params) == 0 {
+			return fmt.Errorf("line %d: step %s takes no parameters", value.Line, s.Kind)
+		}
+		return s.setParam(params[0], value)
+	}
+	for i := 0; i < len(value.Content); i += 2 {
+		name, v := value.Content[i].Value, value.Content[i+1]
+		known := false
+		for _, p := range params {
+			known = known || p == name
+		}
+		if !known {
+			return fmt.Errorf("line %d: unknown parameter %q for step %s", value.Content[i].Line, name, s.Kind)
+		}
+		if err := s.setParam(name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setParam decodes a single parameter value
+func (s *Step) setParam(name string, value *yaml.Node) error {
+	var err error
+	switch name {
+	case "model":
+		err = value.Decode(&s.Model)
+	case "lang":
+		err = value.Decode(&s.Lang)
+	case "style":
+		err = value.Decode(&s.Style)
+	case "langs":
+		// A single language may be written without brackets
+		if value.Kind == yaml.ScalarNode {
+			s.Langs = []string{value.Value}
+		} else {
+			err = value.Decode(&s.Langs)
+		}
+	case "factor":
+		err = value.Decode(&s.Factor)
+	case "loudness":
+		err = value.Decode(&s.Loudness)
+	}
+	if err != nil {
+		return fmt.Errorf("line %d: invalid %s for step %s", value.Line, name, s.Kind)
+	}
+	return nil
+}
+
+// Definition describes a pipeline: an input, where outputs go and the steps
+// to run in order
+type Definition struct {
+	Input       string     `yaml:"input"`
+	OutputDir   string     `yaml:"output_dir"`
+	Formats     []string   `yaml:"formats"`            // subtitle formats written, default srt
+	ModelsDir   string     `yaml:"models_dir"`         // where transcribe looks up models by name
+	Translation *mt.Config `yaml:"translation"`        // backend for targets other than English
+	Glossary    string     `yaml:"glossary"`           // terms the backend's translations must follow (.csv, .tsv or .tbx)
+	Memory      string     `yaml:"translation_memory"` // file of translations reused before asking the backend
+	Steps       []Step     `yaml:"steps"`
+}
+
+// Parse parses a YAML or JSON pipeline definition
+func Parse(data []byte) (Definition, error) {
+	var def Definition
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&def); err != nil {
+		return Definition{}, fmt.Errorf("invalid pipeline: %v", err)
+	}
+	if len(def.Formats) == 0 {
+		def.Formats = []string{"srt"}
+	}
+	return def, nil
+}
+
+// Load reads a pipeline definition file. Relative input, output and model
+// paths are resolved against the directory of the file.
+func Load(path string) (Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Definition{}, fmt.Errorf("failed to read pipeline: %v", err)
+	}
+	def, err := Parse(data)
+	if err != nil {
+		return Definition{}, fmt.Errorf("%s: %v", path, err)
+	}
+
+	base := filepath.Dir(path)
+	for _, p := range []*string{&def.Input, &def.OutputDir, &def.ModelsDir, &def.Glossary, &def.Memory} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(base, *p)
+		}
+	}
+	return def, nil
+}
+
+// Validate checks the definition and the order of its steps: audio must be
+// extracted before transcription, and subtitles produced before they are
+// translated, combined, burned or muxed
+func (d Definition) Validate() error {
+	if d.Input == "" {
+		return fmt.Errorf("pipeline has no input")
+	}
+	if len(d.Steps) == 0 {
+		return fmt.Errorf("pipeline has no steps")
+	}
+	for _, f := range d.Formats {
+		if f != "srt" && f != "vtt" && f != "json" {
+			return fmt.Errorf("unknown subtitle format: %s", f)
+		}
+	}
+	if d.Glossary != "" && d.Translation == nil {
+		return fmt.Errorf("glossary requires a translation backend")
+	}
+	if d.Memory != "" && d.Translation == nil {
+		return fmt.Errorf("translation_memory requires a translation backend")
+	}
+
+	extracted, transcribed, spoken := false, false, ""
+	tracks := map[string]bool{}
+	for i, s := range d.Steps {
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("step %d %s: %s", i+1, s, fmt.Sprintf(format, args...))
+		}
+		switch s.Kind {
+		case StepExtract:
+			extracted = true
+		case StepTranscribe:
+			if !extracted {
+				return fail("requires an extract step before it")
+			}
+			if transcribed {
+				return fail("a pipeline transcribes only once")
+			}
+			transcribed = true
+			tracks[""] = true
+			if s.Lang != "" && s.Lang != "auto" {
+				tracks[s.Lang] = true
+				spoken = s.Lang
+			}
+		case StepTranslate:
+			if !transcribed {
+				return fail("requires a transcribe step before it")
+			}
+			if len(s.Langs) == 0 {
+				return fail("no target languages")
+			}
+			for _, lang := range s.Langs {
+				if lang != "en" && d.Translation == nil {
+					return fail("translating to %s requires a translation backend", lang)
+				}
+				tracks[lang] = true
+			}
+		case StepBilingual:
+			if len(s.Langs) == 0 {
+				return fail("no languages")
+			}
+			for _, lang := range s.Langs {
+				if lang == "" || lang == spoken || !tracks[lang] {
+					return fail("no translation into %q before this step", lang)
+				}
+				tracks[BilingualTrack(lang)] = true
+			}
+		case StepBurn:
+			if !tracks[s.Lang] {
+				return fail("no subtitles for %q before this step", s.Lang)
+			}
+			if _, err := s.assOptions(); err != nil {
+				return fail("%v", err)
+			}
+		case StepMux:
+			if !transcribed {
+				return fail("requires a transcribe step before it")
+			}
+			for _, lang := range s.Langs {
+				if !tracks[lang] {
+					return fail("no subtitles for %q before this step", lang)
+				}
+			}
+		case StepSpeed:
+			if s.Factor <= 0 {
+				return fail("speed must be greater than 0")
+			}
+		case StepNormalize:
+			if s.Loudness > 0 {
+				return fail("loudness is in LUFS and must be negative")
+			}
+		}
+	}
+	return nil
+}
+
+// assOptions returns the styles a burn step renders its subtitles with
+func (s Step) assOptions() (subtitle.ASSOptions, error) {
+	style, err := subtitle.ParseASSStyle(s.Style, subtitle.DefaultASSStyle())
+	if err != nil {
+		return subtitle.ASSOptions{}, err
+	}
+	return subtitle.ASSOptions{Styles: []subtitle.ASSStyle{style}}, nil
+}
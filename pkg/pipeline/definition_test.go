@@ -0,0 +1,289 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gleicon/transcoder/pkg/mt"
+	"github.com/gleicon/transcoder/pkg/whisper"
+)
+
+const samplePipeline = `
+input: talk.mp4
+output_dir: out
+formats: [srt, vtt]
+translation:
+  url: http://localhost:5000
+steps:
+  - probe
+  - normalize
+  - extract
+  - transcribe: {model: small, lang: en}
+  - translate: [es, fr]
+  - burn: {lang: es, style: "top,color=#ffdd00"}
+  - mux: [en, fr]
+  - speed: 1.25
+`
+
+func TestParse(t *testing.T) {
+	def, err := Parse([]byte(samplePipeline))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var got []string
+	for _, s := range def.Steps {
+		got = append(got, s.String())
+	}
+	want := "probe normalize extract transcribe(small) translate(es, fr) burn(es) mux(en, fr) speed(1.25)"
+	if strings.Join(got, " ") != want {
+		t.Errorf("steps = %s, want %s", strings.Join(got, " "), want)
+	}
+	if def.Steps[3].Lang != "en" {
+		t.Errorf("transcribe lang = %q, want en", def.Steps[3].Lang)
+	}
+	if def.Steps[5].Style != "top,color=#ffdd00" {
+		t.Errorf("burn style = %q", def.Steps[5].Style)
+	}
+	if def.Translation == nil || def.Translation.URL != "http://localhost:5000" {
+		t.Errorf("translation = %+v", def.Translation)
+	}
+	if err := def.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	data := `{"input": "talk.mp3", "steps": ["extract", {"transcribe": {"model": "auto"}}, {"translate": {"langs": "en"}}]}`
+	def, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(def.Steps) != 3 || def.Steps[1].Model != "auto" || len(def.Steps[2].Langs) != 1 {
+		t.Errorf("steps = %+v", def.Steps)
+	}
+	if len(def.Formats) != 1 || def.Formats[0] != "srt" {
+		t.Errorf("formats = %v, want the srt default", def.Formats)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "unknown step", data: "steps: [resize]", wantErr: `unknown step "resize"`},
+		{name: "unknown parameter", data: "steps: [{speed: {rate: 2}}]", wantErr: `unknown parameter "rate"`},
+		{name: "parameterless step", data: "steps: [{probe: 1}]", wantErr: "takes no parameters"},
+		{name: "bad value", data: "steps: [{speed: fast}]", wantErr: "invalid factor"},
+		{name: "unknown field", data: "inptu: a.mp4", wantErr: "inptu"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	steps := func(s ...Step) Definition {
+		return Definition{Input: "talk.mp4", Formats: []string{"srt"}, Steps: s}
+	}
+	extract := Step{Kind: StepExtract}
+	transcribe := Step{Kind: StepTranscribe, Lang: "en"}
+
+	tests := []struct {
+		name    string
+		def     Definition
+		wantErr string
+	}{
+		{name: "valid", def: steps(extract, transcribe, Step{Kind: StepBurn, Lang: "en"})},
+		{name: "no input", def: Definition{Steps: []Step{extract}}, wantErr: "no input"},
+		{name: "no steps", def: steps(), wantErr: "no steps"},
+		{name: "transcribe first", def: steps(transcribe), wantErr: "requires an extract step"},
+		{name: "translate first", def: steps(extract, Step{Kind: StepTranslate, Langs: []string{"en"}}), wantErr: "requires a transcribe step"},
+		{name: "twice", def: steps(extract, transcribe, transcribe), wantErr: "only once"},
+		{name: "no backend", def: steps(extract, transcribe, Step{Kind: StepTranslate, Langs: []string{"es"}}), wantErr: "requires a translation backend"},
+		{name: "burn missing", def: steps(extract, transcribe, Step{Kind: StepBurn, Lang: "fr"}), wantErr: `no subtitles for "fr"`},
+		{name: "burn style", def: steps(extract, transcribe, Step{Kind: StepBurn, Lang: "en", Style: "top,size=0"}), wantErr: "font size"},
+		{name: "bilingual", def: steps(extract, transcribe, Step{Kind: StepTranslate, Langs: []string{"en"}}, Step{Kind: StepBilingual, Langs: []string{"en"}}), wantErr: `no translation into "en"`},
+		{name: "bilingual missing", def: steps(extract, transcribe, Step{Kind: StepBilingual, Langs: []string{"fr"}}), wantErr: `no translation into "fr"`},
+		{name: "speed", def: steps(Step{Kind: StepSpeed}), wantErr: "greater than 0"},
+		{name: "format", def: Definition{Input: "a", Formats: []string{"ass"}, Steps: []Step{extract}}, wantErr: "unknown subtitle format"},
+		{name: "glossary", def: Definition{Input: "a", Glossary: "terms.csv", Steps: []Step{extract}}, wantErr: "requires a translation backend"},
+		{name: "memory", def: Definition{Input: "a", Memory: "tm.jsonl", Steps: []Step{extract}}, wantErr: "requires a translation backend"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.def.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	def := steps(extract, transcribe, Step{Kind: StepTranslate, Langs: []string{"es"}})
+	def.Translation = &mt.Config{URL: "http://localhost:5000"}
+	if err := def.Validate(); err != nil {
+		t.Errorf("Validate() with a backend error = %v", err)
+	}
+
+	def.Steps = append(def.Steps, Step{Kind: StepBilingual, Langs: []string{"es"}}, Step{Kind: StepBurn, Lang: "es.bilingual"})
+	if err := def.Validate(); err != nil {
+		t.Errorf("Validate() burning a bilingual track error = %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pipeline.yaml")
+	if err := os.WriteFile(path, []byte(samplePipeline), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	def, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if def.Input != filepath.Join(dir, "talk.mp4") || def.OutputDir != filepath.Join(dir, "out") {
+		t.Errorf("paths not resolved against the file: %q, %q", def.Input, def.OutputDir)
+	}
+}
+
+func TestModelConfig(t *testing.T) {
+	base := whisper.DefaultConfig()
+	base.ModelDir = "/models"
+
+	if c := modelConfig(base, "small", ""); c.ModelPath != "/models/ggml-small.bin" || c.Model != "" {
+		t.Errorf("named model: %+v", c)
+	}
+	if c := modelConfig(base, "auto", "/other"); c.Model != whisper.AutoModel || c.ModelDir != "/other" {
+		t.Errorf("auto model: %+v", c)
+	}
+	if c := modelConfig(base, "custom.bin", ""); c.ModelPath != "custom.bin" {
+		t.Errorf("model file: %+v", c)
+	}
+	if c := modelConfig(base, "", ""); c.ModelPath != base.ModelPath {
+		t.Errorf("default model: %+v", c)
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	if got := outputName("talk", "", "srt"); got != "talk.srt" {
+		t.Errorf("outputName() = %q", got)
+	}
+	if got := outputName("talk", "es", "vtt"); got != "talk.es.vtt" {
+		t.Errorf("outputName() = %q", got)
+	}
+	if got := outputName("talk", BilingualTrack("es"), "vtt"); got != "talk.es.bilingual.vtt" {
+		t.Errorf("outputName() = %q", got)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	def, err := Parse([]byte(samplePipeline))
+	if err != nil {
+		t.Fatal(err)
+	}
+	def.Steps = append(def.Steps[:6], Step{Kind: StepSpeed, Factor: 2})
+	def.Input = "/media/talk.mp4"
+	def.OutputDir = "/out"
+
+	st := &state{def: def, outDir: def.OutputDir, base: "talk", lang: "en", backend: &mtStub{}}
+	g, result := st.plan()
+	if err := g.Validate(); err != nil {
+		t.Fatalf("planned graph is invalid: %v", err)
+	}
+
+	needs := make(map[string][]string)
+	for _, s := range g.Stages() {
+		for _, p := range s.Needs {
+			needs[s.Name] = append(needs[s.Name], p.Name)
+		}
+	}
+	tests := map[string]string{
+		"step 3 extract":           "media.2",
+		"step 4 transcribe(small)": "audio.3",
+		"step 5 translate(es)":     "subtitles",
+		"step 5 translate(fr)":     "subtitles",
+		"step 6 burn(es)":          "media.2 subtitles.es",
+		// The speed change only reads the burned video, not the other translations
+		"step 7 speed(2)": "media.6",
+	}
+	for stage, want := range tests {
+		if got := strings.Join(needs[stage], " "); got != want {
+			t.Errorf("%s needs %q, want %q", stage, got, want)
+		}
+	}
+
+	want := "/out/talk.srt /out/talk.vtt /out/talk.es.srt /out/talk.es.vtt /out/talk.fr.srt /out/talk.fr.vtt"
+	if got := strings.Join(result.Subtitles, " "); got != want {
+		t.Errorf("Subtitles = %s, want %s", got, want)
+	}
+	if result.Media != "/out/talk.processed.mp4" {
+		t.Errorf("Media = %s", result.Media)
+	}
+}
+
+func TestPlanBilingual(t *testing.T) {
+	def, err := Parse([]byte(`
+input: /media/talk.mp4
+output_dir: /out
+translation:
+  url: http://localhost:5000
+steps:
+  - extract
+  - transcribe: {lang: en}
+  - translate: [es]
+  - bilingual: es
+  - mux: [en, es.bilingual]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := &state{def: def, outDir: def.OutputDir, base: "talk", lang: "en", backend: &mtStub{}}
+	g, result := st.plan()
+	if err := g.Validate(); err != nil {
+		t.Fatalf("planned graph is invalid: %v", err)
+	}
+
+	needs := make(map[string][]string)
+	for _, s := range g.Stages() {
+		for _, p := range s.Needs {
+			needs[s.Name] = append(needs[s.Name], p.Name)
+		}
+	}
+	if got := strings.Join(needs["step 4 bilingual(es)"], " "); got != "subtitles subtitles.es" {
+		t.Errorf("bilingual step needs %q", got)
+	}
+	if got := strings.Join(needs["step 5 mux(en, es.bilingual)"], " "); got != "media subtitles subtitles.es.bilingual" {
+		t.Errorf("mux step needs %q", got)
+	}
+
+	want := "/out/talk.srt /out/talk.es.srt /out/talk.es.bilingual.srt"
+	if got := strings.Join(result.Subtitles, " "); got != want {
+		t.Errorf("Subtitles = %s, want %s", got, want)
+	}
+}
+
+type mtStub struct{}
+
+func (mtStub) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	return texts, nil
+}
@@ -0,0 +1,588 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/atomicfile"
+	"github.com/gleicon/transcoder/pkg/engine"
+	"github.com/gleicon/transcoder/pkg/ffmpeg"
+	"github.com/gleicon/transcoder/pkg/logging"
+	"github.com/gleicon/transcoder/pkg/mt"
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/subtitle"
+	"github.com/gleicon/transcoder/pkg/translation"
+	"github.com/gleicon/transcoder/pkg/whisper"
+	"github.com/gleicon/transcoder/pkg/workspace"
+)
+
+// Runner executes pipeline definitions
+type Runner struct {
+	// Whisper is the base configuration of transcribe steps, whose model and
+	// language parameters override it
+	Whisper whisper.Config
+	// NewTranslator creates the translator used by transcribe and translate
+	// steps, defaults to translation.NewWithConfig
+	NewTranslator func(config whisper.Config) (*translation.Translator, error)
+	// Logger receives a record when each step starts and finishes, defaults
+	// to the default logger
+	Logger *slog.Logger
+	// Workspace configures where intermediate files are kept
+	Workspace workspace.Config
+	// Policy limits and retries the external operations of the steps,
+	// defaults to policy.Default()
+	Policy *policy.Policy
+}
+
+// Result lists what a pipeline produced
+type Result struct {
+	Probe     *ffmpeg.ProbeResult `json:"probe,omitempty"`
+	Subtitles []string            `json:"subtitles"`       // subtitle files in every format and language
+	Media     string              `json:"media,omitempty"` // the processed media file, if a step changed it
+	Language  string              `json:"language,omitempty"`
+	Glossary  []mt.Violation      `json:"glossary,omitempty"` // translated cues that break the glossary
+	Memory    *mt.MemoryMatches   `json:"memory,omitempty"`   // cues translated from the translation memory
+	// Timings holds the wall time of every stage
+	Timings map[string]time.Duration `json:"-"`
+}
+
+// state is shared by the stages of a running pipeline
+type state struct {
+	def    Definition
+	outDir string
+	base   string
+	lang   string // spoken language, empty if unknown
+
+	ffmpeg     *ffmpeg.FFmpeg
+	translator *translation.Translator
+	backend    mt.Backend // translates the transcript, nil to have whisper translate the audio
+	logger     *slog.Logger
+
+	mu    sync.Mutex
+	probe *ffmpeg.ProbeResult
+}
+
+// Run validates def and runs its steps. Each step only waits for the steps
+// whose output it reads, so independent steps such as translations into
+// several languages, or a speed change and a transcription, run concurrently.
+func (r *Runner) Run(ctx context.Context, def Definition) (*Result, error) {
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(def.Input); err != nil {
+		return nil, fmt.Errorf("input file not found: %s", def.Input)
+	}
+
+	st := &state{
+		def:    def,
+		outDir: def.OutputDir,
+		base:   strings.TrimSuffix(filepath.Base(def.Input), filepath.Ext(def.Input)),
+		logger: r.Logger,
+	}
+	if st.outDir == "" {
+		st.outDir = filepath.Dir(def.Input)
+	}
+	if err := os.MkdirAll(st.outDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	limits := r.Policy
+	if limits == nil {
+		limits = policy.Default()
+	}
+
+	var err error
+	if st.ffmpeg, err = ffmpeg.New(); err != nil {
+		return nil, err
+	}
+	st.ffmpeg.Policy = limits
+	if def.Translation != nil {
+		if st.backend, err = mt.New(*def.Translation); err != nil {
+			return nil, err
+		}
+	}
+	var glossary *mt.Glossary
+	if def.Glossary != "" {
+		if glossary, err = mt.LoadGlossary(def.Glossary); err != nil {
+			return nil, err
+		}
+	}
+	var memory *mt.Memory
+	if def.Memory != "" {
+		if memory, err = mt.OpenMemory(def.Memory); err != nil {
+			return nil, err
+		}
+	}
+
+	// The translator is created up front so extraction can use its cache
+	for _, step := range def.Steps {
+		if step.Kind != StepTranscribe {
+			continue
+		}
+		config := modelConfig(r.Whisper, step.Model, def.ModelsDir)
+		if step.Lang != "" {
+			config.Language = step.Lang
+		}
+		newTranslator := r.NewTranslator
+		if newTranslator == nil {
+			newTranslator = translation.NewWithConfig
+		}
+		if st.translator, err = newTranslator(config); err != nil {
+			return nil, fmt.Errorf("failed to create translator: %w", err)
+		}
+		defer st.translator.Close()
+		st.translator.SetPolicy(limits)
+		st.translator.SetBackend(st.backend)
+		st.translator.SetGlossary(glossary)
+		st.translator.SetMemory(memory)
+		if config.Language != "" && config.Language != "auto" {
+			st.lang = config.Language
+		}
+	}
+
+	ws, err := workspace.Create(r.Workspace, st.need())
+	if err != nil {
+		return nil, err
+	}
+
+	g, result := st.plan()
+	g.Workspace = ws
+	// The translator records glossary violations and memory matches for the result
+	stats := &translation.Stats{}
+	run, err := g.Run(translation.WithStats(ctx, stats))
+	logger := logging.From(ctx, st.logger)
+	if cerr := ws.Close(err); cerr != nil {
+		logger.Error("failed to clean up workspace", "error", cerr)
+	}
+	if ws.Kept() {
+		logger.Warn("intermediate files kept", "dir", ws.Dir())
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Probe = st.probe
+	result.Language = st.lang
+	result.Glossary = stats.Glossary
+	if stats.Memory != (mt.MemoryMatches{}) {
+		result.Memory = &stats.Memory
+	}
+	result.Timings = run.Timings
+	return result, nil
+}
+
+// need estimates the workspace space a run takes
+func (st *state) need() int64 {
+	info, err := os.Stat(st.def.Input)
+	if err != nil {
+		return 0
+	}
+	copies := 0
+	for _, s := range st.def.Steps {
+		switch s.Kind {
+		case StepNormalize, StepBurn, StepMux, StepSpeed:
+			copies++
+		}
+	}
+	// A copy is deleted once the next step has read it, so at most two exist at a time
+	return workspace.MediaNeed(info.Size(), 0, min(copies, 2))
+}
+
+// planner turns the ordered steps of a definition into a stage graph whose
+// edges follow the artifacts each step reads
+type planner struct {
+	st     *state
+	g      *engine.Graph
+	media  string            // artifact holding the current media
+	audio  string            // artifact holding the extracted audio
+	tracks map[string]string // subtitle artifacts by language, "" is the transcript, see also BilingualTrack
+}
+
+// plan builds the stage graph and the result it will fill in
+func (st *state) plan() (*engine.Graph, *Result) {
+	p := &planner{st: st, g: engine.New(), media: "media", tracks: make(map[string]string)}
+	p.g.Provide("media", engine.KindMedia, st.def.Input)
+
+	for i, step := range st.def.Steps {
+		p.add(i, step)
+	}
+
+	// Publish the subtitle tracks in every format and the processed media
+	result := &Result{}
+	langs := append([]string{""}, translationOrder(st.def)...)
+	langs = append(langs, bilingualOrder(st.def)...)
+	published := make(map[string]bool)
+	for _, lang := range langs {
+		name, ok := p.tracks[lang]
+		if !ok || published[name] {
+			continue
+		}
+		published[name] = true
+		var paths []string
+		var makes []engine.Port
+		for _, format := range st.def.Formats {
+			path := filepath.Join(st.outDir, outputName(st.base, lang, format))
+			paths = append(paths, path)
+			makes = append(makes, engine.Port{Name: path, Kind: engine.KindFile})
+		}
+		result.Subtitles = append(result.Subtitles, paths...)
+		p.stage("write "+outputName(st.base, lang, "*"), []engine.Port{{Name: name, Kind: engine.KindSubtitles}}, makes,
+			func(ctx context.Context, in map[string]engine.Artifact, dir string) (map[string]string, error) {
+				track, err := subtitle.ReadFile(in[name].Path)
+				if err != nil {
+					return nil, err
+				}
+				out := make(map[string]string)
+				for _, path := range paths {
+					if err := subtitle.WriteFile(path, track); err != nil {
+						return nil, err
+					}
+					out[path] = path
+				}
+				return out, nil
+			})
+	}
+
+	if p.media != "media" {
+		media := p.media
+		path := filepath.Join(st.outDir, st.base+".processed"+mediaExt(st.def))
+		result.Media = path
+		p.stage("save "+filepath.Base(path), []engine.Port{{Name: media, Kind: engine.KindMedia}}, []engine.Port{{Name: path, Kind: engine.KindMedia}},
+			func(ctx context.Context, in map[string]engine.Artifact, dir string) (map[string]string, error) {
+				return map[string]string{path: path}, moveFile(in[media].Path, path)
+			})
+	}
+	return p.g, result
+}
+
+// stage adds a stage that logs when it starts and finishes
+func (p *planner) stage(name string, needs, makes []engine.Port, run engine.StageFunc) {
+	logger := p.st.logger
+	p.g.Add(engine.Stage{
+		Name:  name,
+		Needs: needs,
+		Makes: makes,
+		Run: func(ctx context.Context, in map[string]engine.Artifact, dir string) (map[string]string, error) {
+			start := time.Now()
+			ctx = logging.WithAttrs(ctx, "step", name)
+			logging.From(ctx, logger).Info("step started")
+			out, err := run(ctx, in, dir)
+			if err == nil {
+				logging.From(ctx, logger).Info("step done", "duration", time.Since(start).Round(time.Millisecond))
+			}
+			return out, err
+		},
+	})
+}
+
+// mediaStage adds a step that reads the current media and replaces it
+func (p *planner) mediaStage(i int, step Step, extra []engine.Port, run func(ctx context.Context, in map[string]engine.Artifact, input, output string) error) {
+	input := p.media
+	output := fmt.Sprintf("media.%d", i+1)
+	ext := mediaExt(p.st.def)
+	p.media = output
+	needs := append([]engine.Port{{Name: input, Kind: engine.KindMedia}}, extra...)
+	p.stage(stepName(i, step), needs, []engine.Port{{Name: output, Kind: engine.KindMedia}},
+		func(ctx context.Context, in map[string]engine.Artifact, dir string) (map[string]string, error) {
+			path := filepath.Join(dir, fmt.Sprintf("%d-%s%s", i+1, step.Kind, ext))
+			if err := run(ctx, in, in[input].Path, path); err != nil {
+				return nil, err
+			}
+			return map[string]string{output: path}, nil
+		})
+}
+
+// add adds the stages of a step
+func (p *planner) add(i int, step Step) {
+	st := p.st
+	switch step.Kind {
+	case StepProbe:
+		media := p.media
+		p.stage(stepName(i, step), []engine.Port{{Name: media, Kind: engine.KindMedia}}, nil,
+			func(ctx context.Context, in map[string]engine.Artifact, dir string) (map[string]string, error) {
+				return nil, st.checkMedia(ctx, in[media].Path)
+			})
+
+	case StepNormalize:
+		p.mediaStage(i, step, nil, func(ctx context.Context, in map[string]engine.Artifact, input, output string) error {
+			return st.ffmpeg.NormalizeAudio(ctx, input, output, step.Loudness)
+		})
+
+	case StepExtract:
+		media := p.media
+		p.audio = fmt.Sprintf("audio.%d", i+1)
+		audio := p.audio
+		p.stage(stepName(i, step), []engine.Port{{Name: media, Kind: engine.KindMedia}}, []engine.Port{{Name: audio, Kind: engine.KindAudio}},
+			func(ctx context.Context, in map[string]engine.Artifact, dir string) (map[string]string, error) {
+				path := filepath.Join(dir, fmt.Sprintf("%d-audio.wav", i+1))
+				var err error
+				if st.translator != nil {
+					err = st.translator.ExtractAudio(ctx, in[media].Path, path)
+				} else {
+					err = st.ffmpeg.ExtractAudio(ctx, in[media].Path, path)
+				}
+				return map[string]string{audio: path}, err
+			})
+
+	case StepTranscribe:
+		audio := p.audio
+		p.tracks[""] = "subtitles"
+		if st.lang != "" {
+			p.tracks[st.lang] = "subtitles"
+		}
+		p.stage(stepName(i, step), []engine.Port{{Name: audio, Kind: engine.KindAudio}}, []engine.Port{{Name: "subtitles", Kind: engine.KindSubtitles}},
+			func(ctx context.Context, in map[string]engine.Artifact, dir string) (map[string]string, error) {
+				path := filepath.Join(dir, "transcript.srt")
+				return map[string]string{"subtitles": path}, st.translator.Transcribe(ctx, in[audio].Path, path)
+			})
+
+	case StepTranslate:
+		// One stage per language, so the targets are translated concurrently
+		for _, lang := range step.Langs {
+			if _, ok := p.tracks[lang]; ok {
+				continue
+			}
+			name := "subtitles." + lang
+			p.tracks[lang] = name
+			// Without a backend Validate only lets English through, which whisper translates from the audio
+			need := engine.Port{Name: p.tracks[""], Kind: engine.KindSubtitles}
+			if st.backend == nil {
+				need = engine.Port{Name: p.audio, Kind: engine.KindAudio}
+			}
+			p.stage(fmt.Sprintf("step %d translate(%s)", i+1, lang), []engine.Port{need}, []engine.Port{{Name: name, Kind: engine.KindSubtitles}},
+				func(ctx context.Context, in map[string]engine.Artifact, dir string) (map[string]string, error) {
+					path := filepath.Join(dir, "transcript."+lang+".srt")
+					return map[string]string{name: path}, st.translate(ctx, in[need.Name].Path, lang, path)
+				})
+		}
+
+	case StepBilingual:
+		for _, lang := range step.Langs {
+			key := BilingualTrack(lang)
+			if _, ok := p.tracks[key]; ok {
+				continue
+			}
+			name := "subtitles." + key
+			p.tracks[key] = name
+			transcript, translation := p.tracks[""], p.tracks[lang]
+			needs := []engine.Port{{Name: transcript, Kind: engine.KindSubtitles}, {Name: translation, Kind: engine.KindSubtitles}}
+			p.stage(fmt.Sprintf("step %d bilingual(%s)", i+1, lang), needs, []engine.Port{{Name: name, Kind: engine.KindSubtitles}},
+				func(ctx context.Context, in map[string]engine.Artifact, dir string) (map[string]string, error) {
+					// WebVTT keeps the translated lines apart from the transcript
+					path := filepath.Join(dir, "transcript."+key+".vtt")
+					return map[string]string{name: path}, combine(in[transcript].Path, in[translation].Path, path)
+				})
+		}
+
+	case StepBurn:
+		track := p.tracks[step.Lang]
+		style, _ := step.assOptions() // checked by Validate
+		p.mediaStage(i, step, []engine.Port{{Name: track, Kind: engine.KindSubtitles}},
+			func(ctx context.Context, in map[string]engine.Artifact, input, output string) error {
+				return st.ffmpeg.BurnSubtitlesWith(ctx, input, in[track].Path, output, style)
+			})
+
+	case StepMux:
+		langs := step.Langs
+		if len(langs) == 0 {
+			langs = p.languages()
+		}
+		var needs []engine.Port
+		var names, codes []string
+		for _, lang := range langs {
+			needs = append(needs, engine.Port{Name: p.tracks[lang], Kind: engine.KindSubtitles})
+			names = append(names, p.tracks[lang])
+			if lang == "" {
+				lang = st.lang
+			}
+			codes = append(codes, strings.TrimSuffix(lang, BilingualTrack("")))
+		}
+		p.mediaStage(i, step, dedupe(needs), func(ctx context.Context, in map[string]engine.Artifact, input, output string) error {
+			streams := make([]ffmpeg.SubtitleStream, len(names))
+			for i, name := range names {
+				streams[i] = ffmpeg.SubtitleStream{Path: in[name].Path, Language: codes[i]}
+			}
+			return st.ffmpeg.MuxSubtitles(ctx, input, output, streams)
+		})
+
+	case StepSpeed:
+		p.mediaStage(i, step, nil, func(ctx context.Context, in map[string]engine.Artifact, input, output string) error {
+			return st.ffmpeg.ChangeSpeed(ctx, input, output, step.Factor)
+		})
+	}
+}
+
+// languages returns the languages of the subtitle tracks planned so far, the
+// transcript first
+func (p *planner) languages() []string {
+	langs := []string{""}
+	for _, lang := range translationOrder(p.st.def) {
+		if name, ok := p.tracks[lang]; ok && name != p.tracks[""] {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// checkMedia probes the media and checks it has the streams later steps need
+func (st *state) checkMedia(ctx context.Context, path string) error {
+	probe, err := st.ffmpeg.Probe(ctx, path)
+	if err != nil {
+		return err
+	}
+	if !probe.HasAudio() {
+		return fmt.Errorf("%s has no audio stream", st.def.Input)
+	}
+	for _, s := range st.def.Steps {
+		if (s.Kind == StepBurn || s.Kind == StepMux || s.Kind == StepSpeed) && !probe.HasVideo() {
+			return fmt.Errorf("%s has no video stream for the %s step", st.def.Input, s.Kind)
+		}
+	}
+	st.mu.Lock()
+	st.probe = probe
+	st.mu.Unlock()
+	return nil
+}
+
+// translate writes a translation into lang to out. With a backend, source is
+// the transcript, which the translator translates cue by cue; without one it
+// is the audio, which whisper translates.
+func (st *state) translate(ctx context.Context, source, lang, out string) error {
+	if st.backend == nil {
+		return st.translator.Translate(ctx, source, out, lang)
+	}
+
+	track, err := subtitle.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	translated, err := st.translator.TranslateTrack(ctx, track, st.lang, lang)
+	if err != nil {
+		return err
+	}
+	return subtitle.WriteFile(out, translated)
+}
+
+// combine writes the transcript with its translation below every cue to out
+func combine(transcript, translation, out string) error {
+	primary, err := subtitle.ReadFile(transcript)
+	if err != nil {
+		return err
+	}
+	secondary, err := subtitle.ReadFile(translation)
+	if err != nil {
+		return err
+	}
+	return subtitle.WriteFile(out, subtitle.Bilingual(primary, secondary))
+}
+
+// stepName names the stage of a step for logs and errors
+func stepName(i int, step Step) string {
+	return fmt.Sprintf("step %d %s", i+1, step)
+}
+
+// mediaExt returns the extension of the media files a pipeline produces
+func mediaExt(def Definition) string {
+	return filepath.Ext(def.Input)
+}
+
+// dedupe removes repeated ports, e.g. a transcript muxed under two languages
+func dedupe(ports []engine.Port) []engine.Port {
+	seen := make(map[string]bool)
+	var out []engine.Port
+	for _, p := range ports {
+		if !seen[p.Name] {
+			seen[p.Name] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// translationOrder lists the translation targets in the order they appear
+func translationOrder(def Definition) []string {
+	var langs []string
+	for _, s := range def.Steps {
+		if s.Kind == StepTranslate {
+			langs = append(langs, s.Langs...)
+		}
+	}
+	return langs
+}
+
+// bilingualOrder lists the bilingual tracks in the order they appear
+func bilingualOrder(def Definition) []string {
+	var tracks []string
+	for _, s := range def.Steps {
+		if s.Kind == StepBilingual {
+			for _, lang := range s.Langs {
+				tracks = append(tracks, BilingualTrack(lang))
+			}
+		}
+	}
+	return tracks
+}
+
+// outputName returns the file name of a subtitle track: <base>.<format> for
+// the transcript, <base>.<lang>.<format> for translations and
+// <base>.<lang>.bilingual.<format> for bilingual tracks
+func outputName(base, lang, format string) string {
+	if lang == "" {
+		return base + "." + format
+	}
+	return base + "." + lang + "." + format
+}
+
+// modelConfig applies a transcribe step's model parameter to config: "auto"
+// selects a model, a bare name such as "small" is looked up in modelsDir, and
+// anything else is a model file
+func modelConfig(config whisper.Config, model, modelsDir string) whisper.Config {
+	if modelsDir != "" {
+		config.ModelDir = modelsDir
+	}
+	switch {
+	case model == "":
+	case model == whisper.AutoModel:
+		config.Model = whisper.AutoModel
+	case !strings.ContainsRune(model, filepath.Separator) && filepath.Ext(model) != ".bin":
+		config.Model = ""
+		config.ModelPath = filepath.Join(config.ModelDir, "ggml-"+model+".bin")
+	default:
+		config.Model = ""
+		config.ModelPath = model
+	}
+	return config
+}
+
+// moveFile renames src to dst, copying when they are on different filesystems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", src, err)
+	}
+	defer in.Close()
+	err = atomicfile.Write(dst, func(tmp string) error {
+		out, err := os.Create(tmp)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", dst, err)
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,269 @@
+// Package policy bounds external operations: every attempt at running ffmpeg,
+// whisper-cli or a translation request gets a timeout scaled by the duration
+// of the media, and attempts that fail for transient reasons are retried with
+// exponential backoff.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operation is a kind of external operation with its own timeout
+type Operation string
+
+const (
+	Extract    Operation = "extract"    // audio extraction with ffmpeg
+	Transcribe Operation = "transcribe" // whisper-cli, with or without translation
+	Translate  Operation = "translate"  // a request to a machine translation backend
+	Encode     Operation = "encode"     // ffmpeg re-encoding: normalize, burn, mux, speed
+)
+
+// Operations lists every operation
+var Operations = []Operation{Extract, Transcribe, Translate, Encode}
+
+// Timeout limits an attempt to Base plus Factor times the media duration. The
+// zero value means no limit.
+type Timeout struct {
+	Base   time.Duration
+	Factor float64
+}
+
+// For returns the limit for media of the given duration, 0 for none. With an
+// unknown duration only Base applies.
+func (t Timeout) For(duration time.Duration) time.Duration {
+	return t.Base + time.Duration(t.Factor*float64(duration))
+}
+
+// String formats the timeout as parsed by ParseTimeout
+func (t Timeout) String() string {
+	base := t.Base.String()
+	// 5m0s reads better as 5m
+	if strings.HasSuffix(base, "m0s") {
+		base = strings.TrimSuffix(base, "0s")
+	}
+	if strings.HasSuffix(base, "h0m") {
+		base = strings.TrimSuffix(base, "0m")
+	}
+	factor := strconv.FormatFloat(t.Factor, 'g', -1, 64) + "x"
+	switch {
+	case t.Base == 0 && t.Factor == 0:
+		return "none"
+	case t.Factor == 0:
+		return base
+	case t.Base == 0:
+		return factor
+	}
+	return base + "+" + factor
+}
+
+// ParseTimeout parses "none", a duration ("10m"), a multiple of the media
+// duration ("3x") or both ("5m+3x")
+func ParseTimeout(s string) (Timeout, error) {
+	var t Timeout
+	if s == "none" || s == "0" {
+		return t, nil
+	}
+	for _, part := range strings.Split(s, "+") {
+		part = strings.TrimSpace(part)
+		if f, ok := strings.CutSuffix(part, "x"); ok {
+			factor, err := strconv.ParseFloat(f, 64)
+			if err != nil || factor < 0 {
+				return t, fmt.Errorf("invalid timeout %q: bad factor %q", s, part)
+			}
+			t.Factor += factor
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil || d < 0 {
+			return t, fmt.Errorf("invalid timeout %q: bad duration %q", s, part)
+		}
+		t.Base += d
+	}
+	return t, nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (t *Timeout) UnmarshalText(text []byte) error {
+	parsed, err := ParseTimeout(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// Retry describes how failed attempts are retried
+type Retry struct {
+	Attempts   int           // total attempts, 1 or less disables retries
+	Backoff    time.Duration // delay before the first retry, doubled for each following one
+	MaxBackoff time.Duration // upper bound of the delay, 0 for none
+}
+
+// delay returns the wait before the given retry, counted from 1
+func (r Retry) delay(retry int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if r.MaxBackoff > 0 && d >= r.MaxBackoff {
+			break
+		}
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
+
+// Policy holds the timeouts and retries of external operations. A nil Policy
+// runs every operation once without a limit.
+type Policy struct {
+	Timeouts map[Operation]Timeout
+	Retry    Retry
+	// OnRetry, if set, is called before waiting to retry a failed attempt
+	OnRetry func(op Operation, attempt int, err error, wait time.Duration)
+}
+
+// Default returns limits generous enough for large models on a CPU while
+// still stopping a tool that hangs
+func Default() *Policy {
+	return &Policy{
+		Timeouts: map[Operation]Timeout{
+			Extract:    {Base: 2 * time.Minute, Factor: 0.5},
+			Transcribe: {Base: 5 * time.Minute, Factor: 4},
+			Translate:  {Base: 2 * time.Minute},
+			Encode:     {Base: 5 * time.Minute, Factor: 4},
+		},
+		Retry: Retry{Attempts: 3, Backoff: 2 * time.Second, MaxBackoff: 30 * time.Second},
+	}
+}
+
+// Run calls fn until it succeeds, fails permanently or runs out of attempts.
+// Each attempt gets a context limited by the timeout of op for media of the
+// given duration (0 if unknown). An attempt that times out is transient.
+func (p *Policy) Run(ctx context.Context, op Operation, duration time.Duration, fn func(ctx context.Context) error) error {
+	if p == nil {
+		return fn(ctx)
+	}
+	limit := p.Timeouts[op].For(duration)
+
+	for attempt := 1; ; attempt++ {
+		err := p.attempt(ctx, limit, fn)
+		if err == nil || ctx.Err() != nil || attempt >= p.Retry.Attempts || !Transient(err) {
+			return err
+		}
+
+		wait := p.Retry.delay(attempt)
+		if p.OnRetry != nil {
+			p.OnRetry(op, attempt, err, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// attempt runs fn once, within limit if set
+func (p *Policy) attempt(ctx context.Context, limit time.Duration, fn func(ctx context.Context) error) error {
+	if limit <= 0 {
+		return fn(ctx)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, limit)
+	defer cancel()
+	err := fn(attemptCtx)
+	if err != nil && ctx.Err() == nil && attemptCtx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{Limit: limit, Err: err}
+	}
+	return err
+}
+
+// String formats the timeouts of p as accepted by ParseTimeouts
+func (p *Policy) String() string {
+	var parts []string
+	for _, op := range Operations {
+		if t, ok := p.Timeouts[op]; ok {
+			parts = append(parts, string(op)+"="+t.String())
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseTimeouts parses a comma separated list of operation=timeout pairs,
+// such as "transcribe=10m+4x,translate=30s", into timeouts
+func ParseTimeouts(s string, timeouts map[Operation]Timeout) error {
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid timeout %q, want operation=timeout", pair)
+		}
+		op := Operation(strings.TrimSpace(name))
+		if !known(op) {
+			names := make([]string, len(Operations))
+			for i, o := range Operations {
+				names[i] = string(o)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown operation %q, want one of %s", op, strings.Join(names, ", "))
+		}
+		t, err := ParseTimeout(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		timeouts[op] = t
+	}
+	return nil
+}
+
+// known reports whether op is a valid operation
+func known(op Operation) bool {
+	for _, o := range Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// TimeoutError reports an attempt stopped by its timeout
+type TimeoutError struct {
+	Limit time.Duration
+	Err   error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s: %v", e.Limit, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Transient reports whether err may go away by retrying: a timeout, a network
+// error, or an error that says so with a Transient() bool method, such as a
+// tool that crashed or an HTTP 503
+func Transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var timeout *TimeoutError
+	if errors.As(err, &timeout) {
+		return true
+	}
+	var t interface{ Transient() bool }
+	if errors.As(err, &t) {
+		return t.Transient()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
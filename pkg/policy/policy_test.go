@@ -0,0 +1,167 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Timeout
+		str  string
+	}{
+		{in: "none", want: Timeout{}, str: "none"},
+		{in: "10m", want: Timeout{Base: 10 * time.Minute}, str: "10m"},
+		{in: "3x", want: Timeout{Factor: 3}, str: "3x"},
+		{in: "5m+2.5x", want: Timeout{Base: 5 * time.Minute, Factor: 2.5}, str: "5m+2.5x"},
+		{in: "1h30s", want: Timeout{Base: time.Hour + 30*time.Second}, str: "1h0m30s"},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimeout(tt.in)
+		if err != nil {
+			t.Errorf("ParseTimeout(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTimeout(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if got.String() != tt.str {
+			t.Errorf("String() = %q, want %q", got.String(), tt.str)
+		}
+	}
+
+	for _, in := range []string{"fast", "-1m", "x", "2y"} {
+		if _, err := ParseTimeout(in); err == nil {
+			t.Errorf("ParseTimeout(%q) succeeded", in)
+		}
+	}
+
+	if got := (Timeout{Base: time.Minute, Factor: 2}).For(10 * time.Minute); got != 21*time.Minute {
+		t.Errorf("For() = %s, want 21m", got)
+	}
+}
+
+func TestParseTimeouts(t *testing.T) {
+	timeouts := Default().Timeouts
+	if err := ParseTimeouts("transcribe=10m+6x, translate=none", timeouts); err != nil {
+		t.Fatalf("ParseTimeouts() error = %v", err)
+	}
+	if timeouts[Transcribe] != (Timeout{Base: 10 * time.Minute, Factor: 6}) || timeouts[Translate] != (Timeout{}) {
+		t.Errorf("timeouts = %v", timeouts)
+	}
+	if timeouts[Extract] != Default().Timeouts[Extract] {
+		t.Error("ParseTimeouts() changed an operation it was not given")
+	}
+
+	err := ParseTimeouts("resize=1m", timeouts)
+	if err == nil || !strings.Contains(err.Error(), `unknown operation "resize"`) {
+		t.Errorf("ParseTimeouts() error = %v", err)
+	}
+}
+
+// transientError is an error that declares itself transient or not
+type transientError bool
+
+func (e transientError) Error() string   { return "tool failed" }
+func (e transientError) Transient() bool { return bool(e) }
+
+func TestRun(t *testing.T) {
+	p := &Policy{Retry: Retry{Attempts: 3, Backoff: time.Millisecond}}
+	var retries []int
+	p.OnRetry = func(op Operation, attempt int, err error, wait time.Duration) {
+		retries = append(retries, attempt)
+	}
+
+	calls := 0
+	err := p.Run(context.Background(), Extract, 0, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return transientError(true)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 || len(retries) != 2 {
+		t.Errorf("transient failures: err = %v, calls = %d, retries = %v", err, calls, retries)
+	}
+
+	calls = 0
+	err = p.Run(context.Background(), Extract, 0, func(ctx context.Context) error {
+		calls++
+		return transientError(false)
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("permanent failure: err = %v, calls = %d, want a single attempt", err, calls)
+	}
+
+	calls = 0
+	err = p.Run(context.Background(), Extract, 0, func(ctx context.Context) error {
+		calls++
+		return transientError(true)
+	})
+	if err == nil || calls != 3 {
+		t.Errorf("persistent failure: err = %v, calls = %d, want 3 attempts", err, calls)
+	}
+
+	var nilPolicy *Policy
+	if err := nilPolicy.Run(context.Background(), Extract, 0, func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); ok {
+			t.Error("nil policy set a deadline")
+		}
+		return nil
+	}); err != nil {
+		t.Errorf("nil policy: %v", err)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	p := &Policy{
+		Timeouts: map[Operation]Timeout{Transcribe: {Factor: 0.001}},
+		Retry:    Retry{Attempts: 2},
+	}
+
+	// 20s of media allows 20ms per attempt; a hung tool is stopped and retried
+	calls := 0
+	err := p.Run(context.Background(), Transcribe, 20*time.Second, func(ctx context.Context) error {
+		calls++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) || timeout.Limit != 20*time.Millisecond {
+		t.Errorf("Run() error = %v, want a 20ms timeout", err)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want the timed out attempt retried", calls)
+	}
+
+	// Cancelling the caller's context is not retried
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	err = p.Run(ctx, Transcribe, 0, func(ctx context.Context) error {
+		calls++
+		cancel()
+		return transientError(true)
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("cancelled: err = %v, calls = %d", err, calls)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	r := Retry{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	var got []time.Duration
+	for i := 1; i <= 5; i++ {
+		got = append(got, r.delay(i))
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("delays = %v, want %v", got, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package proc
+
+import (
+	"os/exec"
+	"time"
+)
+
+// setGroup keeps the default of killing only cmd where process groups are
+// not available
+func setGroup(cmd *exec.Cmd, grace time.Duration) {}
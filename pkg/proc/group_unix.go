@@ -0,0 +1,29 @@
+//go:build unix
+
+package proc
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setGroup starts cmd in a new process group and makes cancellation signal
+// the whole group, escalating to SIGKILL after grace
+func setGroup(cmd *exec.Cmd, grace time.Duration) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+		if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+			if errors.Is(err, syscall.ESRCH) {
+				return nil
+			}
+			return err
+		}
+		time.AfterFunc(grace, func() {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		})
+		return nil
+	}
+}
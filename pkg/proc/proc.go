@@ -0,0 +1,115 @@
+// Package proc runs external tools such as ffmpeg and whisper-cli so that
+// cancelling their context stops them and everything they started: the tool
+// gets a process group of its own, which is sent SIGTERM and, if it has not
+// exited after a grace period, SIGKILL.
+package proc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GracePeriod is how long a cancelled process may take to exit before it is killed
+var GracePeriod = 5 * time.Second
+
+// Command returns an exec.Cmd for name that is stopped, with its children,
+// when ctx is done
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setGroup(cmd, GracePeriod)
+	// Backstop for children that keep the output pipes open after the kill
+	cmd.WaitDelay = GracePeriod + time.Second
+	return cmd
+}
+
+// tailSize is how much of a tool's stderr is kept for error reports
+const tailSize = 4096
+
+// transientMessages are stderr fragments of failures that may not happen again
+var transientMessages = []string{
+	"resource temporarily unavailable",
+	"cannot allocate memory",
+	"out of memory",
+	"connection reset",
+	"connection refused",
+	"connection timed out",
+	"temporary failure in name resolution",
+	"input/output error",
+	"device or resource busy",
+	"server returned 5",
+}
+
+// ExitError reports a tool that exited unsuccessfully, with the end of what
+// it wrote to stderr
+type ExitError struct {
+	Name   string
+	Err    error
+	Stderr string
+}
+
+func (e *ExitError) Error() string {
+	if line := lastLine(e.Stderr); line != "" {
+		return fmt.Sprintf("%s: %v: %s", e.Name, e.Err, line)
+	}
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Transient reports whether the tool was killed by a signal, for instance by
+// the out of memory killer, or failed with a message of a passing condition
+func (e *ExitError) Transient() bool {
+	var exit *exec.ExitError
+	if errors.As(e.Err, &exit) && !exit.Exited() {
+		return true
+	}
+	stderr := strings.ToLower(e.Stderr)
+	for _, msg := range transientMessages {
+		if strings.Contains(stderr, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run runs cmd, passing its stderr through to cmd.Stderr while keeping the
+// end of it for the *ExitError returned on failure
+func Run(cmd *exec.Cmd) error {
+	tail := &tailBuffer{}
+	if cmd.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, tail)
+	} else {
+		cmd.Stderr = tail
+	}
+	if err := cmd.Run(); err != nil {
+		return &ExitError{Name: filepath.Base(cmd.Path), Err: err, Stderr: string(tail.buf)}
+	}
+	return nil
+}
+
+// tailBuffer keeps the last tailSize bytes written to it
+type tailBuffer struct {
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > tailSize {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-tailSize:]...)
+	}
+	return len(p), nil
+}
+
+// lastLine returns the last non-empty line of s
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
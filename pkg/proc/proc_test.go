@@ -0,0 +1,80 @@
+//go:build unix
+
+package proc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// run starts script with sh, cancels it once it is running and returns how
+// long Wait took after the cancellation
+func run(t *testing.T, script string) time.Duration {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cmd := Command(ctx, "sh", "-c", script)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Start(); err != nil {
+		t.Skipf("sh not available: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	cancel()
+	if err := cmd.Wait(); err == nil {
+		t.Error("Wait() succeeded after cancellation")
+	}
+	return time.Since(start)
+}
+
+func TestCommandStopsGroup(t *testing.T) {
+	defer func(d time.Duration) { GracePeriod = d }(GracePeriod)
+	GracePeriod = 3 * time.Second
+
+	// The background sleep holds the output pipe; Wait only returns early if
+	// it was signalled along with the shell
+	if d := run(t, "sleep 30 & wait"); d > time.Second {
+		t.Errorf("child outlived its cancelled parent by %s", d)
+	}
+}
+
+func TestCommandKillsAfterGrace(t *testing.T) {
+	defer func(d time.Duration) { GracePeriod = d }(GracePeriod)
+	GracePeriod = 100 * time.Millisecond
+
+	if d := run(t, `trap "" TERM; sleep 30`); d > 2*time.Second {
+		t.Errorf("process ignoring SIGTERM took %s to stop", d)
+	}
+}
+
+func TestRunExitError(t *testing.T) {
+	tests := []struct {
+		script    string
+		transient bool
+	}{
+		{script: "echo 'Invalid data found when processing input' >&2; exit 1", transient: false},
+		{script: "echo 'Connection reset by peer' >&2; exit 1", transient: true},
+		{script: "kill -KILL $$", transient: true},
+	}
+	for _, tt := range tests {
+		err := Run(Command(context.Background(), "sh", "-c", tt.script))
+		var exit *ExitError
+		if !errors.As(err, &exit) {
+			t.Fatalf("Run(%q) error = %v, want an *ExitError", tt.script, err)
+		}
+		if exit.Transient() != tt.transient {
+			t.Errorf("Run(%q) transient = %v, want %v", tt.script, exit.Transient(), tt.transient)
+		}
+	}
+
+	err := Run(Command(context.Background(), "sh", "-c", "echo progress >&2; echo 'No such file' >&2; exit 2"))
+	if err == nil || err.Error() != "sh: exit status 2: No such file" {
+		t.Errorf("Run() error = %v, want the last stderr line", err)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Status is the state of a job
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Done reports whether the status is final
+func (s Status) Done() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCancelled
+}
+
+// Operations a job can run
+const (
+	OperationTranscribe = "transcribe"
+	OperationTranslate  = "translate"
+	OperationSpeed      = "speed"
+)
+
+// Options describe what a job produces
+type Options struct {
+	Operation string   `json:"operation"`         // transcribe, translate or speed
+	Lang      string   `json:"lang,omitempty"`    // target language for translate, en only
+	Formats   []string `json:"formats,omitempty"` // subtitle outputs: srt, vtt, json; default srt
+	Burn      bool     `json:"burn,omitempty"`    // render the subtitles into a video output
+	Speed     float64  `json:"speed,omitempty"`   // playback speed of the video output, 0 or 1 keeps it
+}
+
+// Validate checks the options and fills in defaults
+func (o *Options) Validate() error {
+	switch o.Operation {
+	case OperationTranscribe:
+	case OperationTranslate:
+		if o.Lang == "" {
+			return fmt.Errorf("lang is required for translate")
+		}
+		// Jobs are translated by whisper, which only translates into English
+		if o.Lang != "en" {
+			return fmt.Errorf("translate only translates to en, not %s", o.Lang)
+		}
+	case OperationSpeed:
+		if o.Speed <= 0 || o.Speed == 1 {
+			return fmt.Errorf("speed must be greater than 0 and different from 1 for the speed operation")
+		}
+		if o.Burn || len(o.Formats) > 0 {
+			return fmt.Errorf("the speed operation produces no subtitles")
+		}
+		return nil
+	case "":
+		return fmt.Errorf("operation is required")
+	default:
+		return fmt.Errorf("unknown operation: %s", o.Operation)
+	}
+
+	if o.Speed < 0 {
+		return fmt.Errorf("speed must be greater than 0")
+	}
+	if len(o.Formats) == 0 {
+		o.Formats = []string{"srt"}
+	}
+	for _, f := range o.Formats {
+		switch f {
+		case "srt", "vtt", "json":
+		default:
+			return fmt.Errorf("unknown subtitle format: %s", f)
+		}
+	}
+	return nil
+}
+
+// Job is a unit of work submitted to the server
+type Job struct {
+	ID       string            `json:"id"`
+	Input    string            `json:"input"`
+	Options  Options           `json:"options"`
+	Status   Status            `json:"status"`
+	Stage    string            `json:"stage,omitempty"`
+	Progress float64           `json:"progress"`
+	Outputs  map[string]string `json:"outputs,omitempty"` // output name (srt, vtt, json, video) to file path
+	Error    string            `json:"error,omitempty"`
+
+	// Completed lists the stages that finished and Artifacts the files they
+	// produced, so an interrupted job resumes after its last completed stage
+	Completed []string          `json:"completed,omitempty"`
+	Artifacts map[string]string `json:"artifacts,omitempty"`
+
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// clone returns a deep copy of the job
+func (j *Job) clone() Job {
+	c := *j
+	c.Completed = append([]string(nil), j.Completed...)
+	c.Outputs = copyMap(j.Outputs)
+	c.Artifacts = copyMap(j.Artifacts)
+	return c
+}
+
+// copyMap returns a copy of m, nil if m is nil
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// hasCompleted reports whether a stage of the job finished
+func (j *Job) hasCompleted(stage string) bool {
+	for _, s := range j.Completed {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// newJobID returns a random job identifier
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
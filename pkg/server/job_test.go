@@ -0,0 +1,87 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "transcribe defaults to srt", opts: Options{Operation: OperationTranscribe}},
+		{name: "translate", opts: Options{Operation: OperationTranslate, Lang: "en", Formats: []string{"vtt", "json"}, Burn: true}},
+		{name: "translate to a language other than English", opts: Options{Operation: OperationTranslate, Lang: "es"}, wantErr: true},
+		{name: "translate without lang", opts: Options{Operation: OperationTranslate}, wantErr: true},
+		{name: "speed", opts: Options{Operation: OperationSpeed, Speed: 1.25}},
+		{name: "speed of one", opts: Options{Operation: OperationSpeed, Speed: 1}, wantErr: true},
+		{name: "speed with subtitles", opts: Options{Operation: OperationSpeed, Speed: 2, Burn: true}, wantErr: true},
+		{name: "negative speed", opts: Options{Operation: OperationTranscribe, Speed: -1}, wantErr: true},
+		{name: "unknown format", opts: Options{Operation: OperationTranscribe, Formats: []string{"ass"}}, wantErr: true},
+		{name: "missing operation", opts: Options{}, wantErr: true},
+		{name: "unknown operation", opts: Options{Operation: "dance"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && tt.opts.Operation != OperationSpeed && len(tt.opts.Formats) == 0 {
+				t.Error("Validate() did not set default formats")
+			}
+		})
+	}
+}
+
+func TestStages(t *testing.T) {
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{opts: Options{Operation: OperationTranscribe}, want: "extract,transcribe,convert"},
+		{opts: Options{Operation: OperationTranslate, Burn: true, Speed: 1.5}, want: "extract,transcribe,convert,burn,speed"},
+		{opts: Options{Operation: OperationSpeed, Speed: 2}, want: "speed"},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		for i, s := range stages(tt.opts) {
+			if i > 0 {
+				got += ","
+			}
+			got += s
+		}
+		if got != tt.want {
+			t.Errorf("stages(%+v) = %s, want %s", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestResumable(t *testing.T) {
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "audio.wav")
+	if err := os.WriteFile(audio, []byte("wav"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	job := Job{
+		Options:   Options{Operation: OperationTranscribe, Formats: []string{"srt"}},
+		Completed: []string{"extract", "transcribe"},
+		Artifacts: map[string]string{"audio": audio, "transcript": filepath.Join(dir, "missing.srt")},
+	}
+
+	if !resumable(job, "extract") {
+		t.Error("resumable(extract) = false with its artifact on disk")
+	}
+	if resumable(job, "transcribe") {
+		t.Error("resumable(transcribe) = true with its artifact missing")
+	}
+	if resumable(job, "convert") {
+		t.Error("resumable(convert) = true for a stage that never ran")
+	}
+}
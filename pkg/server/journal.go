@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Journal persists job snapshots as JSON lines appended to a file. Replaying
+// the journal yields the last recorded state of every job.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// OpenJournal replays the journal at path, compacts it to one record per job
+// and opens it for appending. A missing journal starts empty.
+func OpenJournal(path string) (*Journal, []Job, error) {
+	jobs, err := replayJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := writeSnapshot(path, jobs); err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open journal: %v", err)
+	}
+	return &Journal{path: path, f: f}, jobs, nil
+}
+
+// replayJournal reads the last snapshot of every job recorded at path, oldest first
+func replayJournal(path string) ([]Job, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %v", err)
+	}
+	defer f.Close()
+
+	latest := make(map[string]Job)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var job Job
+		if err := json.Unmarshal(scanner.Bytes(), &job); err != nil || job.ID == "" {
+			// A crash can leave a truncated last line; skip it
+			continue
+		}
+		latest[job.ID] = job
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal: %v", err)
+	}
+
+	jobs := make([]Job, 0, len(latest))
+	for _, job := range latest {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+	return jobs, nil
+}
+
+// writeSnapshot atomically replaces the journal with one record per job
+func writeSnapshot(path string, jobs []Job) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create journal directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".journal-*")
+	if err != nil {
+		return fmt.Errorf("failed to compact journal: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, job := range jobs {
+		if err := enc.Encode(job); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to compact journal: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact journal: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact journal: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to compact journal: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to compact journal: %v", err)
+	}
+	return nil
+}
+
+// Record appends a job snapshot and syncs it to disk
+func (j *Journal) Record(job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job %s: %v", job.ID, err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to record job %s: %v", job.ID, err)
+	}
+	return j.f.Sync()
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
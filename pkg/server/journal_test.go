@@ -0,0 +1,79 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	journal, jobs, err := OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+	if len(jobs) != 0 {
+		t.Fatalf("OpenJournal() on a new journal returned %d jobs", len(jobs))
+	}
+
+	created := time.Now()
+	records := []Job{
+		{ID: "a", Status: StatusQueued, Created: created},
+		{ID: "b", Status: StatusQueued, Created: created.Add(time.Second)},
+		{ID: "a", Status: StatusRunning, Created: created, Completed: []string{"extract"}, Artifacts: map[string]string{"audio": "a.wav"}},
+		{ID: "b", Status: StatusSucceeded, Created: created.Add(time.Second)},
+	}
+	for _, job := range records {
+		if err := journal.Record(job); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+	journal.Close()
+
+	// Simulate a crash in the middle of writing a record
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id": "a", "status": "succ`)
+	f.Close()
+
+	journal, jobs, err = OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+	defer journal.Close()
+
+	if len(jobs) != 2 || jobs[0].ID != "a" || jobs[1].ID != "b" {
+		t.Fatalf("OpenJournal() jobs = %+v", jobs)
+	}
+	if jobs[0].Status != StatusRunning || jobs[0].Artifacts["audio"] != "a.wav" || !jobs[0].hasCompleted("extract") {
+		t.Errorf("job a = %+v", jobs[0])
+	}
+	if jobs[1].Status != StatusSucceeded {
+		t.Errorf("job b status = %s", jobs[1].Status)
+	}
+
+	// The journal was compacted to one record per job
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := len(splitLines(data)); lines != 2 {
+		t.Errorf("compacted journal has %d lines, want 2", lines)
+	}
+}
+
+func splitLines(data []byte) []string {
+	var lines []string
+	start := 0
+	for i, b := range data {
+		if b == '\n' {
+			lines = append(lines, string(data[start:i]))
+			start = i + 1
+		}
+	}
+	return lines
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/metrics"
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/proc"
+	"github.com/gleicon/transcoder/pkg/translation"
+)
+
+// Upper bounds of the histogram buckets: stages take from under a second
+// (convert) to an hour (transcribing a film on a CPU), and models run from
+// many times faster than real time to several times slower
+var (
+	stageBuckets    = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+	realtimeBuckets = []float64{0.05, 0.1, 0.25, 0.5, 0.75, 1, 1.5, 2, 4, 8}
+)
+
+// serverMetrics are the metrics served on GET /metrics
+type serverMetrics struct {
+	registry     *metrics.Registry
+	submitted    *metrics.Counter   // by operation
+	finished     *metrics.Counter   // by operation and status
+	stages       *metrics.Histogram // seconds by stage
+	realtime     *metrics.Histogram // transcription time over media duration by model
+	cache        *metrics.Counter   // lookups by stage and result
+	toolFailures *metrics.Counter   // by tool and class
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry:  r,
+		submitted: r.Counter("transcoder_jobs_submitted_total", "Jobs accepted by the server.", "operation"),
+		finished:  r.Counter("transcoder_jobs_finished_total", "Jobs that finished, by final status: succeeded, failed or cancelled.", "operation", "status"),
+		stages: r.Histogram("transcoder_stage_duration_seconds", "Wall time of job stages.",
+			stageBuckets, "stage"),
+		realtime: r.Histogram("transcoder_realtime_factor", "Transcription time divided by the media duration, per whisper model; below 1 is faster than real time.",
+			realtimeBuckets, "model"),
+		cache:        r.Counter("transcoder_cache_lookups_total", "Cache lookups of extracted audio and transcripts, by result: hit or miss.", "stage", "result"),
+		toolFailures: r.Counter("transcoder_tool_failures_total", "Jobs failed by ffmpeg or whisper-cli, by class: timeout, transient or permanent.", "tool", "class"),
+	}
+	r.GaugeFunc("transcoder_jobs_queued", "Jobs waiting for a worker.", func() float64 {
+		return float64(s.count(StatusQueued))
+	})
+	r.GaugeFunc("transcoder_jobs_running", "Jobs being processed.", func() float64 {
+		return float64(s.count(StatusRunning))
+	})
+	return m
+}
+
+// observe records what the translator did for a job
+func (m *serverMetrics) observe(stats *translation.Stats) {
+	for _, stage := range stats.Cached {
+		m.cache.Inc(stage, "hit")
+	}
+	for _, stage := range stats.Missed {
+		m.cache.Inc(stage, "miss")
+	}
+	// A cached transcript says nothing about the speed of the model
+	d, ok := stats.Stages["transcribe"]
+	if ok && stats.Duration > 0 && stats.Model != "" && !slices.Contains(stats.Cached, "transcribe") {
+		m.realtime.Observe(d.Seconds()/stats.Duration.Seconds(), stats.Model)
+	}
+}
+
+// fail records the external tool, if any, a job failed in
+func (m *serverMetrics) fail(err error) {
+	if tool, class, ok := toolFailure(err); ok {
+		m.toolFailures.Inc(tool, class)
+	}
+}
+
+// toolFailure returns the tool an error came from and whether it was a
+// timeout, a transient failure or a permanent one
+func toolFailure(err error) (tool, class string, ok bool) {
+	var exit *proc.ExitError
+	if !errors.As(err, &exit) {
+		return "", "", false
+	}
+	var timeout *policy.TimeoutError
+	switch {
+	case errors.As(err, &timeout):
+		class = "timeout"
+	case exit.Transient():
+		class = "transient"
+	default:
+		class = "permanent"
+	}
+	return exit.Name, class, true
+}
+
+// stageTimer times the stages of a job from the progress hook announcing
+// them to the checkpoint completing them
+type stageTimer struct {
+	stage   string
+	started time.Time
+}
+
+func (t *stageTimer) start(stage string) {
+	t.stage, t.started = stage, time.Now()
+}
+
+// stop returns how long stage took, false if it was not the one started
+func (t *stageTimer) stop(stage string) (time.Duration, bool) {
+	if stage != t.stage || t.started.IsZero() {
+		return 0, false
+	}
+	return time.Since(t.started), true
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/proc"
+	"github.com/gleicon/transcoder/pkg/translation"
+)
+
+func TestToolFailure(t *testing.T) {
+	invalid := &proc.ExitError{Name: "ffmpeg", Err: errors.New("exit status 1"), Stderr: "Invalid data found when processing input"}
+	reset := &proc.ExitError{Name: "whisper-cli", Err: errors.New("exit status 1"), Stderr: "connection reset by peer"}
+	tests := []struct {
+		err   error
+		tool  string
+		class string
+		ok    bool
+	}{
+		{err: fmt.Errorf("failed to extract audio: %w", invalid), tool: "ffmpeg", class: "permanent", ok: true},
+		{err: reset, tool: "whisper-cli", class: "transient", ok: true},
+		{err: &policy.TimeoutError{Limit: time.Minute, Err: invalid}, tool: "ffmpeg", class: "timeout", ok: true},
+		{err: context.Canceled},
+		{err: errors.New("unknown stage: mux")},
+	}
+	for _, tt := range tests {
+		tool, class, ok := toolFailure(tt.err)
+		if tool != tt.tool || class != tt.class || ok != tt.ok {
+			t.Errorf("toolFailure(%v) = %q, %q, %v, want %q, %q, %v", tt.err, tool, class, ok, tt.tool, tt.class, tt.ok)
+		}
+	}
+}
+
+func TestObserveStats(t *testing.T) {
+	m := newServerMetrics(&Server{})
+	m.observe(&translation.Stats{
+		Model:    "base.en",
+		Duration: 100 * time.Second,
+		Stages:   map[string]time.Duration{"extract": time.Second, "transcribe": 25 * time.Second},
+		Cached:   []string{"extract"},
+		Missed:   []string{"transcribe"},
+	})
+	// Served from the cache: no real-time factor
+	m.observe(&translation.Stats{
+		Model:    "base.en",
+		Duration: 100 * time.Second,
+		Stages:   map[string]time.Duration{"transcribe": time.Millisecond},
+		Cached:   []string{"transcribe"},
+	})
+
+	if n := m.realtime.Count("base.en"); n != 1 {
+		t.Errorf("real-time factor observations = %d, want 1", n)
+	}
+	if v := m.cache.Value("extract", "hit"); v != 1 {
+		t.Errorf("extract cache hits = %v, want 1", v)
+	}
+	if v := m.cache.Value("transcribe", "miss"); v != 1 {
+		t.Errorf("transcribe cache misses = %v, want 1", v)
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/gleicon/transcoder/pkg/subtitle"
+	"github.com/gleicon/transcoder/pkg/translation"
+	"github.com/gleicon/transcoder/pkg/workspace"
+)
+
+// Hooks receive the progress of a running job
+type Hooks struct {
+	// Progress reports the stage being run and the overall progress from 0 to 1
+	Progress func(stage string, progress float64)
+	// Checkpoint reports a completed stage and the artifacts it produced
+	Checkpoint func(stage string, artifacts map[string]string)
+}
+
+// Processor runs a job, keeping intermediate files in work and writing its
+// outputs under dir, and returns the outputs keyed by name (srt, vtt, json,
+// video). Stages listed in job.Completed whose artifacts still exist are not
+// run again.
+type Processor interface {
+	Process(ctx context.Context, job Job, work *workspace.Workspace, dir string, hooks Hooks) (map[string]string, error)
+}
+
+// TranslatorProcessor runs jobs with a translation.Translator
+type TranslatorProcessor struct {
+	translator *translation.Translator
+}
+
+// NewTranslatorProcessor creates a processor backed by translator, which is
+// shared by every worker
+func NewTranslatorProcessor(translator *translation.Translator) *TranslatorProcessor {
+	return &TranslatorProcessor{translator: translator}
+}
+
+// stages lists the stages a job goes through
+func stages(opts Options) []string {
+	var s []string
+	if opts.Operation != OperationSpeed {
+		s = append(s, "extract", "transcribe", "convert")
+	}
+	if opts.Burn {
+		s = append(s, "burn")
+	}
+	if opts.Speed > 0 && opts.Speed != 1 {
+		s = append(s, "speed")
+	}
+	return s
+}
+
+// stageArtifacts returns the names of the artifacts a stage produces
+func stageArtifacts(stage string, opts Options) []string {
+	switch stage {
+	case "extract":
+		return []string{"audio"}
+	case "transcribe":
+		return []string{"transcript"}
+	case "convert":
+		return opts.Formats
+	case "burn":
+		return []string{"burned"}
+	case "speed":
+		return []string{"video"}
+	}
+	return nil
+}
+
+// resumable reports whether a completed stage left all its artifacts on disk
+func resumable(job Job, stage string) bool {
+	if !job.hasCompleted(stage) {
+		return false
+	}
+	for _, name := range stageArtifacts(stage, job.Options) {
+		path, ok := job.Artifacts[name]
+		if !ok {
+			return false
+		}
+		if _, err := os.Stat(path); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Process implements Processor
+func (p *TranslatorProcessor) Process(ctx context.Context, job Job, work *workspace.Workspace, dir string, hooks Hooks) (map[string]string, error) {
+	artifacts := make(map[string]string)
+	for name, path := range job.Artifacts {
+		artifacts[name] = path
+	}
+
+	all := stages(job.Options)
+	for i, stage := range all {
+		if resumable(job, stage) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hooks.Progress(stage, float64(i)/float64(len(all)))
+
+		produced, err := p.runStage(ctx, stage, job, work, dir, artifacts)
+		if err != nil {
+			return nil, err
+		}
+		for name, path := range produced {
+			artifacts[name] = path
+		}
+		hooks.Checkpoint(stage, produced)
+	}
+
+	outputs := make(map[string]string)
+	for _, format := range job.Options.Formats {
+		outputs[format] = artifacts[format]
+	}
+	video := ""
+	if job.Options.Burn {
+		video = artifacts["burned"]
+	}
+	if path, ok := artifacts["video"]; ok {
+		video = path
+	}
+	if video != "" {
+		outputs["video"] = video
+	}
+
+	hooks.Progress("", 1)
+	return outputs, nil
+}
+
+// runStage runs a single stage and returns the artifacts it produced.
+// Intermediate artifacts go to the workspace, outputs to dir.
+func (p *TranslatorProcessor) runStage(ctx context.Context, stage string, job Job, work *workspace.Workspace, dir string, artifacts map[string]string) (map[string]string, error) {
+	ext := filepath.Ext(job.Input)
+
+	switch stage {
+	case "extract":
+		audio := work.Path("audio.wav")
+		if err := p.translator.ExtractAudio(ctx, job.Input, audio); err != nil {
+			return nil, err
+		}
+		return map[string]string{"audio": audio}, nil
+
+	case "transcribe":
+		transcript := work.Path("transcript.srt")
+		var err error
+		if job.Options.Operation == OperationTranslate {
+			err = p.translator.Translate(ctx, artifacts["audio"], transcript, job.Options.Lang)
+		} else {
+			err = p.translator.Transcribe(ctx, artifacts["audio"], transcript)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"transcript": transcript}, nil
+
+	case "convert":
+		track, err := subtitle.ReadFile(artifacts["transcript"])
+		if err != nil {
+			return nil, err
+		}
+		produced := make(map[string]string)
+		for _, format := range job.Options.Formats {
+			path := filepath.Join(dir, "subtitles."+format)
+			if err := subtitle.WriteFile(path, track); err != nil {
+				return nil, err
+			}
+			produced[format] = path
+		}
+		return produced, nil
+
+	case "burn":
+		// The burned video is the output unless its speed is changed next
+		burned := filepath.Join(dir, "video"+ext)
+		if slices.Contains(stages(job.Options), "speed") {
+			burned = work.Path("burned" + ext)
+		}
+		if err := p.translator.FFmpegProcessor().BurnSubtitles(ctx, job.Input, artifacts["transcript"], burned); err != nil {
+			return nil, err
+		}
+		return map[string]string{"burned": burned}, nil
+
+	case "speed":
+		source := job.Input
+		if burned, ok := artifacts["burned"]; ok {
+			source = burned
+		}
+		video := filepath.Join(dir, "video"+ext)
+		if err := p.translator.FFmpegProcessor().ChangeSpeed(ctx, source, video, job.Options.Speed); err != nil {
+			return nil, err
+		}
+		return map[string]string{"video": video}, nil
+	}
+
+	return nil, fmt.Errorf("unknown stage: %s", stage)
+}
@@ -0,0 +1,641 @@
+// Package server exposes transcoding jobs over an HTTP API backed by a
+// bounded worker pool.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/logging"
+	"github.com/gleicon/transcoder/pkg/translation"
+	"github.com/gleicon/transcoder/pkg/workspace"
+)
+
+// Config holds the server configuration
+type Config struct {
+	DataDir       string           // uploads and job outputs are stored under this directory
+	Workers       int              // jobs processed concurrently
+	QueueSize     int              // jobs waiting for a worker before submissions are rejected
+	MaxUploadSize int64            // maximum upload size in bytes
+	InputRoots    []string         // directories whose files may be referenced by path; none disables references
+	Workspace     workspace.Config // intermediate files of jobs, Root defaults to <DataDir>/work
+	Logger        *slog.Logger     // receives job events, defaults to the default logger
+}
+
+// DefaultConfig returns a configuration storing data under dataDir
+func DefaultConfig(dataDir string) Config {
+	return Config{
+		DataDir:       dataDir,
+		Workers:       2,
+		QueueSize:     100,
+		MaxUploadSize: 2 << 30,
+	}
+}
+
+// Server runs submitted jobs and serves their status and outputs. Job state
+// is recorded in a journal under the data directory so unfinished jobs are
+// resumed when the server restarts.
+type Server struct {
+	config    Config
+	processor Processor
+	logger    *slog.Logger
+	journal   *Journal
+	metrics   *serverMetrics
+	queue     chan string
+	wg        sync.WaitGroup
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	cancels map[string]context.CancelFunc
+}
+
+// New creates a server that runs jobs with processor
+func New(config Config, processor Processor) (*Server, error) {
+	if processor == nil {
+		return nil, fmt.Errorf("processor is required")
+	}
+	if config.DataDir == "" {
+		return nil, fmt.Errorf("data directory is required")
+	}
+	if err := os.MkdirAll(filepath.Join(config.DataDir, "jobs"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	defaults := DefaultConfig(config.DataDir)
+	if config.Workers < 1 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize < 1 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.MaxUploadSize <= 0 {
+		config.MaxUploadSize = defaults.MaxUploadSize
+	}
+	if config.Workspace.Root == "" {
+		config.Workspace.Root = filepath.Join(config.DataDir, "work")
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	journal, recorded, err := OpenJournal(filepath.Join(config.DataDir, "journal.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Jobs that were queued or running when the server stopped are queued
+	// again ahead of new submissions
+	var recovered []string
+	jobs := make(map[string]*Job)
+	for i := range recorded {
+		job := &recorded[i]
+		if !job.Status.Done() {
+			job.Status = StatusQueued
+			job.Stage = ""
+			recovered = append(recovered, job.ID)
+		}
+		jobs[job.ID] = job
+	}
+
+	s := &Server{
+		config:    config,
+		processor: processor,
+		logger:    logger,
+		journal:   journal,
+		queue:     make(chan string, config.QueueSize+len(recovered)),
+		jobs:      jobs,
+		cancels:   make(map[string]context.CancelFunc),
+	}
+	s.metrics = newServerMetrics(s)
+	for _, id := range recovered {
+		s.queue <- id
+		s.record(jobs[id])
+		logger.Info("job recovered", "job", id, "completed", jobs[id].Completed)
+	}
+	return s, nil
+}
+
+// Close releases the journal. Call it after Wait.
+func (s *Server) Close() error {
+	return s.journal.Close()
+}
+
+// record writes a job snapshot to the journal; s.mu must be held
+func (s *Server) record(job *Job) {
+	if err := s.journal.Record(*job); err != nil {
+		s.logger.Error("failed to record job", "job", job.ID, "error", err)
+	}
+}
+
+// Start launches the workers. Running jobs are cancelled when ctx is done.
+func (s *Server) Start(ctx context.Context) {
+	for i := 0; i < s.config.Workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-s.queue:
+					s.run(ctx, id)
+				}
+			}
+		}()
+	}
+}
+
+// Wait blocks until the workers started by Start have stopped
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// jobDir returns the directory holding the files of a job
+func (s *Server) jobDir(id string) string {
+	return filepath.Join(s.config.DataDir, "jobs", id)
+}
+
+// Submit queues a job for input. The job is rejected if the queue is full.
+func (s *Server) Submit(id, input string, opts Options) (*Job, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:      id,
+		Input:   input,
+		Options: opts,
+		Status:  StatusQueued,
+		Created: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case s.queue <- id:
+	default:
+		return nil, errQueueFull
+	}
+	s.jobs[id] = job
+	s.record(job)
+	s.metrics.submitted.Inc(string(opts.Operation))
+	s.logger.Info("job queued", "job", id, "operation", opts.Operation, "input", filepath.Base(input))
+	copied := job.clone()
+	return &copied, nil
+}
+
+var (
+	errQueueFull   = errors.New("job queue is full")
+	errNotFound    = errors.New("job not found")
+	errJobFinished = errors.New("job already finished")
+)
+
+// Get returns a snapshot of a job
+func (s *Server) Get(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	copied := job.clone()
+	return &copied, nil
+}
+
+// List returns snapshots of every job, newest first
+func (s *Server) List() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.clone())
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.After(jobs[j].Created) })
+	return jobs
+}
+
+// Cancel stops a queued or running job
+func (s *Server) Cancel(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	switch job.Status {
+	case StatusQueued:
+		// The worker skips it when it is dequeued
+		now := time.Now()
+		job.Status = StatusCancelled
+		job.Finished = &now
+		s.record(job)
+		s.metrics.finished.Inc(string(job.Options.Operation), string(StatusCancelled))
+	case StatusRunning:
+		s.cancels[id]()
+	default:
+		return nil, errJobFinished
+	}
+	copied := job.clone()
+	return &copied, nil
+}
+
+// count returns the number of jobs with the given status
+func (s *Server) count(status Status) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, job := range s.jobs {
+		if job.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// update applies fn to a job under the lock
+func (s *Server) update(id string, fn func(job *Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// run processes a dequeued job
+func (s *Server) run(ctx context.Context, id string) {
+	jobCtx, cancel := context.WithCancel(logging.WithAttrs(ctx, "job", id))
+	defer cancel()
+
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	if !ok || job.Status != StatusQueued {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	job.Status = StatusRunning
+	job.Started = &now
+	s.cancels[id] = cancel
+	s.record(job)
+	snapshot := job.clone()
+	s.mu.Unlock()
+
+	logger := logging.From(jobCtx, s.logger)
+	logger.Info("job started")
+	dir := s.jobDir(id)
+
+	// A resumed job reopens the workspace holding its earlier artifacts
+	var outputs map[string]string
+	stats := &translation.Stats{}
+	work, err := workspace.Open(s.config.Workspace, id, jobNeed(snapshot))
+	if err == nil {
+		outputs, err = s.process(translation.WithStats(jobCtx, stats), snapshot, work, dir)
+	}
+	s.metrics.observe(stats)
+
+	s.mu.Lock()
+	delete(s.cancels, id)
+	finished := time.Now()
+	job.Finished = &finished
+	switch {
+	case err == nil:
+		job.Status = StatusSucceeded
+		job.Stage = ""
+		job.Progress = 1
+		job.Outputs = outputs
+		work.Close(nil)
+	case ctx.Err() != nil:
+		// The server is shutting down; keep the job queued, and its
+		// workspace, so it resumes on restart
+		job.Status = StatusQueued
+		job.Stage = ""
+		job.Finished = nil
+	case jobCtx.Err() != nil:
+		job.Status = StatusCancelled
+		job.Error = jobCtx.Err().Error()
+		if work != nil {
+			work.Remove()
+		}
+	default:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		s.metrics.fail(err)
+		if work != nil {
+			work.Close(err)
+			if work.Kept() {
+				logger.Warn("job failed, intermediate files kept", "dir", work.Dir())
+			}
+		}
+	}
+	s.record(job)
+	status := job.Status
+	if status.Done() {
+		s.metrics.finished.Inc(string(snapshot.Options.Operation), string(status))
+	}
+	s.mu.Unlock()
+
+	logger.Info("job finished", "status", status, "duration", finished.Sub(now).Round(time.Millisecond))
+}
+
+// jobNeed estimates the workspace space a job takes
+func jobNeed(job Job) int64 {
+	info, err := os.Stat(job.Input)
+	if err != nil {
+		return 0
+	}
+	copies := 0
+	if job.Options.Burn {
+		copies++
+	}
+	return workspace.MediaNeed(info.Size(), 0, copies)
+}
+
+// process runs the processor on a job, reporting its progress and checkpoints
+func (s *Server) process(ctx context.Context, job Job, work *workspace.Workspace, dir string) (map[string]string, error) {
+	id := job.ID
+	var timer stageTimer
+	return s.processor.Process(ctx, job, work, dir, Hooks{
+		Progress: func(stage string, progress float64) {
+			timer.start(stage)
+			s.update(id, func(job *Job) {
+				job.Stage = stage
+				job.Progress = progress
+			})
+		},
+		Checkpoint: func(stage string, artifacts map[string]string) {
+			if d, ok := timer.stop(stage); ok {
+				s.metrics.stages.Observe(d.Seconds(), stage)
+			}
+			s.update(id, func(job *Job) {
+				if !job.hasCompleted(stage) {
+					job.Completed = append(job.Completed, stage)
+				}
+				if job.Artifacts == nil {
+					job.Artifacts = make(map[string]string)
+				}
+				for name, path := range artifacts {
+					job.Artifacts[name] = path
+				}
+				s.record(job)
+			})
+		},
+	})
+}
+
+// Handler returns the HTTP API:
+//
+//	POST   /jobs                     submit a job (JSON reference or multipart upload)
+//	GET    /jobs                     list jobs
+//	GET    /jobs/{id}                job status and progress
+//	GET    /jobs/{id}/outputs/{name} download an output (srt, vtt, json, video)
+//	DELETE /jobs/{id}                cancel a job
+//	GET    /metrics                  metrics in the Prometheus text format
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.handleSubmit)
+	mux.HandleFunc("GET /jobs", s.handleList)
+	mux.HandleFunc("GET /jobs/{id}", s.handleGet)
+	mux.HandleFunc("DELETE /jobs/{id}", s.handleCancel)
+	mux.HandleFunc("GET /jobs/{id}/outputs/{name}", s.handleOutput)
+	mux.Handle("GET /metrics", s.metrics.registry.Handler())
+	return mux
+}
+
+// jobResponse is the API representation of a job; output paths are replaced by URLs
+type jobResponse struct {
+	Job
+	Outputs map[string]string `json:"outputs,omitempty"`
+}
+
+func newJobResponse(job *Job) jobResponse {
+	resp := jobResponse{Job: *job}
+	if len(job.Outputs) > 0 {
+		resp.Outputs = make(map[string]string)
+		for name := range job.Outputs {
+			resp.Outputs[name] = "/jobs/" + job.ID + "/outputs/" + name
+		}
+	}
+	return resp
+}
+
+// writeJSON writes v with the given status code
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// submitRequest is the JSON body of a job referencing a file on the server
+type submitRequest struct {
+	Input string `json:"input"`
+	Options
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	id, err := newJobID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var input string
+	var opts Options
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		input, opts, err = s.receiveUpload(w, r, id)
+	} else {
+		input, opts, err = s.decodeReference(r)
+	}
+	if err != nil {
+		os.RemoveAll(s.jobDir(id))
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := s.Submit(id, input, opts)
+	if err != nil {
+		os.RemoveAll(s.jobDir(id))
+		code := http.StatusBadRequest
+		if errors.Is(err, errQueueFull) {
+			code = http.StatusServiceUnavailable
+		}
+		writeError(w, code, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, newJobResponse(job))
+}
+
+// decodeReference reads a JSON submission referencing a file on the server
+func (s *Server) decodeReference(r *http.Request) (string, Options, error) {
+	var req submitRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		return "", Options{}, fmt.Errorf("invalid request body: %v", err)
+	}
+	if req.Input == "" {
+		return "", Options{}, fmt.Errorf("input is required")
+	}
+	input, err := s.allowedInput(req.Input)
+	if err != nil {
+		return "", Options{}, err
+	}
+	return input, req.Options, nil
+}
+
+// allowedInput checks that a referenced path exists inside one of the input roots
+func (s *Server) allowedInput(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	abs, err = filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", fmt.Errorf("input file not found: %s", path)
+	}
+	for _, root := range s.config.InputRoots {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		if rel, err := filepath.Rel(root, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return abs, nil
+		}
+	}
+	return "", fmt.Errorf("input %s is outside the allowed directories", path)
+}
+
+// receiveUpload stores the "file" part of a multipart submission in the job
+// directory. Options come from an "options" JSON field.
+func (s *Server) receiveUpload(w http.ResponseWriter, r *http.Request, id string) (string, Options, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxUploadSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", Options{}, fmt.Errorf("invalid upload: %v", err)
+	}
+
+	var input string
+	var opts Options
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", Options{}, fmt.Errorf("invalid upload: %v", err)
+		}
+
+		switch part.FormName() {
+		case "options":
+			if err := json.NewDecoder(io.LimitReader(part, 1<<20)).Decode(&opts); err != nil {
+				return "", Options{}, fmt.Errorf("invalid options: %v", err)
+			}
+		case "file":
+			name := filepath.Base(part.FileName())
+			if name == "." || name == string(filepath.Separator) {
+				return "", Options{}, fmt.Errorf("upload has no file name")
+			}
+			input = filepath.Join(s.jobDir(id), "input", name)
+			if err := saveUpload(part, input); err != nil {
+				return "", Options{}, err
+			}
+		}
+		part.Close()
+	}
+
+	if input == "" {
+		return "", Options{}, fmt.Errorf("file is required")
+	}
+	return input, opts, nil
+}
+
+// saveUpload copies an uploaded file to path
+func saveUpload(r io.Reader, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create upload directory: %v", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to store upload: %v", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to store upload: %v", err)
+	}
+	return f.Close()
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	jobs := s.List()
+	resp := make([]jobResponse, 0, len(jobs))
+	for i := range jobs {
+		resp = append(resp, newJobResponse(&jobs[i]))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	job, err := s.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newJobResponse(job))
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	job, err := s.Cancel(r.PathValue("id"))
+	switch {
+	case errors.Is(err, errNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeJSON(w, http.StatusAccepted, newJobResponse(job))
+	}
+}
+
+func (s *Server) handleOutput(w http.ResponseWriter, r *http.Request) {
+	job, err := s.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	path, ok := job.Outputs[r.PathValue("name")]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("output not found"))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.ID+"."+outputExt(r.PathValue("name"), path)))
+	http.ServeFile(w, r, path)
+}
+
+// outputExt returns the file extension used when downloading an output
+func outputExt(name, path string) string {
+	if name == "video" {
+		return strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+	return name
+}
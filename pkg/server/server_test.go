@@ -0,0 +1,371 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/workspace"
+)
+
+// fakeProcessor writes an SRT output, or blocks until cancelled when the
+// input name contains "slow"
+type fakeProcessor struct{}
+
+func (fakeProcessor) Process(ctx context.Context, job Job, work *workspace.Workspace, dir string, hooks Hooks) (map[string]string, error) {
+	hooks.Progress("transcribe", 0.5)
+	if strings.Contains(job.Input, "slow") {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	path := filepath.Join(dir, "subtitles.srt")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte("1\n00:00:00,000 --> 00:00:01,000\nhello\n"), 0644); err != nil {
+		return nil, err
+	}
+	return map[string]string{"srt": path}, nil
+}
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server, string) {
+	t.Helper()
+	inputs := t.TempDir()
+	config := DefaultConfig(t.TempDir())
+	config.InputRoots = []string{inputs}
+	config.QueueSize = 2
+	config.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	s, err := New(config, fakeProcessor{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		cancel()
+		s.Wait()
+		s.Close()
+	})
+	return s, ts, inputs
+}
+
+func decodeJob(t *testing.T, resp *http.Response) jobResponse {
+	t.Helper()
+	defer resp.Body.Close()
+	var job jobResponse
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		t.Fatalf("failed to decode job: %v", err)
+	}
+	return job
+}
+
+// waitStatus polls a job until it reaches a final status
+func waitStatus(t *testing.T, ts *httptest.Server, id string) jobResponse {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(ts.URL + "/jobs/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job := decodeJob(t, resp)
+		if job.Status.Done() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return jobResponse{}
+}
+
+func TestSubmitReference(t *testing.T) {
+	_, ts, inputs := newTestServer(t)
+	input := filepath.Join(inputs, "talk.mp3")
+	if err := os.WriteFile(input, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"input": "` + input + `", "operation": "transcribe"}`
+	resp, err := http.Post(ts.URL+"/jobs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST /jobs status = %d", resp.StatusCode)
+	}
+	job := decodeJob(t, resp)
+
+	job = waitStatus(t, ts, job.ID)
+	if job.Status != StatusSucceeded || job.Progress != 1 {
+		t.Fatalf("job = %+v", job)
+	}
+	url, ok := job.Outputs["srt"]
+	if !ok {
+		t.Fatalf("job outputs = %v", job.Outputs)
+	}
+
+	resp, err = http.Get(ts.URL + url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(data), "hello") {
+		t.Errorf("GET output = %d %q", resp.StatusCode, data)
+	}
+
+	resp, err = http.Get(ts.URL + "/jobs/" + job.ID + "/outputs/video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET missing output status = %d", resp.StatusCode)
+	}
+}
+
+func TestSubmitUpload(t *testing.T) {
+	_, ts, _ := newTestServer(t)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("options", `{"operation": "translate", "lang": "en", "formats": ["vtt"]}`)
+	fw, _ := mw.CreateFormFile("file", "upload.wav")
+	fw.Write([]byte("audio"))
+	mw.Close()
+
+	resp, err := http.Post(ts.URL+"/jobs", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST /jobs status = %d", resp.StatusCode)
+	}
+	job := decodeJob(t, resp)
+	if filepath.Base(job.Input) != "upload.wav" || job.Options.Lang != "en" {
+		t.Errorf("job = %+v", job)
+	}
+	if _, err := os.Stat(job.Input); err != nil {
+		t.Errorf("upload not stored: %v", err)
+	}
+	if job := waitStatus(t, ts, job.ID); job.Status != StatusSucceeded {
+		t.Errorf("job status = %s", job.Status)
+	}
+}
+
+func TestSubmitRejected(t *testing.T) {
+	_, ts, inputs := newTestServer(t)
+	outside := filepath.Join(t.TempDir(), "secret.mp3")
+	os.WriteFile(outside, []byte("audio"), 0644)
+	inside := filepath.Join(inputs, "talk.mp3")
+	os.WriteFile(inside, []byte("audio"), 0644)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "outside roots", body: `{"input": "` + outside + `", "operation": "transcribe"}`},
+		{name: "missing file", body: `{"input": "` + filepath.Join(inputs, "missing.mp3") + `", "operation": "transcribe"}`},
+		{name: "bad options", body: `{"input": "` + inside + `", "operation": "translate"}`},
+		{name: "translate to es", body: `{"input": "` + inside + `", "operation": "translate", "lang": "es"}`},
+		{name: "bad json", body: `{`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(ts.URL+"/jobs", "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("POST /jobs status = %d, want 400", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s, ts, inputs := newTestServer(t)
+	input := filepath.Join(inputs, "slow.mp3")
+	os.WriteFile(input, []byte("audio"), 0644)
+
+	job, err := s.Submit("slowjob", input, Options{Operation: OperationTranscribe})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	// Wait until a worker picked it up
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if j, _ := s.Get(job.ID); j.Status == StatusRunning && j.Stage == "transcribe" {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/jobs/"+job.ID, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("DELETE status = %d", resp.StatusCode)
+	}
+
+	if final := waitStatus(t, ts, job.ID); final.Status != StatusCancelled {
+		t.Errorf("job status = %s, want cancelled", final.Status)
+	}
+
+	// Cancelling a finished job conflicts
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("second DELETE status = %d, want 409", resp.StatusCode)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	config := DefaultConfig(t.TempDir())
+	config.QueueSize = 1
+	config.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	s, err := New(config, fakeProcessor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Workers are not started, so the queue fills up
+	if _, err := s.Submit("a", "a.mp3", Options{Operation: OperationTranscribe}); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if _, err := s.Submit("b", "b.mp3", Options{Operation: OperationTranscribe}); err != errQueueFull {
+		t.Errorf("Submit() error = %v, want %v", err, errQueueFull)
+	}
+	if _, err := s.Cancel("a"); err != nil {
+		t.Errorf("Cancel() queued job error = %v", err)
+	}
+	if j, _ := s.Get("a"); j.Status != StatusCancelled {
+		t.Errorf("queued job status = %s after cancel", j.Status)
+	}
+}
+
+// resumingProcessor completes the extract stage, then blocks until cancelled.
+// If extract was completed by an earlier run it reports the job instead.
+type resumingProcessor struct {
+	resumed chan Job
+}
+
+func (p *resumingProcessor) Process(ctx context.Context, job Job, work *workspace.Workspace, dir string, hooks Hooks) (map[string]string, error) {
+	if job.hasCompleted("extract") {
+		p.resumed <- job
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	audio := work.Path("audio.wav")
+	os.WriteFile(audio, []byte("wav"), 0644)
+	hooks.Checkpoint("extract", map[string]string{"audio": audio})
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRecovery(t *testing.T) {
+	config := DefaultConfig(t.TempDir())
+	config.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// First run: the job checkpoints a stage and the server shuts down
+	first, err := New(config, &resumingProcessor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first.Start(ctx)
+	if _, err := first.Submit("job1", "talk.mp3", Options{Operation: OperationTranscribe}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if j, _ := first.Get("job1"); j.hasCompleted("extract") {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	first.Wait()
+	first.Close()
+
+	// Second run: the job is recovered and resumes after the completed stage
+	p := &resumingProcessor{resumed: make(chan Job, 1)}
+	second, err := New(config, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if j, _ := second.Get("job1"); j.Status != StatusQueued {
+		t.Fatalf("recovered job status = %s, want queued", j.Status)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		second.Wait()
+	}()
+	second.Start(ctx)
+
+	select {
+	case job := <-p.resumed:
+		if job.Artifacts["audio"] == "" {
+			t.Errorf("resumed job lost its artifacts: %+v", job)
+		} else if _, err := os.Stat(job.Artifacts["audio"]); err != nil {
+			t.Errorf("workspace of the interrupted job was removed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not resumed")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s, ts, inputs := newTestServer(t)
+	input := filepath.Join(inputs, "talk.mp3")
+	os.WriteFile(input, []byte("audio"), 0644)
+
+	job, err := s.Submit("metricsjob", input, Options{Operation: OperationTranscribe})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitStatus(t, ts, job.ID)
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{
+		`transcoder_jobs_submitted_total{operation="transcribe"} 1`,
+		`transcoder_jobs_finished_total{operation="transcribe",status="succeeded"} 1`,
+		`transcoder_jobs_queued 0`,
+		`transcoder_jobs_running 0`,
+	} {
+		if !strings.Contains(string(data), want+"\n") {
+			t.Errorf("GET /metrics is missing %q:\n%s", want, data)
+		}
+	}
+}
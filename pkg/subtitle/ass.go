@@ -0,0 +1,429 @@
+package subtitle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Color is an RGB color with an opacity, 255 for opaque
+type Color struct {
+	R, G, B, A uint8
+}
+
+// ParseColor parses a color written as #rrggbb, or #rrggbbaa with an opacity
+func ParseColor(s string) (Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 8 || !strings.HasPrefix(s, "#") {
+		return Color{}, fmt.Errorf("invalid color %q, want #rrggbb or #rrggbbaa", s)
+	}
+	return Color{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+func (c Color) String() string {
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// ass returns the color as &HAABBGGRR, where ASS alpha is a transparency
+func (c Color) ass() string {
+	return fmt.Sprintf("&H%02X%02X%02X%02X", 255-c.A, c.B, c.G, c.R)
+}
+
+// ASSStyle is a named style of an Advanced SubStation Alpha track. Sizes and
+// margins are in the units of the track's PlayResX and PlayResY.
+type ASSStyle struct {
+	Name         string
+	Font         string
+	Size         float64
+	Color        Color // text
+	OutlineColor Color
+	BackColor    Color // shadow, or the box behind the text with Box
+	Bold         bool
+	Italic       bool
+	Outline      float64 // outline width
+	Shadow       float64 // shadow distance
+	Box          bool    // draw an opaque box behind the text instead of an outline
+	// Alignment is the position on screen as on a numeric keypad: 1 to 3
+	// along the bottom, 4 to 6 in the middle and 7 to 9 along the top
+	Alignment int
+	MarginL   int
+	MarginR   int
+	MarginV   int // distance from the bottom or top edge
+}
+
+// DefaultASSStyleName is the style of cues without a speaker style
+const DefaultASSStyleName = "Default"
+
+// SecondaryASSStyleName is the style of the secondary text of bilingual cues
+const SecondaryASSStyleName = "Secondary"
+
+// assPresets are the named styles ParseASSStyle starts from
+var assPresets = map[string]func() ASSStyle{
+	"default": DefaultASSStyle,
+	// Along the top, clear of lower thirds and burned-in credits
+	"top": func() ASSStyle {
+		s := DefaultASSStyle()
+		s.Alignment = 8
+		return s
+	},
+	// On a translucent black box, readable over any picture
+	"boxed": func() ASSStyle {
+		s := DefaultASSStyle()
+		s.Box, s.Outline, s.Shadow = true, 8, 0
+		s.OutlineColor, s.BackColor = Color{0, 0, 0, 160}, Color{0, 0, 0, 160}
+		return s
+	},
+	// Larger text with a heavier outline for small screens and low vision
+	"large": func() ASSStyle {
+		s := DefaultASSStyle()
+		s.Size, s.Outline, s.Bold = 68, 4, true
+		return s
+	},
+	// Yellow text, the classic color of film subtitles
+	"yellow": func() ASSStyle {
+		s := DefaultASSStyle()
+		s.Color = Color{255, 221, 0, 255}
+		return s
+	},
+}
+
+// ASSPresets returns the names of the style presets
+func ASSPresets() []string {
+	names := make([]string, 0, len(assPresets))
+	for name := range assPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultASSStyle returns the default preset: white text with a black
+// outline along the bottom
+func DefaultASSStyle() ASSStyle {
+	return ASSStyle{
+		Name: DefaultASSStyleName, Font: "Arial", Size: 52,
+		Color: Color{255, 255, 255, 255}, OutlineColor: Color{0, 0, 0, 255}, BackColor: Color{0, 0, 0, 128},
+		Outline: 3, Shadow: 1, Alignment: 2, MarginL: 80, MarginR: 80, MarginV: 60,
+	}
+}
+
+// ParseASSStyle applies a style specification to base: an optional preset
+// name followed by overrides, such as "top,font=Verdana,size=60" or
+// "color=#ffdd00,outline=2". The name of base is kept.
+func ParseASSStyle(spec string, base ASSStyle) (ASSStyle, error) {
+	s := base
+	for i, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			preset, found := assPresets[pair]
+			if i > 0 || !found {
+				return s, fmt.Errorf("unknown style preset %q, want one of %s", pair, strings.Join(ASSPresets(), ", "))
+			}
+			s = preset()
+			s.Name = base.Name
+			continue
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		var err error
+		switch name {
+		case "font":
+			s.Font = value
+		case "size":
+			s.Size, err = strconv.ParseFloat(value, 64)
+		case "color":
+			s.Color, err = ParseColor(value)
+		case "outline-color":
+			s.OutlineColor, err = ParseColor(value)
+		case "back-color":
+			s.BackColor, err = ParseColor(value)
+		case "bold":
+			s.Bold, err = strconv.ParseBool(value)
+		case "italic":
+			s.Italic, err = strconv.ParseBool(value)
+		case "outline":
+			s.Outline, err = strconv.ParseFloat(value, 64)
+		case "shadow":
+			s.Shadow, err = strconv.ParseFloat(value, 64)
+		case "box":
+			s.Box, err = strconv.ParseBool(value)
+		case "align":
+			s.Alignment, err = strconv.Atoi(value)
+		case "margin-l":
+			s.MarginL, err = strconv.Atoi(value)
+		case "margin-r":
+			s.MarginR, err = strconv.Atoi(value)
+		case "margin-v":
+			s.MarginV, err = strconv.Atoi(value)
+		default:
+			return s, fmt.Errorf("unknown style property %q, want one of font, size, color, outline-color, back-color, bold, italic, outline, shadow, box, align, margin-l, margin-r, margin-v", name)
+		}
+		if err != nil {
+			return s, fmt.Errorf("invalid value for style property %s: %q", name, value)
+		}
+	}
+	switch {
+	case s.Font == "" || strings.Contains(s.Font, ","):
+		return s, fmt.Errorf("invalid font %q", s.Font)
+	case s.Size <= 0:
+		return s, fmt.Errorf("font size must be positive")
+	case s.Outline < 0 || s.Shadow < 0:
+		return s, fmt.Errorf("outline and shadow must not be negative")
+	case s.Alignment < 1 || s.Alignment > 9:
+		return s, fmt.Errorf("align must be from 1 to 9")
+	case s.MarginL < 0 || s.MarginR < 0 || s.MarginV < 0:
+		return s, fmt.Errorf("margins must not be negative")
+	}
+	return s, nil
+}
+
+// ASSOptions style the cues written by WriteASSWith
+type ASSOptions struct {
+	// PlayResX and PlayResY are the size of the canvas that style sizes and
+	// margins refer to, default 1920x1080; players scale it to the video
+	PlayResX, PlayResY int
+	// Styles of the track. The one named DefaultASSStyleName, else the first,
+	// styles cues; cues whose Speaker names another style use that one. A
+	// SecondaryASSStyleName style is derived from the default one when
+	// bilingual cues need it. Empty uses DefaultASSStyle().
+	Styles []ASSStyle
+}
+
+// styles returns the styles to write for t, the default one first
+func (o ASSOptions) styles(t *Track) []ASSStyle {
+	styles := slices.Clone(o.Styles)
+	if len(styles) == 0 {
+		styles = []ASSStyle{DefaultASSStyle()}
+	}
+	i := slices.IndexFunc(styles, func(s ASSStyle) bool { return s.Name == DefaultASSStyleName })
+	switch {
+	case i > 0:
+		styles[0], styles[i] = styles[i], styles[0]
+	case i < 0:
+		styles[0].Name = DefaultASSStyleName
+	}
+	if t.bilingual() && !slices.ContainsFunc(styles, func(s ASSStyle) bool { return s.Name == SecondaryASSStyleName }) {
+		s := styles[0]
+		s.Name, s.Size, s.Italic, s.Color = SecondaryASSStyleName, math.Round(s.Size*0.85), true, Color{255, 215, 0, 255}
+		styles = append(styles, s)
+	}
+	return styles
+}
+
+// WriteASS writes a track in Advanced SubStation Alpha format with the
+// default style
+func WriteASS(w io.Writer, t *Track) error {
+	return WriteASSWith(w, t, ASSOptions{})
+}
+
+// WriteASSWith writes a track in Advanced SubStation Alpha format with the
+// styles of opts. The secondary text of bilingual cues switches to the
+// SecondaryASSStyleName style.
+func WriteASSWith(w io.Writer, t *Track, opts ASSOptions) error {
+	if opts.PlayResX <= 0 || opts.PlayResY <= 0 {
+		opts.PlayResX, opts.PlayResY = 1920, 1080
+	}
+	styles := opts.styles(t)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "[Script Info]\nScriptType: v4.00+\nPlayResX: %d\nPlayResY: %d\nWrapStyle: 0\nScaledBorderAndShadow: yes\n", opts.PlayResX, opts.PlayResY)
+
+	fmt.Fprintf(bw, "\n[V4+ Styles]\nFormat: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n")
+	names := make(map[string]bool)
+	for _, s := range styles {
+		names[s.Name] = true
+		border := 1
+		if s.Box {
+			border = 3
+		}
+		fmt.Fprintf(bw, "Style: %s,%s,%s,%s,%s,%s,%s,%d,%d,0,0,100,100,0,0,%d,%s,%s,%d,%d,%d,%d,1\n",
+			s.Name, s.Font, formatNumber(s.Size), s.Color.ass(), s.Color.ass(), s.OutlineColor.ass(), s.BackColor.ass(),
+			assBool(s.Bold), assBool(s.Italic), border, formatNumber(s.Outline), formatNumber(s.Shadow),
+			s.Alignment, s.MarginL, s.MarginR, s.MarginV)
+	}
+
+	fmt.Fprintf(bw, "\n[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+	for _, c := range t.Cues {
+		style := DefaultASSStyleName
+		if names[c.Speaker] {
+			style = c.Speaker
+		}
+		text := assText(c.Text)
+		if c.Secondary != "" {
+			text += `\N{\r` + SecondaryASSStyleName + `}` + assText(c.Secondary)
+		}
+		fmt.Fprintf(bw, "Dialogue: 0,%s,%s,%s,%s,0,0,0,,%s\n", formatASSTimestamp(c.Start), formatASSTimestamp(c.End), style, strings.ReplaceAll(c.Speaker, ",", " "), text)
+	}
+	return bw.Flush()
+}
+
+// assText turns cue text into ASS event text: line breaks become \N, the
+// HTML-like tags of SRT and WebVTT become override codes, and braces, which
+// open override blocks, are replaced
+func assText(text string) string {
+	return strings.NewReplacer(
+		"\n", `\N`,
+		"{", "(", "}", ")",
+		"<i>", `{\i1}`, "</i>", `{\i0}`,
+		"<b>", `{\b1}`, "</b>", `{\b0}`,
+		"<u>", `{\u1}`, "</u>", `{\u0}`,
+	).Replace(text)
+}
+
+// ParseASS reads an Advanced SubStation Alpha or SubStation Alpha track. The
+// Name field of events is read as the speaker, override codes are removed,
+// and text switching to the SecondaryASSStyleName style is read back into
+// Secondary. Styles are not kept.
+func ParseASS(r io.Reader) (*Track, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	t := &Track{}
+	section, line := "", 0
+	var format []string
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section, format = strings.ToLower(text), nil
+			continue
+		}
+		if section != "[events]" {
+			continue
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Format":
+			format = nil
+			for _, f := range strings.Split(value, ",") {
+				format = append(format, strings.ToLower(strings.TrimSpace(f)))
+			}
+		case "Dialogue":
+			if format == nil {
+				return nil, fmt.Errorf("line %d: dialogue before the events format", line)
+			}
+			c, err := parseDialogue(format, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			t.Cues = append(t.Cues, c)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read subtitles: %v", err)
+	}
+	if section == "" {
+		return nil, fmt.Errorf("missing [Script Info] section")
+	}
+
+	// Events need not be in order of time
+	sort.SliceStable(t.Cues, func(i, j int) bool { return t.Cues[i].Start < t.Cues[j].Start })
+	t.Renumber()
+	return t, nil
+}
+
+// parseDialogue parses the fields of a Dialogue line named by format; the
+// text is the last field and may contain commas
+func parseDialogue(format []string, value string) (Cue, error) {
+	fields := strings.SplitN(strings.TrimSpace(value), ",", len(format))
+	if len(fields) != len(format) {
+		return Cue{}, fmt.Errorf("dialogue has %d fields, want %d", len(fields), len(format))
+	}
+	var c Cue
+	for i, name := range format {
+		var err error
+		switch name {
+		case "start":
+			c.Start, err = parseASSTimestamp(fields[i])
+		case "end":
+			c.End, err = parseASSTimestamp(fields[i])
+		case "name":
+			c.Speaker = strings.TrimSpace(fields[i])
+		case "text":
+			c.Text, c.Secondary = parseASSText(fields[i])
+		}
+		if err != nil {
+			return Cue{}, err
+		}
+	}
+	return c, nil
+}
+
+// parseASSText turns event text into cue text, splitting off the text that
+// switches to the secondary style
+func parseASSText(text string) (primary, secondary string) {
+	if before, after, ok := strings.Cut(text, `{\r`+SecondaryASSStyleName+`}`); ok {
+		primary, _ = parseASSText(strings.TrimSuffix(before, `\N`))
+		secondary, _ = parseASSText(after)
+		return primary, secondary
+	}
+	var b strings.Builder
+	for len(text) > 0 {
+		open := strings.IndexByte(text, '{')
+		if open < 0 {
+			b.WriteString(text)
+			break
+		}
+		b.WriteString(text[:open])
+		end := strings.IndexByte(text[open:], '}')
+		if end < 0 {
+			break
+		}
+		text = text[open+end+1:]
+	}
+	lines := strings.Split(strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(b.String()), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
+	}
+	return strings.Join(lines, "\n"), ""
+}
+
+// parseASSTimestamp parses H:MM:SS.cc
+func parseASSTimestamp(s string) (time.Duration, error) {
+	var h, m int
+	var sec float64
+	if _, err := fmt.Sscanf(strings.TrimSpace(s), "%d:%d:%f", &h, &m, &sec); err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(math.Round(sec*1000))*time.Millisecond, nil
+}
+
+// formatASSTimestamp formats a duration as H:MM:SS.cc, rounded to the
+// centisecond ASS is timed in
+func formatASSTimestamp(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	cs := (d + 5*time.Millisecond) / (10 * time.Millisecond)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func assBool(b bool) int {
+	if b {
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package subtitle
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleASS = `[Script Info]
+Title: Sample
+ScriptType: v4.00+
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,20,&H00FFFFFF,&H000000FF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,2,2,10,10,10,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,0:00:05.50,0:00:07.00,Default,Ana,0,0,0,,{\an8}Second cue, with a comma
+Dialogue: 0,0:00:01.00,0:00:03.25,Default,,0,0,0,,First line\NSecond\hline
+Comment: 0,0:00:04.00,0:00:05.00,Default,,0,0,0,,Not shown
+`
+
+func TestParseASS(t *testing.T) {
+	track, err := Parse(strings.NewReader(sampleASS), FormatASS)
+	if err != nil {
+		t.Fatalf("ParseASS() error = %v", err)
+	}
+	want := []Cue{
+		{Index: 1, Start: time.Second, End: 3250 * time.Millisecond, Text: "First line\nSecond line"},
+		{Index: 2, Start: 5500 * time.Millisecond, End: 7 * time.Second, Text: "Second cue, with a comma", Speaker: "Ana"},
+	}
+	if !reflect.DeepEqual(track.Cues, want) {
+		t.Errorf("ParseASS() = %+v, want %+v", track.Cues, want)
+	}
+
+	if _, err := ParseASS(strings.NewReader("1\n00:00:01,000 --> 00:00:02,000\nSRT\n")); err == nil {
+		t.Error("ParseASS() read an SRT file")
+	}
+}
+
+func TestWriteASS(t *testing.T) {
+	speaker, err := ParseASSStyle("top,color=#80c0ff", DefaultASSStyle())
+	if err != nil {
+		t.Fatal(err)
+	}
+	speaker.Name = "Ana"
+	track := &Track{Cues: []Cue{
+		{Start: time.Second, End: 2504 * time.Millisecond, Text: "Hola <i>amigos</i>.", Secondary: "Hello friends.", Speaker: "Ana"},
+		{Start: time.Hour, End: time.Hour + time.Second, Text: "Two\nlines {sic}", Speaker: "Bo"},
+	}}
+
+	var buf bytes.Buffer
+	if err := WriteASSWith(&buf, track, ASSOptions{Styles: []ASSStyle{speaker, DefaultASSStyle()}}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"PlayResY: 1080\n",
+		"Style: Default,Arial,52,&H00FFFFFF,&H00FFFFFF,&H00000000,&H7F000000,0,0,0,0,100,100,0,0,1,3,1,2,80,80,60,1\n",
+		"Style: Ana,Arial,52,&H00FFC080,",
+		"Style: Secondary,Arial,44,&H0000D7FF,&H0000D7FF,&H00000000,&H7F000000,0,-1,",
+		`Dialogue: 0,0:00:01.00,0:00:02.50,Ana,Ana,0,0,0,,Hola {\i1}amigos{\i0}.\N{\rSecondary}Hello friends.` + "\n",
+		`Dialogue: 0,1:00:00.00,1:00:01.00,Default,Bo,0,0,0,,Two\Nlines (sic)` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("WriteASSWith() output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, "Style: Default") > strings.Index(out, "Style: Ana") {
+		t.Error("WriteASSWith() did not write the default style first")
+	}
+
+	back, err := ParseASS(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := back.Cues[0]; c.Text != "Hola amigos." || c.Secondary != "Hello friends." || c.Speaker != "Ana" {
+		t.Errorf("ParseASS() of a bilingual cue = %+v", c)
+	}
+}
+
+func TestParseASSStyle(t *testing.T) {
+	base := DefaultASSStyle()
+	base.Name = "Ana"
+	s, err := ParseASSStyle("boxed, font=Verdana,size=60,align=7,margin-v=20,bold=true", base)
+	if err != nil {
+		t.Fatalf("ParseASSStyle() error = %v", err)
+	}
+	if s.Name != "Ana" || !s.Box || s.Font != "Verdana" || s.Size != 60 || s.Alignment != 7 || s.MarginV != 20 || !s.Bold {
+		t.Errorf("ParseASSStyle() = %+v", s)
+	}
+
+	for _, spec := range []string{"glow", "size=60,top", "size=0", "align=10", "color=red", "font=A,B", "speed=2"} {
+		if _, err := ParseASSStyle(spec, DefaultASSStyle()); err == nil {
+			t.Errorf("ParseASSStyle(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	c, err := ParseColor("#ff8000c0")
+	if err != nil || c != (Color{255, 128, 0, 192}) {
+		t.Fatalf("ParseColor() = %v, %v", c, err)
+	}
+	if got := c.ass(); got != "&H3F0080FF" {
+		t.Errorf("ass() = %s, want &H3F0080FF", got)
+	}
+	if c, _ := ParseColor("#FFFFFF"); c.String() != "#ffffff" {
+		t.Errorf("String() = %s", c)
+	}
+	for _, s := range []string{"ffffff", "#fff", "#gggggg"} {
+		if _, err := ParseColor(s); err == nil {
+			t.Errorf("ParseColor(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package subtitle
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// SecondaryClass is the WebVTT class of the secondary text of bilingual cues
+const SecondaryClass = "secondary"
+
+// secondaryCSS styles the secondary text of bilingual WebVTT tracks apart
+// from the primary text
+const secondaryCSS = "::cue(." + SecondaryClass + ") {\n  color: #ffd700;\n  font-style: italic;\n}"
+
+// Bilingual combines two tracks of the same speech, such as a transcript and
+// its translation, into one whose cues show the text of primary with the text
+// of secondary below it as Secondary. Cues keep the timings of primary. Tracks
+// translated cue by cue are paired by position; otherwise each secondary cue
+// goes to the primary cue it overlaps the most, or the nearest one.
+func Bilingual(primary, secondary *Track) *Track {
+	out := &Track{Cues: slices.Clone(primary.Cues)}
+	if parallel(primary, secondary) {
+		for i := range out.Cues {
+			out.Cues[i].Secondary = secondary.Cues[i].Text
+		}
+		out.Renumber()
+		return out
+	}
+
+	texts := make([][]string, len(out.Cues))
+	for _, c := range secondary.Cues {
+		if i := closestCue(out.Cues, c); i >= 0 {
+			texts[i] = append(texts[i], c.Text)
+		}
+	}
+	for i, t := range texts {
+		switch len(t) {
+		case 0:
+		case 1:
+			out.Cues[i].Secondary = t[0]
+		default:
+			// Several cues are run into one line rather than stacked
+			out.Cues[i].Secondary = strings.Join(strings.Fields(strings.Join(t, " ")), " ")
+		}
+	}
+	out.Renumber()
+	return out
+}
+
+// parallel reports whether the cues of a and b pair up one to one in time
+func parallel(a, b *Track) bool {
+	if len(a.Cues) != len(b.Cues) {
+		return false
+	}
+	for i, c := range a.Cues {
+		if overlap(c, b.Cues[i]) <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// closestCue returns the index of the cue of cues that c overlaps the most,
+// or that is nearest to it when it overlaps none, and -1 for no cues
+func closestCue(cues []Cue, c Cue) int {
+	best, bestOverlap, bestDistance := -1, time.Duration(0), time.Duration(0)
+	for i, p := range cues {
+		if o := overlap(p, c); o > bestOverlap {
+			best, bestOverlap = i, o
+			continue
+		}
+		if bestOverlap > 0 {
+			continue
+		}
+		d := max(p.Start-c.End, c.Start-p.End)
+		if best < 0 || d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	return best
+}
+
+// overlap returns how long a and b are displayed together
+func overlap(a, b Cue) time.Duration {
+	return min(a.End, b.End) - max(a.Start, b.Start)
+}
+
+// Flatten moves the secondary text of bilingual cues into Text, below the
+// primary lines, for players that ignore the styling of secondary text
+func (t *Track) Flatten() {
+	for i, c := range t.Cues {
+		if c.Secondary != "" {
+			t.Cues[i].Text = c.Text + "\n" + c.Secondary
+			t.Cues[i].Secondary = ""
+		}
+	}
+}
+
+// bilingual reports whether any cue of t has secondary text
+func (t *Track) bilingual() bool {
+	return slices.ContainsFunc(t.Cues, func(c Cue) bool { return c.Secondary != "" })
+}
@@ -0,0 +1,105 @@
+package subtitle
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBilingualParallel(t *testing.T) {
+	s := time.Second
+	primary := &Track{Cues: []Cue{
+		{Start: 1 * s, End: 3 * s, Text: "Hola."},
+		{Start: 4 * s, End: 6 * s, Text: "¿Cómo estás?"},
+	}}
+	secondary := &Track{Cues: []Cue{
+		{Start: 1 * s, End: 3 * s, Text: "Hello."},
+		{Start: 4 * s, End: 6 * s, Text: "How are you?"},
+	}}
+
+	got := Bilingual(primary, secondary)
+	want := []Cue{
+		{Index: 1, Start: 1 * s, End: 3 * s, Text: "Hola.", Secondary: "Hello."},
+		{Index: 2, Start: 4 * s, End: 6 * s, Text: "¿Cómo estás?", Secondary: "How are you?"},
+	}
+	if !reflect.DeepEqual(got.Cues, want) {
+		t.Errorf("Bilingual() = %+v, want %+v", got.Cues, want)
+	}
+	if primary.Cues[0].Secondary != "" {
+		t.Error("Bilingual() modified the primary track")
+	}
+}
+
+func TestBilingualByTime(t *testing.T) {
+	ms := time.Millisecond
+	primary := &Track{Cues: []Cue{
+		{Start: 0, End: 2000 * ms, Text: "Bonjour à tous."},
+		{Start: 2500 * ms, End: 6000 * ms, Text: "Aujourd'hui nous parlons\nde la mer."},
+		{Start: 9000 * ms, End: 10000 * ms, Text: "Merci."},
+	}}
+	// Whisper's translation segments the speech on its own
+	secondary := &Track{Cues: []Cue{
+		{Start: 0, End: 2200 * ms, Text: "Hello everyone."},
+		{Start: 2200 * ms, End: 4000 * ms, Text: "Today we talk"},
+		{Start: 4000 * ms, End: 6100 * ms, Text: "about the sea."},
+		{Start: 8200 * ms, End: 8800 * ms, Text: "Thanks."},
+	}}
+
+	got := Bilingual(primary, secondary)
+	want := []string{"Hello everyone.", "Today we talk about the sea.", "Thanks."}
+	for i, c := range got.Cues {
+		if c.Secondary != want[i] {
+			t.Errorf("cue %d secondary = %q, want %q", i+1, c.Secondary, want[i])
+		}
+		if c.Start != primary.Cues[i].Start || c.End != primary.Cues[i].End || c.Text != primary.Cues[i].Text {
+			t.Errorf("cue %d = %+v, want the primary cue", i+1, c)
+		}
+	}
+}
+
+func TestBilingualFormats(t *testing.T) {
+	track := &Track{Cues: []Cue{
+		{Index: 1, Start: time.Second, End: 2 * time.Second, Text: "Hola.", Secondary: "Hello,\nthere."},
+		{Index: 2, Start: 3 * time.Second, End: 4 * time.Second, Text: "Adiós."},
+	}}
+
+	var srt bytes.Buffer
+	if err := WriteSRT(&srt, track); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(srt.String(), "Hola.\n<i>Hello,\nthere.</i>\n") {
+		t.Errorf("WriteSRT() = %q, want the secondary text in italics", srt.String())
+	}
+
+	for _, format := range []Format{FormatVTT, FormatJSON} {
+		var buf bytes.Buffer
+		if err := Write(&buf, track, format); err != nil {
+			t.Fatal(err)
+		}
+		if format == FormatVTT && !strings.Contains(buf.String(), "STYLE\n::cue(.secondary)") {
+			t.Errorf("WriteVTT() = %q, want a style for the secondary text", buf.String())
+		}
+		back, err := Parse(&buf, format)
+		if err != nil {
+			t.Fatalf("Parse(%s) error = %v", format, err)
+		}
+		if !reflect.DeepEqual(back, track) {
+			t.Errorf("%s round trip = %+v, want %+v", format, back.Cues, track.Cues)
+		}
+	}
+
+	var plain bytes.Buffer
+	if err := WriteVTT(&plain, &Track{Cues: track.Cues[1:]}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(plain.String(), "STYLE") {
+		t.Errorf("WriteVTT() = %q, want no style without secondary text", plain.String())
+	}
+
+	track.Flatten()
+	if c := track.Cues[0]; c.Text != "Hola.\nHello,\nthere." || c.Secondary != "" {
+		t.Errorf("Flatten() = %+v", c)
+	}
+}
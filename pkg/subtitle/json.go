@@ -0,0 +1,63 @@
+package subtitle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// jsonCue is the JSON representation of a cue, with times in milliseconds
+type jsonCue struct {
+	Index     int    `json:"index"`
+	Start     int64  `json:"start_ms"`
+	End       int64  `json:"end_ms"`
+	Text      string `json:"text"`
+	Secondary string `json:"secondary,omitempty"`
+	Speaker   string `json:"speaker,omitempty"`
+}
+
+// jsonTrack is the JSON representation of a track
+type jsonTrack struct {
+	Cues []jsonCue `json:"cues"`
+}
+
+// ParseJSON reads a track written by WriteJSON
+func ParseJSON(r io.Reader) (*Track, error) {
+	var jt jsonTrack
+	if err := json.NewDecoder(r).Decode(&jt); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON subtitles: %v", err)
+	}
+
+	t := &Track{}
+	for _, c := range jt.Cues {
+		t.Cues = append(t.Cues, Cue{
+			Start:     time.Duration(c.Start) * time.Millisecond,
+			End:       time.Duration(c.End) * time.Millisecond,
+			Text:      c.Text,
+			Secondary: c.Secondary,
+			Speaker:   c.Speaker,
+		})
+	}
+	t.Renumber()
+	return t, nil
+}
+
+// WriteJSON writes a track as JSON with cue times in milliseconds
+func WriteJSON(w io.Writer, t *Track) error {
+	jt := jsonTrack{Cues: make([]jsonCue, 0, len(t.Cues))}
+	for i, c := range t.Cues {
+		jt.Cues = append(jt.Cues, jsonCue{
+			Index:     i + 1,
+			Start:     c.Start.Milliseconds(),
+			End:       c.End.Milliseconds(),
+			Text:      c.Text,
+			Secondary: c.Secondary,
+			Speaker:   c.Speaker,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jt)
+}
@@ -0,0 +1,175 @@
+package subtitle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Severity tells whether an issue must be fixed before publishing
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Issue is a problem found by Validate. Rule is one of overlap, duration,
+// beyond-media, empty, encoding, repeated, reading-speed, lines or
+// line-length.
+type Issue struct {
+	Cue      int      `json:"cue"` // 1-based position in the track
+	Severity Severity `json:"severity"`
+	Rule     string   `json:"rule"`
+	Message  string   `json:"message"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("cue %d: %s: %s [%s]", i.Cue, i.Severity, i.Message, i.Rule)
+}
+
+// LintOptions configure Validate. Zero values use the defaults.
+type LintOptions struct {
+	Style         Style         // line and reading speed limits, default DefaultStyle()
+	MediaDuration time.Duration // length of the media; cues ending later are errors, zero skips the check
+	MaxRepeats    int           // consecutive cues with the same text reported as a loop, default 3
+}
+
+// Validate checks a track before it is published. Cues that overlap, have
+// no duration, run past the media, have no text or text that is not valid
+// UTF-8, and runs of the same text repeated by a looping transcription are
+// errors; cues that are too fast to read or break the line limits of the
+// style are warnings. Issues are ordered by cue.
+func Validate(t *Track, opts LintOptions) []Issue {
+	style := opts.Style.withDefaults()
+	if opts.MaxRepeats <= 0 {
+		opts.MaxRepeats = 3
+	}
+
+	var issues []Issue
+	add := func(cue int, severity Severity, rule, format string, args ...any) {
+		issues = append(issues, Issue{Cue: cue, Severity: severity, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	for i, c := range t.Cues {
+		n := i + 1
+		if c.End <= c.Start {
+			add(n, SeverityError, "duration", "ends at %s, not after it starts at %s", formatTimestamp(c.End, ","), formatTimestamp(c.Start, ","))
+		}
+		if i > 0 && c.Start < t.Cues[i-1].End {
+			add(n, SeverityError, "overlap", "starts %s before cue %d ends", t.Cues[i-1].End-c.Start, n-1)
+		}
+		if opts.MediaDuration > 0 && c.End > opts.MediaDuration {
+			add(n, SeverityError, "beyond-media", "ends at %s, after the media ends at %s", formatTimestamp(c.End, ","), formatTimestamp(opts.MediaDuration, ","))
+		}
+		if strings.TrimSpace(c.Text) == "" {
+			add(n, SeverityError, "empty", "has no text")
+			continue
+		}
+		if problem, severity := encodingProblem(c.Text); problem != "" {
+			add(n, severity, "encoding", "%s", problem)
+		}
+
+		lines := strings.Split(c.Text, "\n")
+		if len(lines) > style.MaxLines {
+			add(n, SeverityWarning, "lines", "has %d lines, more than %d", len(lines), style.MaxLines)
+		}
+		for _, l := range lines {
+			if length := utf8.RuneCountInString(l); length > style.MaxLineChars {
+				add(n, SeverityWarning, "line-length", "has a line of %d characters, more than %d", length, style.MaxLineChars)
+				break
+			}
+		}
+		if d := c.Duration(); d > 0 {
+			if cps := float64(textLength(lines)) / d.Seconds(); cps > style.MaxCPS {
+				add(n, SeverityWarning, "reading-speed", "needs %.1f characters per second, more than %g", cps, style.MaxCPS)
+			}
+		}
+	}
+
+	// Transcriptions stuck in a loop repeat a phrase over and over, often
+	// over silence or music
+	for i := 0; i < len(t.Cues); {
+		key := normalizeText(t.Cues[i].Text)
+		j := i + 1
+		for j < len(t.Cues) && key != "" && normalizeText(t.Cues[j].Text) == key {
+			j++
+		}
+		if j-i >= opts.MaxRepeats {
+			add(i+1, SeverityError, "repeated", "text %q repeated in cues %d to %d", firstLine(t.Cues[i].Text), i+1, j)
+		}
+		i = j
+	}
+
+	sort.SliceStable(issues, func(a, b int) bool { return issues[a].Cue < issues[b].Cue })
+	return issues
+}
+
+// HasErrors reports whether any of issues is an error
+func HasErrors(issues []Issue) bool {
+	for _, i := range issues {
+		if i.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// encodingProblem describes text that was not decoded correctly: invalid
+// UTF-8 and replacement characters are errors, UTF-8 read as Latin-1 or
+// Windows-1252 and control characters are warnings
+func encodingProblem(text string) (string, Severity) {
+	switch {
+	case !utf8.ValidString(text):
+		return "is not valid UTF-8", SeverityError
+	case strings.ContainsRune(text, utf8.RuneError):
+		return "contains the replacement character U+FFFD left by a failed decoding", SeverityError
+	case isMojibake(text):
+		return "looks like UTF-8 decoded as Latin-1 or Windows-1252", SeverityWarning
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return fmt.Sprintf("contains the control character %U", r), SeverityWarning
+		}
+	}
+	return "", ""
+}
+
+// isMojibake reports whether text contains the two characters a multi-byte
+// UTF-8 sequence turns into when its bytes are read as Latin-1 or
+// Windows-1252, such as "Ã©" for "é" or "â€™" for "’"
+func isMojibake(text string) bool {
+	runes := []rune(text)
+	for i := 0; i+1 < len(runes); i++ {
+		lead, next := runes[i], runes[i+1]
+		if lead >= 'Â' && lead <= 'ß' && next >= 0x80 && next <= 0xBF {
+			return true
+		}
+		if lead == 'â' && next == '€' {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeText reduces text to lower case letters and digits, so that
+// repeats differing in case, punctuation or line breaks compare equal
+func normalizeText(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			b.WriteRune(r)
+		case unicode.IsSpace(r) && b.Len() > 0:
+			b.WriteByte(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return line
+}
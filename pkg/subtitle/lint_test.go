@@ -0,0 +1,85 @@
+package subtitle
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	s := time.Second
+	track := &Track{Cues: []Cue{
+		{Start: 1 * s, End: 3 * s, Text: "Fine."},
+		{Start: 2500 * time.Millisecond, End: 4 * s, Text: "Overlaps the first."},
+		{Start: 5 * s, End: 5 * s, Text: "No time."},
+		{Start: 6 * s, End: 7 * s, Text: " "},
+		{Start: 8 * s, End: 9 * s, Text: "This sentence is far too long to be read in one second."},
+		{Start: 10 * s, End: 14 * s, Text: "one\ntwo\nthree"},
+		{Start: 15 * s, End: 17 * s, Text: "CafÃ© au lait"},
+		{Start: 18 * s, End: 20 * s, Text: "bad \xff byte"},
+		{Start: 21 * s, End: 23 * s, Text: "Thank you for watching!"},
+		{Start: 24 * s, End: 26 * s, Text: "Thank you for watching."},
+		{Start: 27 * s, End: 29 * s, Text: "thank you\nfor watching"},
+		{Start: 58 * s, End: 62 * s, Text: "Past the end."},
+	}}
+	track.Renumber()
+
+	issues := Validate(track, LintOptions{MediaDuration: time.Minute})
+	var got [][2]any
+	for _, i := range issues {
+		got = append(got, [2]any{i.Cue, i.Rule})
+		if want := map[string]Severity{"reading-speed": SeverityWarning, "lines": SeverityWarning, "overlap": SeverityError}[i.Rule]; want != "" && i.Severity != want {
+			t.Errorf("%v has severity %s, want %s", i, i.Severity, want)
+		}
+	}
+	want := [][2]any{
+		{2, "overlap"},
+		{3, "duration"},
+		{4, "empty"},
+		{5, "line-length"},
+		{5, "reading-speed"},
+		{6, "lines"},
+		{7, "encoding"},
+		{8, "encoding"},
+		{9, "repeated"},
+		{12, "beyond-media"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate() issues = %v, want %v", got, want)
+	}
+	if !HasErrors(issues) {
+		t.Error("HasErrors() = false")
+	}
+}
+
+func TestValidateClean(t *testing.T) {
+	track, err := ParseSRT(strings.NewReader(sampleSRT))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issues := Validate(track, LintOptions{}); len(issues) != 0 {
+		t.Errorf("Validate() = %v, want no issues", issues)
+	}
+	if HasErrors([]Issue{{Severity: SeverityWarning}}) {
+		t.Error("HasErrors() counted a warning")
+	}
+}
+
+func TestEncodingProblem(t *testing.T) {
+	tests := []struct {
+		text     string
+		severity Severity
+	}{
+		{"naïve café ’quoted’", ""},
+		{"itâ€™s", SeverityWarning},
+		{"bell\a", SeverityWarning},
+		{"lost � char", SeverityError},
+		{"\xc3\x28", SeverityError},
+	}
+	for _, tt := range tests {
+		if _, severity := encodingProblem(tt.text); severity != tt.severity {
+			t.Errorf("encodingProblem(%q) severity = %q, want %q", tt.text, severity, tt.severity)
+		}
+	}
+}
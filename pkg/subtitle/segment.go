@@ -0,0 +1,375 @@
+package subtitle
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Word is a transcribed word with its timing
+type Word struct {
+	Text  string
+	Start time.Duration
+	End   time.Duration
+}
+
+// Style holds the readability rules cues are segmented to. Zero values use
+// the defaults of DefaultStyle.
+type Style struct {
+	MaxLineChars int           // characters per line
+	MaxLines     int           // lines per cue
+	MaxCPS       float64       // reading speed in characters per second
+	MinDuration  time.Duration // shortest time a cue is displayed
+	MaxDuration  time.Duration // longest time a cue is displayed
+	MinGap       time.Duration // pause between consecutive cues
+}
+
+// DefaultStyle returns broadcast captioning guidelines: two lines of 42
+// characters read at 17 characters per second, displayed between 5/6 of a
+// second and 7 seconds, two frames apart
+func DefaultStyle() Style {
+	return Style{
+		MaxLineChars: 42,
+		MaxLines:     2,
+		MaxCPS:       17,
+		MinDuration:  833 * time.Millisecond,
+		MaxDuration:  7 * time.Second,
+		MinGap:       83 * time.Millisecond,
+	}
+}
+
+func (s Style) withDefaults() Style {
+	d := DefaultStyle()
+	if s.MaxLineChars <= 0 {
+		s.MaxLineChars = d.MaxLineChars
+	}
+	if s.MaxLines <= 0 {
+		s.MaxLines = d.MaxLines
+	}
+	if s.MaxCPS <= 0 {
+		s.MaxCPS = d.MaxCPS
+	}
+	if s.MinDuration <= 0 {
+		s.MinDuration = d.MinDuration
+	}
+	if s.MaxDuration <= 0 {
+		s.MaxDuration = d.MaxDuration
+	}
+	if s.MinGap < 0 {
+		s.MinGap = 0
+	}
+	return s
+}
+
+// String formats the style as ParseStyle reads it
+func (s Style) String() string {
+	return fmt.Sprintf("line-chars=%d,lines=%d,cps=%s,min-duration=%s,max-duration=%s,min-gap=%s",
+		s.MaxLineChars, s.MaxLines, strconv.FormatFloat(s.MaxCPS, 'g', -1, 64), s.MinDuration, s.MaxDuration, s.MinGap)
+}
+
+// ParseStyle parses a comma separated list of rule=value pairs, such as
+// "line-chars=37,cps=15", overriding DefaultStyle. The name "broadcast"
+// stands for the defaults themselves.
+func ParseStyle(spec string) (Style, error) {
+	s := DefaultStyle()
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" || pair == "broadcast" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return s, fmt.Errorf("invalid caption rule %q, want rule=value", pair)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		var err error
+		switch name {
+		case "line-chars":
+			s.MaxLineChars, err = strconv.Atoi(value)
+		case "lines":
+			s.MaxLines, err = strconv.Atoi(value)
+		case "cps":
+			s.MaxCPS, err = strconv.ParseFloat(value, 64)
+		case "min-duration":
+			s.MinDuration, err = time.ParseDuration(value)
+		case "max-duration":
+			s.MaxDuration, err = time.ParseDuration(value)
+		case "min-gap":
+			s.MinGap, err = time.ParseDuration(value)
+		default:
+			return s, fmt.Errorf("unknown caption rule %q, want one of line-chars, lines, cps, min-duration, max-duration, min-gap", name)
+		}
+		if err != nil {
+			return s, fmt.Errorf("invalid value for caption rule %s: %q", name, value)
+		}
+	}
+	switch {
+	case s.MaxLineChars <= 0, s.MaxLines <= 0, s.MaxCPS <= 0, s.MinDuration <= 0:
+		return s, fmt.Errorf("caption rules must be positive: %s", s)
+	case s.MinGap < 0:
+		return s, fmt.Errorf("min-gap must not be negative")
+	case s.MaxDuration <= s.MinDuration:
+		return s, fmt.Errorf("max-duration must be longer than min-duration")
+	}
+	return s, nil
+}
+
+// Penalties weighed by Segment against the cost of one more cue
+const (
+	phraseBreak   = 1 // cue ending on a comma, colon or semicolon
+	midBreak      = 3 // cue ending within a phrase
+	pauseBonus    = 1 // removed from a break at a pause
+	innerPause    = 3 // cue spanning a long pause
+	fastReading   = 5 // per multiple of MaxCPS exceeded
+	longPause     = time.Second
+	noticedPause  = 300 * time.Millisecond
+	sentenceMarks = ".?!…"
+	phraseMarks   = ",;:—–"
+)
+
+// Segment groups timed words into cues that follow style. Cues break where
+// sentences and phrases end or the speaker pauses, and otherwise between
+// words, so that every cue fits the line limits and is displayed no longer
+// than MaxDuration. Cues are lengthened into the following pause, up to
+// MinGap before the next cue, to reach MinDuration and the reading speed;
+// where speech is too fast for that, cues end at pauses they can run into.
+func Segment(words []Word, style Style) *Track {
+	style = style.withDefaults()
+	words = joinPunctuation(words)
+	n := len(words)
+	if n == 0 {
+		return &Track{}
+	}
+
+	// cost[j] is the lowest cost of segmenting words[:j], ending a cue at
+	// from[j]
+	cost := make([]float64, n+1)
+	from := make([]int, n+1)
+	for j := 1; j <= n; j++ {
+		cost[j] = math.Inf(1)
+		for i := j - 1; i >= 0; i-- {
+			single := j-i == 1
+			if !single && words[j-1].End-words[i].Start > style.MaxDuration {
+				break
+			}
+			lines, ok := wrap(words[i:j], style.MaxLineChars, style.MaxLines)
+			if !ok && !single {
+				break
+			}
+			if c := cost[i] + cueCost(words, i, j, lines, style); c < cost[j] {
+				cost[j], from[j] = c, i
+			}
+		}
+	}
+
+	var bounds [][2]int
+	for j := n; j > 0; j = from[j] {
+		bounds = append(bounds, [2]int{from[j], j})
+	}
+	track := &Track{}
+	for k := len(bounds) - 1; k >= 0; k-- {
+		i, j := bounds[k][0], bounds[k][1]
+		lines, _ := wrap(words[i:j], style.MaxLineChars, style.MaxLines)
+		track.Cues = append(track.Cues, Cue{Start: words[i].Start, End: words[j-1].End, Text: strings.Join(lines, "\n")})
+	}
+	retime(track.Cues, style)
+	track.Renumber()
+	return track
+}
+
+// cueCost scores a cue of words[i:j]: one for the cue itself plus penalties
+// for where it breaks and how fast it has to be read
+func cueCost(words []Word, i, j int, lines []string, style Style) float64 {
+	cost := 1.0
+	if j < len(words) {
+		last := words[j-1].Text
+		r, _ := utf8.DecodeLastRuneInString(strings.TrimRight(last, `"')]»`))
+		switch {
+		case strings.ContainsRune(sentenceMarks, r):
+		case strings.ContainsRune(phraseMarks, r):
+			cost += phraseBreak
+		default:
+			cost += midBreak
+		}
+		switch gap := words[j].Start - words[j-1].End; {
+		case gap >= longPause:
+			cost = 1
+		case gap >= noticedPause:
+			cost = max(cost-pauseBonus, 1)
+		}
+	}
+	for k := i + 1; k < j; k++ {
+		if words[k].Start-words[k-1].End >= longPause {
+			cost += innerPause
+		}
+	}
+
+	// The cue may stay up into the pause after it
+	start := words[i].Start
+	end := start + style.MaxDuration
+	if j < len(words) {
+		end = min(end, words[j].Start-style.MinGap)
+	}
+	end = max(end, words[j-1].End)
+	if cps := float64(textLength(lines)) / max(end-start, time.Millisecond).Seconds(); cps > style.MaxCPS {
+		cost += fastReading * (cps/style.MaxCPS - 1)
+	}
+	return cost
+}
+
+// retime lengthens cues to their minimum and reading durations, without
+// running into the next cue, and keeps MinGap between them
+func retime(cues []Cue, style Style) {
+	for k := range cues {
+		c := &cues[k]
+		reading := time.Duration(float64(textLength(strings.Split(c.Text, "\n"))) / style.MaxCPS * float64(time.Second))
+		end := max(c.End, c.Start+style.MinDuration, c.Start+reading)
+		end = min(end, max(c.Start+style.MaxDuration, c.End))
+		if k+1 < len(cues) {
+			end = min(end, cues[k+1].Start-style.MinGap)
+		}
+		c.End = max(end, c.End)
+		if k+1 < len(cues) && cues[k+1].Start < c.End+style.MinGap {
+			// Words closer than MinGap: end early rather than overlap
+			c.End = max(cues[k+1].Start-style.MinGap, c.Start+time.Millisecond)
+		}
+	}
+	// Cues still too short start earlier where the previous pause allows
+	for k := range cues {
+		c := &cues[k]
+		if c.Duration() >= style.MinDuration {
+			continue
+		}
+		earliest := time.Duration(0)
+		if k > 0 {
+			earliest = cues[k-1].End + style.MinGap
+		}
+		c.Start = max(min(c.Start, c.End-style.MinDuration), min(earliest, c.Start))
+	}
+}
+
+// wrap breaks the words of a cue into at most maxLines lines of at most
+// width characters, as evenly as possible. A single word longer than width
+// is returned on its own line and reported as not fitting.
+func wrap(words []Word, width, maxLines int) ([]string, bool) {
+	total := -1
+	longest := 0
+	for _, w := range words {
+		l := utf8.RuneCountInString(w.Text)
+		total += l + 1
+		longest = max(longest, l)
+	}
+	if total <= width {
+		return []string{joinWords(words)}, true
+	}
+	if longest > width {
+		return fill(words, longest), false
+	}
+	// The narrowest width that still fits in maxLines balances the lines
+	for w := max(longest, total/maxLines); w <= width; w++ {
+		if lines := fill(words, w); len(lines) <= maxLines {
+			return lines, true
+		}
+	}
+	return fill(words, width), false
+}
+
+// fill lays out words greedily in lines of at most width characters
+func fill(words []Word, width int) []string {
+	var (
+		lines []string
+		line  strings.Builder
+		n     int
+	)
+	for _, w := range words {
+		l := utf8.RuneCountInString(w.Text)
+		if n > 0 && n+1+l > width {
+			lines = append(lines, line.String())
+			line.Reset()
+			n = 0
+		}
+		if n > 0 {
+			line.WriteByte(' ')
+			n++
+		}
+		line.WriteString(w.Text)
+		n += l
+	}
+	return append(lines, line.String())
+}
+
+func joinWords(words []Word) string {
+	texts := make([]string, len(words))
+	for i, w := range words {
+		texts[i] = w.Text
+	}
+	return strings.Join(texts, " ")
+}
+
+// textLength counts the characters of lines, including the spaces between
+// words but not the line breaks
+func textLength(lines []string) int {
+	n := 0
+	for _, l := range lines {
+		n += utf8.RuneCountInString(l)
+	}
+	return n
+}
+
+// joinPunctuation drops empty words and attaches punctuation transcribed as
+// a word of its own to the word before it
+func joinPunctuation(words []Word) []Word {
+	var out []Word
+	for _, w := range words {
+		w.Text = strings.Join(strings.Fields(w.Text), " ")
+		if w.Text == "" {
+			continue
+		}
+		if len(out) > 0 && strings.IndexFunc(w.Text, func(r rune) bool { return !unicode.IsPunct(r) }) < 0 {
+			prev := &out[len(out)-1]
+			prev.Text += w.Text
+			prev.End = max(prev.End, w.End)
+			continue
+		}
+		if w.End < w.Start {
+			w.End = w.Start
+		}
+		out = append(out, w)
+	}
+	return out
+}
+
+// Words splits the cues of the track into words, spreading the time of each
+// cue over its words by their length. It estimates word timings for Segment
+// where no transcription with word timestamps is at hand.
+func (t *Track) Words() []Word {
+	var words []Word
+	for _, c := range t.Cues {
+		fields := strings.Fields(c.Text)
+		total := -1
+		for _, f := range fields {
+			total += utf8.RuneCountInString(f) + 1
+		}
+		at := 0
+		for _, f := range fields {
+			l := utf8.RuneCountInString(f)
+			words = append(words, Word{
+				Text:  f,
+				Start: c.Start + c.Duration()*time.Duration(at)/time.Duration(total),
+				End:   c.Start + c.Duration()*time.Duration(at+l)/time.Duration(total),
+			})
+			at += l + 1
+		}
+	}
+	return words
+}
+
+// Resegment splits and merges the cues of the track to follow style, with
+// word timings estimated from the cues
+func (t *Track) Resegment(style Style) {
+	*t = *Segment(t.Words(), style)
+}
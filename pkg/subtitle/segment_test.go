@@ -0,0 +1,147 @@
+package subtitle
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// timedWords times the words of text at a steady pace of 3 words per second
+// from start, with a pause after every sentence
+func timedWords(text string, start time.Duration) []Word {
+	var words []Word
+	at := start
+	for _, f := range strings.Fields(text) {
+		words = append(words, Word{Text: f, Start: at, End: at + 300*time.Millisecond})
+		at += 333 * time.Millisecond
+		if strings.HasSuffix(f, ".") {
+			at += 700 * time.Millisecond
+		}
+	}
+	return words
+}
+
+func TestSegment(t *testing.T) {
+	text := "We left the harbour before dawn, when the fog was still lying on the water and nobody could see the lighthouse. " +
+		"Nobody spoke. The engine was the only sound for an hour, until the captain finally told us where we were going and why."
+	style := DefaultStyle()
+	track := Segment(timedWords(text, time.Second), style)
+
+	var got []string
+	for i, c := range track.Cues {
+		lines := strings.Split(c.Text, "\n")
+		if len(lines) > style.MaxLines {
+			t.Errorf("cue %d has %d lines: %q", i+1, len(lines), c.Text)
+		}
+		for _, l := range lines {
+			if n := utf8.RuneCountInString(l); n > style.MaxLineChars {
+				t.Errorf("cue %d line of %d characters: %q", i+1, n, l)
+			}
+		}
+		if d := c.Duration(); d < style.MinDuration || d > style.MaxDuration {
+			t.Errorf("cue %d displayed for %v", i+1, d)
+		}
+		if i > 0 && c.Start-track.Cues[i-1].End < style.MinGap {
+			t.Errorf("cue %d starts %v after the previous one", i+1, c.Start-track.Cues[i-1].End)
+		}
+		if c.Index != i+1 {
+			t.Errorf("cue %d has index %d", i+1, c.Index)
+		}
+		got = append(got, strings.ReplaceAll(c.Text, "\n", " "))
+	}
+	if strings.Join(got, " ") != text {
+		t.Errorf("Segment() text = %q, want every word once in order", strings.Join(got, " "))
+	}
+	// Cues end with sentences and phrases rather than within them
+	for _, g := range got[:len(got)-1] {
+		if !strings.HasSuffix(g, ".") && !strings.HasSuffix(g, ",") {
+			t.Errorf("cue %q breaks within a phrase", g)
+		}
+	}
+}
+
+func TestSegmentPunctuationWords(t *testing.T) {
+	words := []Word{
+		{Text: " Hello", Start: 0, End: 200 * time.Millisecond},
+		{Text: ",", Start: 200 * time.Millisecond, End: 250 * time.Millisecond},
+		{Text: "world", Start: 300 * time.Millisecond, End: 500 * time.Millisecond},
+		{Text: "!", Start: 500 * time.Millisecond, End: 550 * time.Millisecond},
+		{Text: " ", Start: time.Second, End: time.Second},
+	}
+	track := Segment(words, Style{})
+	if len(track.Cues) != 1 || track.Cues[0].Text != "Hello, world!" {
+		t.Fatalf("Segment() = %+v, want one cue \"Hello, world!\"", track.Cues)
+	}
+	if c := track.Cues[0]; c.Start != 0 || c.End != DefaultStyle().MinDuration {
+		t.Errorf("Segment() cue = %v --> %v, want lengthened to the minimum duration", c.Start, c.End)
+	}
+}
+
+func TestSegmentReadingSpeed(t *testing.T) {
+	// 33 characters spoken in a second need almost two seconds to be read,
+	// which the pause after them allows
+	words := []Word{
+		{Text: "Absolutely", Start: 10 * time.Second, End: 10300 * time.Millisecond},
+		{Text: "extraordinary", Start: 10300 * time.Millisecond, End: 10700 * time.Millisecond},
+		{Text: "weather.", Start: 10700 * time.Millisecond, End: 11 * time.Second},
+		{Text: "Yes.", Start: 15 * time.Second, End: 15500 * time.Millisecond},
+	}
+	track := Segment(words, DefaultStyle())
+	if len(track.Cues) != 2 {
+		t.Fatalf("Segment() = %+v, want 2 cues", track.Cues)
+	}
+	if d := track.Cues[0].Duration(); d != 33*time.Second/17 {
+		t.Errorf("first cue displayed for %v, want 33/17 s", d)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	words := (&Track{Cues: []Cue{{Start: 0, End: time.Second, Text: "the quick brown fox jumps over the lazy dog again and again"}}}).Words()
+
+	lines, ok := wrap(words, 42, 2)
+	want := []string{"the quick brown fox jumps over", "the lazy dog again and again"}
+	if !ok || !reflect.DeepEqual(lines, want) {
+		t.Errorf("wrap() = %q, %v, want balanced lines %q", lines, ok, want)
+	}
+	if _, ok := wrap(words, 20, 2); ok {
+		t.Error("wrap() fit 59 characters in two lines of 20")
+	}
+	if lines, ok := wrap(words[:3], 42, 2); !ok || len(lines) != 1 {
+		t.Errorf("wrap() = %q, want a single line", lines)
+	}
+}
+
+func TestWords(t *testing.T) {
+	track := &Track{Cues: []Cue{{Start: time.Second, End: 2 * time.Second, Text: "abc\nde"}}}
+	got := track.Words()
+	want := []Word{
+		{Text: "abc", Start: time.Second, End: 1500 * time.Millisecond},
+		{Text: "de", Start: 1666666666, End: 2 * time.Second},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Words() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseStyle(t *testing.T) {
+	s, err := ParseStyle("broadcast,line-chars=37, cps=15,min-gap=0s")
+	if err != nil {
+		t.Fatalf("ParseStyle() error = %v", err)
+	}
+	want := DefaultStyle()
+	want.MaxLineChars, want.MaxCPS, want.MinGap = 37, 15, 0
+	if s != want {
+		t.Errorf("ParseStyle() = %+v, want %+v", s, want)
+	}
+	if back, err := ParseStyle(s.String()); err != nil || back != s {
+		t.Errorf("ParseStyle(%q) = %+v, %v", s.String(), back, err)
+	}
+
+	for _, spec := range []string{"cps", "speed=3", "lines=0", "cps=fast", "min-duration=8s"} {
+		if _, err := ParseStyle(spec); err == nil {
+			t.Errorf("ParseStyle(%q) succeeded", spec)
+		}
+	}
+}
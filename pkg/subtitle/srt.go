@@ -0,0 +1,110 @@
+package subtitle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseSRT reads a SubRip track
+func ParseSRT(r io.Reader) (*Track, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Track{}
+	for _, block := range blocks {
+		// The numeric counter is optional in files produced by some tools
+		if _, err := strconv.Atoi(strings.TrimSpace(block[0])); err == nil {
+			block = block[1:]
+		}
+		if len(block) == 0 {
+			continue
+		}
+
+		start, end, err := parseTiming(block[0])
+		if err != nil {
+			return nil, fmt.Errorf("cue %d: %w", len(t.Cues)+1, err)
+		}
+		t.Cues = append(t.Cues, Cue{
+			Start: start,
+			End:   end,
+			Text:  strings.Join(block[1:], "\n"),
+		})
+	}
+
+	t.Renumber()
+	return t, nil
+}
+
+// WriteSRT writes a track in SubRip format. The secondary text of bilingual
+// cues is set in italics.
+func WriteSRT(w io.Writer, t *Track) error {
+	bw := bufio.NewWriter(w)
+	for i, c := range t.Cues {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		text := c.Text
+		if c.Secondary != "" {
+			text += "\n<i>" + c.Secondary + "</i>"
+		}
+		fmt.Fprintf(bw, "%d\n%s --> %s\n%s\n", i+1, formatTimestamp(c.Start, ","), formatTimestamp(c.End, ","), text)
+	}
+	return bw.Flush()
+}
+
+// readBlocks splits input into blank-line separated blocks of trimmed lines
+func readBlocks(r io.Reader) ([][]string, error) {
+	var blocks [][]string
+	var current []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+			first = false
+		}
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				blocks = append(blocks, current)
+				current = nil
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read subtitles: %v", err)
+	}
+	if len(current) > 0 {
+		blocks = append(blocks, current)
+	}
+	return blocks, nil
+}
+
+// parseTiming parses a "start --> end" line, ignoring trailing cue settings
+func parseTiming(line string) (start, end time.Duration, err error) {
+	parts := strings.SplitN(line, "-->", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid timing line: %q", line)
+	}
+	if start, err = parseTimestamp(parts[0]); err != nil {
+		return 0, 0, err
+	}
+	endField := strings.Fields(parts[1])
+	if len(endField) == 0 {
+		return 0, 0, fmt.Errorf("invalid timing line: %q", line)
+	}
+	if end, err = parseTimestamp(endField[0]); err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
@@ -0,0 +1,292 @@
+// Package subtitle provides reading, writing and timing operations for subtitle tracks.
+package subtitle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/atomicfile"
+)
+
+// Format identifies a subtitle file format
+type Format string
+
+const (
+	FormatSRT  Format = "srt"
+	FormatVTT  Format = "vtt"
+	FormatJSON Format = "json"
+	FormatASS  Format = "ass"
+)
+
+// Cue is a single subtitle entry
+type Cue struct {
+	Index int // 1-based position in the track
+	Start time.Duration
+	End   time.Duration
+	Text  string // lines separated by "\n"
+	// Secondary is text in a second language shown below Text in bilingual
+	// tracks, styled apart from it where the format allows
+	Secondary string
+	Speaker   string // who speaks, styled by the ASS style of that name
+}
+
+// Duration returns how long the cue is displayed
+func (c Cue) Duration() time.Duration {
+	return c.End - c.Start
+}
+
+// Track is an ordered list of cues
+type Track struct {
+	Cues []Cue
+}
+
+// Renumber sets cue indexes to their 1-based position in the track
+func (t *Track) Renumber() {
+	for i := range t.Cues {
+		t.Cues[i].Index = i + 1
+	}
+}
+
+// FormatFromPath returns the subtitle format matching a file extension
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".srt":
+		return FormatSRT, nil
+	case ".vtt":
+		return FormatVTT, nil
+	case ".json":
+		return FormatJSON, nil
+	case ".ass", ".ssa":
+		// SubStation Alpha files are read as such and written as ASS, which
+		// players of .ssa files read too
+		return FormatASS, nil
+	default:
+		return "", fmt.Errorf("unsupported subtitle format: %s", path)
+	}
+}
+
+// Parse reads a track in the given format
+func Parse(r io.Reader, format Format) (*Track, error) {
+	switch format {
+	case FormatSRT:
+		return ParseSRT(r)
+	case FormatVTT:
+		return ParseVTT(r)
+	case FormatJSON:
+		return ParseJSON(r)
+	case FormatASS:
+		return ParseASS(r)
+	default:
+		return nil, fmt.Errorf("unsupported subtitle format: %s", format)
+	}
+}
+
+// Write writes a track in the given format
+func Write(w io.Writer, t *Track, format Format) error {
+	switch format {
+	case FormatSRT:
+		return WriteSRT(w, t)
+	case FormatVTT:
+		return WriteVTT(w, t)
+	case FormatJSON:
+		return WriteJSON(w, t)
+	case FormatASS:
+		return WriteASS(w, t)
+	default:
+		return fmt.Errorf("unsupported subtitle format: %s", format)
+	}
+}
+
+// ReadFile reads a subtitle file, detecting the format from its extension
+func ReadFile(path string) (*Track, error) {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open subtitle file: %w", err)
+	}
+	defer f.Close()
+
+	t, err := Parse(f, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// WriteFile writes a subtitle file, choosing the format from its extension
+func WriteFile(path string, t *Track) error {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path, func(w io.Writer) error { return Write(w, t, format) })
+}
+
+// WriteASSFile writes a track to an ASS file with the styles of opts
+func WriteASSFile(path string, t *Track, opts ASSOptions) error {
+	return writeFile(path, func(w io.Writer) error { return WriteASSWith(w, t, opts) })
+}
+
+// writeFile replaces the file at path with the output of write
+func writeFile(path string, write func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+
+	if err := EnsureOutputDir(path); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+	if err := atomicfile.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write subtitle file: %v", err)
+	}
+	return nil
+}
+
+// Shift moves every cue by offset. Cues pushed before zero are clamped to
+// start at zero and cues that end up entirely before zero are dropped.
+func (t *Track) Shift(offset time.Duration) {
+	t.Transform(1, offset)
+}
+
+// Stretch multiplies every timestamp by factor, for instance 25/23.976 for
+// subtitles timed against a video converted to another frame rate
+func (t *Track) Stretch(factor float64) {
+	t.Transform(factor, 0)
+}
+
+// Transform maps every timestamp ts to ts*scale + offset, rounded to the
+// millisecond. Cues are clamped or dropped at zero as by Shift.
+func (t *Track) Transform(scale float64, offset time.Duration) {
+	apply := func(d time.Duration) time.Duration {
+		return (time.Duration(float64(d)*scale) + offset).Round(time.Millisecond)
+	}
+	cues := t.Cues[:0]
+	for _, c := range t.Cues {
+		c.Start = apply(c.Start)
+		c.End = apply(c.End)
+		if c.End <= 0 {
+			continue
+		}
+		if c.Start < 0 {
+			c.Start = 0
+		}
+		cues = append(cues, c)
+	}
+	t.Cues = cues
+	t.Renumber()
+}
+
+// SyncPoint is the time a cue, given by its 1-based index, should start at
+type SyncPoint struct {
+	Cue int
+	At  time.Duration
+}
+
+// ParseSyncPoint parses "<cue>=<timestamp>", such as "5=00:01:02"
+func ParseSyncPoint(s string) (SyncPoint, error) {
+	cue, at, ok := strings.Cut(s, "=")
+	if !ok {
+		return SyncPoint{}, fmt.Errorf("invalid sync point %q, want <cue>=<timestamp>", s)
+	}
+	var p SyncPoint
+	if _, err := fmt.Sscanf(strings.TrimSpace(cue), "%d", &p.Cue); err != nil || p.Cue < 1 {
+		return SyncPoint{}, fmt.Errorf("invalid sync point %q: bad cue number %q", s, cue)
+	}
+	d, err := parseTimestamp(at)
+	if err != nil {
+		return SyncPoint{}, fmt.Errorf("invalid sync point %q: %v", s, err)
+	}
+	p.At = d
+	return p, nil
+}
+
+// Resync retimes the track so that the cues of a and b start at the given
+// times, stretching and shifting every cue linearly between and beyond them.
+// This fixes both an offset, such as a pre-roll intro, and a drift.
+func (t *Track) Resync(a, b SyncPoint) error {
+	for _, p := range []SyncPoint{a, b} {
+		if p.Cue < 1 || p.Cue > len(t.Cues) {
+			return fmt.Errorf("cue %d does not exist, the track has %d cues", p.Cue, len(t.Cues))
+		}
+	}
+	from := []time.Duration{t.Cues[a.Cue-1].Start, t.Cues[b.Cue-1].Start}
+	if from[0] == from[1] {
+		return fmt.Errorf("cues %d and %d start at the same time, pick cues further apart", a.Cue, b.Cue)
+	}
+
+	scale := float64(b.At-a.At) / float64(from[1]-from[0])
+	if scale <= 0 {
+		return fmt.Errorf("sync points reverse the order of cues %d and %d", a.Cue, b.Cue)
+	}
+	offset := a.At - time.Duration(float64(from[0])*scale)
+	t.Transform(scale, offset)
+	return nil
+}
+
+// parseTimestamp parses "hh:mm:ss,mmm", "hh:mm:ss.mmm" or "mm:ss.mmm"
+func parseTimestamp(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.Replace(s, ",", ".", 1))
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp: %q", s)
+	}
+
+	var h, m int
+	var sec float64
+	var err error
+	if len(parts) == 3 {
+		if _, err = fmt.Sscanf(parts[0], "%d", &h); err != nil {
+			return 0, fmt.Errorf("invalid timestamp: %q", s)
+		}
+		parts = parts[1:]
+	}
+	if _, err = fmt.Sscanf(parts[0], "%d", &m); err != nil {
+		return 0, fmt.Errorf("invalid timestamp: %q", s)
+	}
+	if _, err = fmt.Sscanf(parts[1], "%f", &sec); err != nil {
+		return 0, fmt.Errorf("invalid timestamp: %q", s)
+	}
+
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec*float64(time.Second))
+	return d.Round(time.Millisecond), nil
+}
+
+// formatTimestamp formats d as "hh:mm:ss<sep>mmm"
+func formatTimestamp(d time.Duration, sep string) string {
+	if d < 0 {
+		d = 0
+	}
+	d = d.Round(time.Millisecond)
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	s := d / time.Second
+	d -= s * time.Second
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", h, m, s, sep, d/time.Millisecond)
+}
+
+// ParseTimestamp parses a subtitle timestamp such as "00:01:02,500" or "01:02.5"
+func ParseTimestamp(s string) (time.Duration, error) {
+	return parseTimestamp(s)
+}
+
+// EnsureOutputDir ensures the output directory exists
+func EnsureOutputDir(output string) error {
+	dir := filepath.Dir(output)
+	if dir != "." {
+		return os.MkdirAll(dir, 0755)
+	}
+	return nil
+}
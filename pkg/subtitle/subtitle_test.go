@@ -0,0 +1,235 @@
+package subtitle
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleSRT = `1
+00:00:01,000 --> 00:00:04,500
+Hello there.
+
+2
+00:00:05,000 --> 00:00:07,250
+Two lines
+of text.
+`
+
+const sampleVTT = `WEBVTT
+
+NOTE produced by a test
+
+intro
+00:00:01.000 --> 00:00:04.500 align:start
+Hello there.
+
+00:05.000 --> 00:07.250
+Two lines
+of text.
+`
+
+const sampleJSON = `{"cues": [
+	{"index": 1, "start_ms": 1000, "end_ms": 4500, "text": "Hello there."},
+	{"index": 2, "start_ms": 5000, "end_ms": 7250, "text": "Two lines\nof text."}
+]}`
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{input: "00:00:01,000", want: time.Second},
+		{input: "01:02:03.456", want: time.Hour + 2*time.Minute + 3*time.Second + 456*time.Millisecond},
+		{input: "02:03.5", want: 2*time.Minute + 3500*time.Millisecond},
+		{input: "garbage", wantErr: true},
+		{input: "1:2:3:4", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseTimestamp(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimestamp() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTimestamp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAndWrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		format Format
+	}{
+		{name: "srt", input: sampleSRT, format: FormatSRT},
+		{name: "vtt", input: sampleVTT, format: FormatVTT},
+		{name: "json", input: sampleJSON, format: FormatJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track, err := Parse(strings.NewReader(tt.input), tt.format)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if len(track.Cues) != 2 {
+				t.Fatalf("Parse() returned %d cues, want 2", len(track.Cues))
+			}
+			second := track.Cues[1]
+			if second.Index != 2 || second.Start != 5*time.Second || second.End != 7250*time.Millisecond || second.Text != "Two lines\nof text." {
+				t.Errorf("Parse() second cue = %+v", second)
+			}
+
+			var buf bytes.Buffer
+			if err := Write(&buf, track, tt.format); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			again, err := Parse(&buf, tt.format)
+			if err != nil {
+				t.Fatalf("Parse() of written track error = %v", err)
+			}
+			if len(again.Cues) != len(track.Cues) || again.Cues[0] != track.Cues[0] {
+				t.Errorf("round trip mismatch: %+v != %+v", again.Cues, track.Cues)
+			}
+		})
+	}
+
+	if _, err := ParseVTT(strings.NewReader(sampleSRT)); err == nil {
+		t.Error("ParseVTT() expected error for missing header")
+	}
+}
+
+func TestReadWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.srt")
+	if err := os.WriteFile(input, []byte(sampleSRT), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	track, err := ReadFile(input)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	output := filepath.Join(dir, "out", "output.vtt")
+	if err := WriteFile(output, track); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "WEBVTT\n\n00:00:01.000 --> 00:00:04.500\nHello there.\n") {
+		t.Errorf("WriteFile() wrote %q", data)
+	}
+
+	if err := WriteFile(filepath.Join(dir, "output.txt"), track); err == nil {
+		t.Error("WriteFile() expected error for unknown extension")
+	}
+}
+
+func TestShift(t *testing.T) {
+	track := &Track{Cues: []Cue{
+		{Start: 0, End: time.Second, Text: "dropped"},
+		{Start: 1500 * time.Millisecond, End: 3 * time.Second, Text: "clamped"},
+		{Start: 5 * time.Second, End: 6 * time.Second, Text: "moved"},
+	}}
+
+	track.Shift(-2 * time.Second)
+
+	if len(track.Cues) != 2 {
+		t.Fatalf("Shift() left %d cues, want 2", len(track.Cues))
+	}
+	if c := track.Cues[0]; c.Index != 1 || c.Start != 0 || c.End != time.Second {
+		t.Errorf("Shift() clamped cue = %+v", c)
+	}
+	if c := track.Cues[1]; c.Index != 2 || c.Start != 3*time.Second || c.End != 4*time.Second {
+		t.Errorf("Shift() moved cue = %+v", c)
+	}
+}
+
+func TestStretch(t *testing.T) {
+	track := &Track{Cues: []Cue{{Start: 10 * time.Second, End: 12 * time.Second, Text: "a"}}}
+	track.Stretch(25 / 23.976)
+
+	if c := track.Cues[0]; c.Start != 10427*time.Millisecond || c.End != 12513*time.Millisecond {
+		t.Errorf("Stretch() cue = %v --> %v", c.Start, c.End)
+	}
+}
+
+func TestResync(t *testing.T) {
+	// Whisper timings 10s early with a 1% drift
+	track := &Track{}
+	for i := 0; i < 300; i++ {
+		start := time.Duration(i) * 10 * time.Second
+		track.Cues = append(track.Cues, Cue{Start: start, End: start + 2*time.Second, Text: "cue"})
+	}
+	track.Renumber()
+	at := func(s string) time.Duration {
+		d, err := ParseTimestamp(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	a, err := ParseSyncPoint("5=00:00:50.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := SyncPoint{Cue: 300, At: at("00:50:29.9")}
+	if err := track.Resync(a, b); err != nil {
+		t.Fatalf("Resync() error = %v", err)
+	}
+
+	tests := []struct {
+		cue   int
+		start time.Duration
+		end   time.Duration
+	}{
+		{cue: 1, start: 10 * time.Second, end: 12020 * time.Millisecond},
+		{cue: 5, start: 50400 * time.Millisecond, end: 52420 * time.Millisecond},
+		{cue: 300, start: at("00:50:29.9"), end: at("00:50:31.92")},
+	}
+	for _, tt := range tests {
+		c := track.Cues[tt.cue-1]
+		if c.Start != tt.start || c.End != tt.end {
+			t.Errorf("cue %d = %v --> %v, want %v --> %v", tt.cue, c.Start, c.End, tt.start, tt.end)
+		}
+	}
+}
+
+func TestResyncErrors(t *testing.T) {
+	track := &Track{Cues: []Cue{
+		{Index: 1, Start: 0, End: time.Second},
+		{Index: 2, Start: 0, End: time.Second},
+		{Index: 3, Start: 5 * time.Second, End: 6 * time.Second},
+	}}
+	tests := []struct {
+		name string
+		a, b SyncPoint
+	}{
+		{name: "missing cue", a: SyncPoint{Cue: 1}, b: SyncPoint{Cue: 4, At: time.Minute}},
+		{name: "same start", a: SyncPoint{Cue: 1}, b: SyncPoint{Cue: 2, At: time.Minute}},
+		{name: "reversed", a: SyncPoint{Cue: 1, At: time.Minute}, b: SyncPoint{Cue: 3}},
+	}
+	for _, tt := range tests {
+		if err := track.Resync(tt.a, tt.b); err == nil {
+			t.Errorf("%s: Resync() succeeded", tt.name)
+		}
+	}
+
+	for _, s := range []string{"5", "x=00:01:00", "0=00:01:00", "5=soon"} {
+		if _, err := ParseSyncPoint(s); err == nil {
+			t.Errorf("ParseSyncPoint(%q) succeeded", s)
+		}
+	}
+}
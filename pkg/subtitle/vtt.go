@@ -0,0 +1,83 @@
+package subtitle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseVTT reads a WebVTT track. NOTE, STYLE and REGION blocks are skipped,
+// and the secondary text of bilingual cues written by WriteVTT is read back
+// into Secondary.
+func ParseVTT(r io.Reader) (*Track, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 || !strings.HasPrefix(blocks[0][0], "WEBVTT") {
+		return nil, fmt.Errorf("missing WEBVTT header")
+	}
+
+	t := &Track{}
+	for _, block := range blocks[1:] {
+		switch {
+		case strings.HasPrefix(block[0], "NOTE"), block[0] == "STYLE", block[0] == "REGION":
+			continue
+		}
+		// An optional cue identifier precedes the timing line
+		if !strings.Contains(block[0], "-->") {
+			block = block[1:]
+		}
+		if len(block) == 0 {
+			continue
+		}
+
+		start, end, err := parseTiming(block[0])
+		if err != nil {
+			return nil, fmt.Errorf("cue %d: %w", len(t.Cues)+1, err)
+		}
+		text, secondary := splitSecondary(block[1:])
+		t.Cues = append(t.Cues, Cue{
+			Start:     start,
+			End:       end,
+			Text:      text,
+			Secondary: secondary,
+		})
+	}
+
+	t.Renumber()
+	return t, nil
+}
+
+// WriteVTT writes a track in WebVTT format. The secondary text of bilingual
+// cues is marked with the SecondaryClass class, styled by a STYLE block.
+func WriteVTT(w io.Writer, t *Track) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "WEBVTT")
+	if t.bilingual() {
+		fmt.Fprintf(bw, "\nSTYLE\n%s\n", secondaryCSS)
+	}
+	for _, c := range t.Cues {
+		text := c.Text
+		if c.Secondary != "" {
+			text += "\n<c." + SecondaryClass + ">" + c.Secondary + "</c>"
+		}
+		fmt.Fprintf(bw, "\n%s --> %s\n%s\n", formatTimestamp(c.Start, "."), formatTimestamp(c.End, "."), text)
+	}
+	return bw.Flush()
+}
+
+// splitSecondary separates the trailing lines of a cue marked with the
+// SecondaryClass class from the lines before them
+func splitSecondary(lines []string) (text, secondary string) {
+	prefix, suffix := "<c."+SecondaryClass+">", "</c>"
+	for i, l := range lines {
+		if i > 0 && strings.HasPrefix(l, prefix) && strings.HasSuffix(lines[len(lines)-1], suffix) {
+			secondary = strings.Join(lines[i:], "\n")
+			secondary = strings.TrimSuffix(strings.TrimPrefix(secondary, prefix), suffix)
+			return strings.Join(lines[:i], "\n"), secondary
+		}
+	}
+	return strings.Join(lines, "\n"), ""
+}
@@ -0,0 +1,100 @@
+package translation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/align"
+	"github.com/gleicon/transcoder/pkg/ffmpeg"
+	"github.com/gleicon/transcoder/pkg/logging"
+	"github.com/gleicon/transcoder/pkg/subtitle"
+	"github.com/gleicon/transcoder/pkg/whisper"
+	"github.com/gleicon/transcoder/pkg/workspace"
+)
+
+// AlignOptions configure Align
+type AlignOptions struct {
+	Align  align.Options
+	Speech ffmpeg.SpeechOptions
+	// Words transcribes the media with word timestamps so that cues whose
+	// text matches the speech are pinned to it. Subtitles in another
+	// language than the audio are aligned from speech intervals alone.
+	Words bool
+}
+
+// Align retimes track to the speech in media, such as subtitles made for a
+// different cut of the video
+func (t *Translator) Align(ctx context.Context, media string, track *subtitle.Track, opts AlignOptions) (result *align.Result, err error) {
+	audio, done, err := t.prepareAudio(ctx, media, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract audio: %w", err)
+	}
+	defer func() { done(err) }()
+
+	speech, err := t.detectSpeech(ctx, audio, opts.Speech)
+	if err != nil {
+		return nil, err
+	}
+
+	var words []align.Word
+	if opts.Words {
+		if words, err = t.words(ctx, audio); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err = align.Align(track, speech, words, opts.Align)
+	if err != nil {
+		return nil, err
+	}
+	logging.From(ctx, t.logger).Info("aligned subtitles", "cues", len(result.Track.Cues), "anchored", result.Anchored, "speech", len(speech), "words", len(words))
+	return result, nil
+}
+
+// detectSpeech returns the intervals of speech in audio
+func (t *Translator) detectSpeech(ctx context.Context, audio string, opts ffmpeg.SpeechOptions) ([]align.Interval, error) {
+	ctx = logging.WithAttrs(ctx, "stage", "speech")
+	defer t.stage(ctx, "speech", time.Now())
+
+	found, err := t.ffmpegProcessor.DetectSpeech(ctx, audio, opts)
+	if err != nil {
+		return nil, err
+	}
+	speech := make([]align.Interval, len(found))
+	for i, s := range found {
+		speech[i] = align.Interval{Start: s.Start, End: s.End}
+	}
+	return speech, nil
+}
+
+// words transcribes audio into words with their timings
+func (t *Translator) words(ctx context.Context, audio string) (words []align.Word, err error) {
+	w, err := t.resolve(ctx, audio)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select whisper model: %w", err)
+	}
+
+	ws, err := workspace.Create(t.workspace, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { ws.Close(err) }()
+
+	ctx = logging.WithAttrs(ctx, "stage", "transcribe")
+	defer t.stage(ctx, "transcribe", time.Now())
+	statsFrom(ctx).audio(w.Config().Language, modelName(w.Config()), whisper.WAVDuration(audio))
+
+	path := ws.Path("words.srt")
+	if err := w.TranscribeWords(ctx, audio, path); err != nil {
+		return nil, err
+	}
+	track, err := subtitle.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range track.Cues {
+		words = append(words, align.Word{Text: c.Text, Start: c.Start, End: c.End})
+	}
+	return words, nil
+}
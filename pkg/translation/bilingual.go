@@ -0,0 +1,78 @@
+package translation
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gleicon/transcoder/pkg/logging"
+	"github.com/gleicon/transcoder/pkg/subtitle"
+	"github.com/gleicon/transcoder/pkg/workspace"
+)
+
+// TranslateBilingual transcribes input and translates it to targetLang, and
+// writes both as one track whose cues show the transcript with the
+// translation below it. The translation comes from the backend set by
+// SetBackend, cue by cue. Without one, only English is accepted, which
+// whisper translates from the same audio with the same model.
+func (t *Translator) TranslateBilingual(ctx context.Context, input, output, targetLang string) (err error) {
+	if targetLang == "" {
+		return fmt.Errorf("target language is required")
+	}
+	if t.backend == nil {
+		if err := requireBackend(targetLang); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(input); os.IsNotExist(err) {
+		return fmt.Errorf("input file not found: %s", input)
+	}
+
+	audioFile, done, err := t.prepareAudio(ctx, input, false)
+	if err != nil {
+		return fmt.Errorf("failed to extract audio: %w", err)
+	}
+	defer func() { done(err) }()
+
+	w, err := t.resolve(ctx, audioFile)
+	if err != nil {
+		return fmt.Errorf("failed to select whisper model: %w", err)
+	}
+
+	ws, err := workspace.Create(t.workspace, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { ws.Close(err) }()
+
+	source := ws.Path("source.srt")
+	if err := t.transcribe(ctx, w, audioFile, source, ""); err != nil {
+		return fmt.Errorf("failed to transcribe audio: %w", err)
+	}
+	primary, err := subtitle.ReadFile(source)
+	if err != nil {
+		return err
+	}
+
+	var secondary *subtitle.Track
+	if t.backend != nil {
+		if secondary, err = t.TranslateTrack(ctx, primary, w.Config().Language, targetLang); err != nil {
+			return fmt.Errorf("failed to translate to %s: %w", targetLang, err)
+		}
+	} else {
+		// requireBackend let English through only, which whisper translates
+		// from the audio of the source language, as TranslateAll does
+		translated := ws.Path("translated.srt")
+		if err := t.transcribe(ctx, w, audioFile, translated, "en"); err != nil {
+			return fmt.Errorf("failed to translate audio: %w", err)
+		}
+		if secondary, err = subtitle.ReadFile(translated); err != nil {
+			return err
+		}
+	}
+	track := subtitle.Bilingual(primary, secondary)
+	logging.From(ctx, t.logger).Info("merged bilingual subtitles", "cues", len(track.Cues), "translated", len(secondary.Cues), "output", filepath.Base(output))
+	return subtitle.WriteFile(output, track)
+}
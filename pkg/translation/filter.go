@@ -0,0 +1,55 @@
+package translation
+
+import (
+	"context"
+
+	"github.com/gleicon/transcoder/pkg/ffmpeg"
+	"github.com/gleicon/transcoder/pkg/hallucination"
+	"github.com/gleicon/transcoder/pkg/logging"
+	"github.com/gleicon/transcoder/pkg/subtitle"
+)
+
+// FilterOptions configure SetFilter
+type FilterOptions struct {
+	Filter hallucination.Options
+	Speech ffmpeg.SpeechOptions
+	// Silence detects speech in the audio with ffmpeg, so that cues over
+	// silence are removed. It costs a pass over the audio.
+	Silence bool
+}
+
+// SetFilter removes text whisper made up from transcripts: loops of repeated
+// text, boilerplate phrases, cues over silence and cues transcribed with a
+// low probability. Removed cues are logged and recorded in the Stats of the
+// call. Nil keeps every cue.
+func (t *Translator) SetFilter(opts *FilterOptions) {
+	t.filter = opts
+}
+
+// filterTrack removes the hallucinated cues of track, whose segments whisper
+// gave scores
+func (t *Translator) filterTrack(ctx context.Context, audio string, track *subtitle.Track, scores []hallucination.Score) (*subtitle.Track, error) {
+	opts := t.filter.Filter
+	if opts.Scores == nil {
+		opts.Scores = scores
+	}
+	if t.filter.Silence {
+		speech, err := t.detectSpeech(ctx, audio, t.filter.Speech)
+		if err != nil {
+			return nil, err
+		}
+		// Empty but not nil: audio without speech removes every cue
+		opts.Speech = make([]hallucination.Interval, len(speech))
+		for i, s := range speech {
+			opts.Speech[i] = hallucination.Interval{Start: s.Start, End: s.End}
+		}
+	}
+
+	result := hallucination.Filter(track, opts)
+	logger := logging.From(ctx, t.logger)
+	for _, r := range result.Removed {
+		logger.Info("removed hallucinated cue", "cue", r.Cue, "start", r.Start, "reason", r.Reason, "text", r.Text)
+	}
+	statsFrom(ctx).removed(result.Removed)
+	return result.Track, nil
+}
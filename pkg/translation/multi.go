@@ -0,0 +1,180 @@
+package translation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/logging"
+	"github.com/gleicon/transcoder/pkg/mt"
+	"github.com/gleicon/transcoder/pkg/subtitle"
+	"github.com/gleicon/transcoder/pkg/workspace"
+)
+
+// SetBackend makes TranslateAll and TranslateBilingual translate the
+// transcript cue by cue with a machine translation backend, which reaches
+// any language, instead of having whisper translate the audio, which it only
+// does into English. Requests are limited and retried by the policy of
+// SetPolicy. TranslateTrack requires one. Nil translates with whisper.
+func (t *Translator) SetBackend(b mt.Backend) {
+	t.backend = b
+}
+
+// SetGlossary makes translations with the backend follow g, and logs and
+// records in the Stats the cues that still break it. Nil disables it.
+func (t *Translator) SetGlossary(g *mt.Glossary) {
+	t.glossary = g
+}
+
+// SetMemory makes translations with the backend reuse the translations in
+// m, and adds the new ones to it. Nil disables it.
+func (t *Translator) SetMemory(m *mt.Memory) {
+	t.memory = m
+}
+
+// TranslateAll transcribes input once and translates it into every language
+// of outputs, which maps target languages to subtitle files written in the
+// format of their extension. The transcript is translated into all
+// languages concurrently with the backend. Without one, whisper can only
+// translate the audio into English, the single target accepted then.
+func (t *Translator) TranslateAll(ctx context.Context, input string, outputs map[string]string) (err error) {
+	if len(outputs) == 0 {
+		return fmt.Errorf("target language is required")
+	}
+	langs := make([]string, 0, len(outputs))
+	for lang := range outputs {
+		if lang == "" {
+			return fmt.Errorf("target language is required")
+		}
+		langs = append(langs, lang)
+	}
+	slices.Sort(langs)
+	if t.backend == nil {
+		if err := requireBackend(langs...); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(input); os.IsNotExist(err) {
+		return fmt.Errorf("input file not found: %s", input)
+	}
+
+	audioFile, done, err := t.prepareAudio(ctx, input, false)
+	if err != nil {
+		return fmt.Errorf("failed to extract audio: %w", err)
+	}
+	defer func() { done(err) }()
+
+	w, err := t.resolve(ctx, audioFile)
+	if err != nil {
+		return fmt.Errorf("failed to select whisper model: %w", err)
+	}
+
+	ws, err := workspace.Create(t.workspace, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { ws.Close(err) }()
+
+	if t.backend == nil {
+		path := ws.Path("translation.srt")
+		if err := t.transcribe(ctx, w, audioFile, path, "en"); err != nil {
+			return fmt.Errorf("failed to translate audio: %w", err)
+		}
+		return convert(path, outputs["en"])
+	}
+
+	transcript := ws.Path("transcript.srt")
+	if err := t.transcribe(ctx, w, audioFile, transcript, ""); err != nil {
+		return fmt.Errorf("failed to transcribe audio: %w", err)
+	}
+	track, err := subtitle.ReadFile(transcript)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(langs))
+	var wg sync.WaitGroup
+	for i, lang := range langs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			translated, err := t.TranslateTrack(ctx, track, w.Config().Language, lang)
+			if err == nil {
+				if t.captions != nil {
+					// Translations run longer or shorter than the transcript
+					translated = t.resegment(ctx, translated, false)
+				}
+				err = subtitle.WriteFile(outputs[lang], translated)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to translate to %s: %w", lang, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// TranslateTrack translates the cues of a transcript in source, empty if
+// unknown, into lang with the backend, keeping their timings. Cues are
+// looked up in the translation memory first, and the result is checked
+// against the glossary.
+func (t *Translator) TranslateTrack(ctx context.Context, track *subtitle.Track, source, lang string) (*subtitle.Track, error) {
+	if t.backend == nil {
+		return nil, fmt.Errorf("translating subtitles requires a translation backend")
+	}
+	ctx = logging.WithAttrs(ctx, "stage", "translate", "lang", lang)
+	defer t.stage(ctx, "translate", time.Now())
+	logger := logging.From(ctx, t.logger)
+
+	// The memory holds translations that already follow the glossary
+	backend := mt.WithGlossary(mt.WithPolicy(t.backend, t.whisperProcessor.Policy), t.glossary)
+	var memory *mt.MemoryBackend
+	if t.memory != nil {
+		memory = mt.WithMemory(backend, t.memory, t.glossary)
+		backend = memory
+	}
+	translated, err := mt.TranslateTrack(ctx, backend, track, source, lang)
+	if err != nil {
+		return nil, err
+	}
+	if memory != nil {
+		m := memory.Matches()
+		logger.Info("translation memory", "exact", m.Exact, "fuzzy", m.Fuzzy, "translated", m.Translated)
+		statsFrom(ctx).memory(m)
+	}
+	if t.glossary != nil {
+		violations := t.glossary.Check(track, translated, lang)
+		for _, v := range violations {
+			logger.Warn("translation breaks the glossary", "cue", v.Cue, "start", v.Start, "term", v.Term, "want", v.Want, "text", v.Text)
+		}
+		statsFrom(ctx).glossary(violations)
+	}
+	return translated, nil
+}
+
+// requireBackend fails unless whisper can translate into langs by itself:
+// whisper only translates into English, one language per transcription
+func requireBackend(langs ...string) error {
+	if len(langs) > 1 {
+		return fmt.Errorf("translating into several languages requires a translation backend")
+	}
+	if len(langs) == 1 && langs[0] != "en" {
+		return fmt.Errorf("translating to %s requires a translation backend", langs[0])
+	}
+	return nil
+}
+
+// convert rewrites the subtitles at src in the format of dst
+func convert(src, dst string) error {
+	track, err := subtitle.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return subtitle.WriteFile(dst, track)
+}
@@ -0,0 +1,116 @@
+package translation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/hallucination"
+	"github.com/gleicon/transcoder/pkg/mt"
+)
+
+// Stats records what a translator call did, for reports: the language that
+// was transcribed, the model, the duration of the audio and the time spent
+// per stage
+type Stats struct {
+	mu       sync.Mutex
+	Language string                   // spoken language, empty if whisper detected it internally
+	Model    string                   // whisper model that transcribed the audio
+	Duration time.Duration            // duration of the transcribed audio
+	Stages   map[string]time.Duration // wall time per stage: extract, detect, transcribe
+	Cached   []string                 // stages whose result came from the cache
+	Missed   []string                 // stages looked up in the cache without a hit
+	Removed  []hallucination.Removal  // cues removed by the hallucination filter
+	Glossary []mt.Violation           // translated cues that break the glossary
+	Memory   mt.MemoryMatches         // cues translated from the translation memory
+}
+
+type statsKey struct{}
+
+// WithStats returns a context that makes translator calls record into s
+func WithStats(ctx context.Context, s *Stats) context.Context {
+	return context.WithValue(ctx, statsKey{}, s)
+}
+
+// statsFrom returns the Stats attached to ctx, or nil
+func statsFrom(ctx context.Context) *Stats {
+	s, _ := ctx.Value(statsKey{}).(*Stats)
+	return s
+}
+
+// stage adds the time since start to a stage
+func (s *Stats) stage(name string, start time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Stages == nil {
+		s.Stages = make(map[string]time.Duration)
+	}
+	s.Stages[name] += time.Since(start)
+}
+
+// cached records a stage served from the cache
+func (s *Stats) cached(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Cached = append(s.Cached, name)
+}
+
+// missed records a stage that was looked up in the cache without a hit
+func (s *Stats) missed(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Missed = append(s.Missed, name)
+}
+
+// audio records the language, model and duration of the transcribed audio
+func (s *Stats) audio(lang, model string, duration time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lang != "auto" {
+		s.Language = lang
+	}
+	s.Model = model
+	s.Duration = duration
+}
+
+// removed records cues removed by the hallucination filter
+func (s *Stats) removed(rs []hallucination.Removal) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Removed = append(s.Removed, rs...)
+}
+
+// glossary records translated cues that break the glossary
+func (s *Stats) glossary(vs []mt.Violation) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Glossary = append(s.Glossary, vs...)
+}
+
+// memory records the cues a translation took from the translation memory
+func (s *Stats) memory(m mt.MemoryMatches) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Memory.Add(m)
+}
@@ -3,19 +3,36 @@ package translation
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/gleicon/transcoder/pkg/cache"
 	"github.com/gleicon/transcoder/pkg/ffmpeg"
+	"github.com/gleicon/transcoder/pkg/hallucination"
+	"github.com/gleicon/transcoder/pkg/logging"
+	"github.com/gleicon/transcoder/pkg/mt"
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/subtitle"
 	"github.com/gleicon/transcoder/pkg/whisper"
+	"github.com/gleicon/transcoder/pkg/workspace"
 )
 
 // Translator represents a translator that can transcribe and translate audio
 type Translator struct {
 	whisperProcessor *whisper.Whisper
 	ffmpegProcessor  *ffmpeg.FFmpeg
+	cache            *cache.Cache
+	workspace        workspace.Config
+	logger           *slog.Logger
+	captions         *subtitle.Style
+	filter           *FilterOptions
+	backend          mt.Backend
+	glossary         *mt.Glossary
+	memory           *mt.Memory
 }
 
 // New creates a new translator with the given FFmpeg and Whisper commands
@@ -53,6 +70,26 @@ func NewWithCmd() (*Translator, error) {
 	return New(ffmpegCmd, whisperCmd)
 }
 
+// NewWithConfig creates a translator that runs the installed ffmpeg and
+// whisper-cli binaries with the given Whisper configuration
+func NewWithConfig(config whisper.Config) (*Translator, error) {
+	ffmpegProcessor, err := ffmpeg.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create FFmpeg processor: %v", err)
+	}
+
+	whisperProcessor, err := whisper.New(config)
+	if err != nil {
+		ffmpegProcessor.Close()
+		return nil, fmt.Errorf("failed to create Whisper processor: %v", err)
+	}
+
+	return &Translator{
+		whisperProcessor: whisperProcessor,
+		ffmpegProcessor:  ffmpegProcessor,
+	}, nil
+}
+
 // Close releases the translator's resources
 func (t *Translator) Close() {
 	if t.whisperProcessor != nil {
@@ -63,11 +100,341 @@ func (t *Translator) Close() {
 	}
 }
 
-// Translate transcribes and translates an audio file
-func (t *Translator) Translate(ctx context.Context, input, output, targetLang string) error {
+// SetCache makes the translator reuse extracted audio and transcripts stored
+// in c. Without a cache every call runs ffmpeg and whisper.
+func (t *Translator) SetCache(c *cache.Cache) {
+	t.cache = c
+}
+
+// SetPolicy sets the timeouts and retries of the ffmpeg and whisper-cli runs.
+// Translators created by NewWithConfig start with policy.Default().
+func (t *Translator) SetPolicy(p *policy.Policy) {
+	t.ffmpegProcessor.Policy = p
+	t.whisperProcessor.Policy = p
+}
+
+// SetLogger sets the logger of the translator and of its ffmpeg and
+// whisper-cli runs. By default they log to slog.Default().
+func (t *Translator) SetLogger(logger *slog.Logger) {
+	t.logger = logger
+	t.ffmpegProcessor.Logger = logger
+	t.whisperProcessor.Logger = logger
+}
+
+// SetWorkspace configures where audio extracted from the input is kept while
+// it is transcribed. By default it goes to a temporary directory.
+func (t *Translator) SetWorkspace(config workspace.Config) {
+	t.workspace = config
+}
+
+// SetCaptions re-segments transcripts into cues following style, such as
+// subtitle.DefaultStyle(). Transcriptions are then made with word timestamps
+// to break cues between words; translations, which whisper does not time by
+// word, are re-segmented with word timings estimated from its segments. Nil
+// keeps the segments of whisper.
+func (t *Translator) SetCaptions(style *subtitle.Style) {
+	t.captions = style
+}
+
+// prepareAudio returns a WAV file for input. Unless input is already a WAV
+// file (and always is false), the audio is extracted into a new workspace;
+// done must be called with the outcome of the job to clean it up.
+func (t *Translator) prepareAudio(ctx context.Context, input string, always bool) (audio string, done func(error), err error) {
+	if !always && strings.ToLower(filepath.Ext(input)) == ".wav" {
+		return input, func(error) {}, nil
+	}
+
+	var size int64
+	if info, err := os.Stat(input); err == nil {
+		size = info.Size()
+	}
+	ws, err := workspace.Create(t.workspace, workspace.MediaNeed(size, 0, 0))
+	if err != nil {
+		return "", nil, err
+	}
+	done = func(err error) {
+		ws.Close(err)
+	}
+
+	audio = ws.Path(strings.TrimSuffix(filepath.Base(input), filepath.Ext(input)) + ".wav")
+	if err := t.ExtractAudio(ctx, input, audio); err != nil {
+		done(err)
+		return "", nil, err
+	}
+	return audio, done, nil
+}
+
+// ExtractAudio extracts a 16kHz mono WAV track from input, reusing a cached
+// copy extracted from identical content
+func (t *Translator) ExtractAudio(ctx context.Context, input, output string) error {
+	ctx = logging.WithAttrs(ctx, "stage", "extract")
+	defer t.stage(ctx, "extract", time.Now())
+
+	var key string
+	if t.cache != nil {
+		if sum, err := t.cache.FileHash(input); err == nil {
+			key = cache.Key("audio", sum, "pcm_s16le", "16000", "1")
+			if ok, _ := t.cache.Get(key, output); ok {
+				t.cached(ctx, "extract")
+				return nil
+			}
+			statsFrom(ctx).missed("extract")
+		}
+	}
+
+	if err := t.ffmpegProcessor.ExtractAudio(ctx, input, output); err != nil {
+		return err
+	}
+	if key != "" {
+		// The cache is an optimization, failing to fill it is not an error
+		t.cache.Put(key, output)
+	}
+	return nil
+}
+
+// transcribe runs whisper on a WAV file, translating into English when
+// targetLang is set, and reuses a transcript cached for the same audio, model
+// and options. The transcript is then re-segmented and filtered as configured.
+func (t *Translator) transcribe(ctx context.Context, w *whisper.Whisper, audio, output, targetLang string) (err error) {
+	words := t.captions != nil && targetLang == ""
+	// whisper-cli writes its own JSON for .json files, so a .json output is
+	// written from an SRT transcript like any other subtitle format
+	jsonOutput := filepath.Ext(output) == ".json"
+	if t.captions == nil && t.filter == nil && !jsonOutput {
+		return t.runWhisper(ctx, w, audio, output, targetLang, words)
+	}
+
+	// Whisper's full JSON holds the probabilities the filter weighs; it is
+	// turned into subtitles once processed
+	raw := output
+	if t.filter != nil || jsonOutput {
+		ws, err := workspace.Create(t.workspace, 0)
+		if err != nil {
+			return err
+		}
+		defer func() { ws.Close(err) }()
+		raw = ws.Path("transcript.srt")
+		if t.filter != nil {
+			raw = ws.Path("transcript.json")
+		}
+	}
+	if err := t.runWhisper(ctx, w, audio, raw, targetLang, words); err != nil {
+		return err
+	}
+	return t.postprocess(ctx, audio, raw, output, words)
+}
+
+// runWhisper writes whisper's transcript of audio to output, as SRT or, for
+// the .json workspace files the filter reads, as full JSON, or copies the one
+// cached for the same audio, model and options. Caching whisper's own output lets a change of caption
+// style or filter reuse it.
+func (t *Translator) runWhisper(ctx context.Context, w *whisper.Whisper, audio, output, targetLang string, words bool) error {
+	ctx = logging.WithAttrs(ctx, "stage", "transcribe")
+	defer t.stage(ctx, "transcribe", time.Now())
+	statsFrom(ctx).audio(w.Config().Language, modelName(w.Config()), whisper.WAVDuration(audio))
+
+	run := func() error {
+		if targetLang != "" {
+			return w.TranscribeWithTranslation(ctx, audio, output, targetLang)
+		}
+		if words {
+			return w.TranscribeWords(ctx, audio, output)
+		}
+		return w.Transcribe(ctx, audio, output)
+	}
+	if t.cache == nil {
+		return run()
+	}
+
+	sum, err := t.cache.FileHash(audio)
+	if err != nil {
+		return run()
+	}
+	config := w.Config()
+	parts := []string{"transcript", sum, modelID(config.ModelPath), config.Language, "translate=" + targetLang}
+	if words {
+		parts = append(parts, "words")
+	}
+	if targetLang != "" {
+		// Translations cached before whisper was told the spoken language
+		// were made from audio taken for English, and are not reused
+		parts = append(parts, "source-lang")
+	}
+	if filepath.Ext(output) == ".json" {
+		parts = append(parts, "json")
+	}
+	key := cache.Key(parts...)
+	if ok, _ := t.cache.Get(key, output); ok {
+		t.cached(ctx, "transcribe")
+		return nil
+	}
+	statsFrom(ctx).missed("transcribe")
+
+	if err := run(); err != nil {
+		return err
+	}
+	t.cache.Put(key, output)
+	return nil
+}
+
+// postprocess turns the whisper transcript in raw into the subtitles written
+// to output. The filter looks at whisper's segments, where repeats are whole
+// segments, except for word level transcripts, whose words are re-segmented
+// into cues first.
+func (t *Translator) postprocess(ctx context.Context, audio, raw, output string, words bool) error {
+	var (
+		track  *subtitle.Track
+		scores []hallucination.Score
+		err    error
+	)
+	if filepath.Ext(raw) == ".json" {
+		track, scores, err = readSegments(raw)
+	} else {
+		track, err = subtitle.ReadFile(raw)
+	}
+	if err != nil {
+		return err
+	}
+
+	if t.filter != nil && !words {
+		if track, err = t.filterTrack(ctx, audio, track, scores); err != nil {
+			return err
+		}
+	}
+	if t.captions != nil {
+		track = t.resegment(ctx, track, words)
+	}
+	if t.filter != nil && words {
+		if track, err = t.filterTrack(ctx, audio, track, scores); err != nil {
+			return err
+		}
+	}
+	return subtitle.WriteFile(output, track)
+}
+
+// readSegments reads whisper's full JSON transcript into a track and the
+// probabilities of its segments
+func readSegments(path string) (*subtitle.Track, []hallucination.Score, error) {
+	segments, err := whisper.ReadSegments(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	track := &subtitle.Track{}
+	scores := make([]hallucination.Score, 0, len(segments))
+	for _, s := range segments {
+		if s.Text == "" {
+			continue
+		}
+		track.Cues = append(track.Cues, subtitle.Cue{Start: s.Start, End: s.End, Text: s.Text})
+		scores = append(scores, hallucination.Score{Start: s.Start, End: s.End, Probability: s.Probability})
+	}
+	track.Renumber()
+	return track, scores, nil
+}
+
+// resegment splits and merges the cues of track to follow the caption style,
+// from the words of a word level transcript or else from word timings
+// estimated from whisper's segments
+func (t *Translator) resegment(ctx context.Context, track *subtitle.Track, words bool) *subtitle.Track {
+	segments := len(track.Cues)
+	if words {
+		ws := make([]subtitle.Word, len(track.Cues))
+		for i, c := range track.Cues {
+			ws[i] = subtitle.Word{Text: c.Text, Start: c.Start, End: c.End}
+		}
+		track = subtitle.Segment(ws, *t.captions)
+	} else {
+		track.Resegment(*t.captions)
+	}
+	logging.From(ctx, t.logger).Info("re-segmented captions", "segments", segments, "cues", len(track.Cues), "words", words)
+	return track
+}
+
+// resolve picks the whisper model for audio, timing the language detection
+// automatic selection may need
+func (t *Translator) resolve(ctx context.Context, audio string) (*whisper.Whisper, error) {
+	if t.whisperProcessor.Config().Model == whisper.AutoModel {
+		ctx = logging.WithAttrs(ctx, "stage", "detect")
+		defer t.stage(ctx, "detect", time.Now())
+	}
+	return t.whisperProcessor.Resolve(ctx, audio)
+}
+
+// stage records and logs the time spent in a stage since start
+func (t *Translator) stage(ctx context.Context, name string, start time.Time) {
+	statsFrom(ctx).stage(name, start)
+	logging.From(ctx, t.logger).Info("stage finished", "duration", time.Since(start))
+}
+
+// cached records and logs a stage whose result came from the cache
+func (t *Translator) cached(ctx context.Context, name string) {
+	statsFrom(ctx).cached(name)
+	logging.From(ctx, t.logger).Info("using cached result")
+}
+
+// modelName returns the name of the configured model, such as base.en
+func modelName(config whisper.Config) string {
+	if config.Model != "" && config.Model != whisper.AutoModel {
+		return config.Model
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(filepath.Base(config.ModelPath), "ggml-"), ".bin")
+}
+
+// modelID identifies a model file by path, size and modification time, which
+// is cheaper than hashing gigabytes of weights on every run
+func modelID(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return path
+	}
+	return fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano())
+}
+
+// Transcribe transcribes an audio or video file without translating it, to
+// SRT, or to subtitle JSON for a .json output
+func (t *Translator) Transcribe(ctx context.Context, input, output string) (err error) {
+	if _, err := os.Stat(input); os.IsNotExist(err) {
+		return fmt.Errorf("input file not found: %s", input)
+	}
+
+	// Create output directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// If the input is not a WAV file, convert it
+	audioFile, done, err := t.prepareAudio(ctx, input, false)
+	if err != nil {
+		return fmt.Errorf("failed to extract audio: %w", err)
+	}
+	defer func() { done(err) }()
+
+	// Pick a model for the audio if automatic selection is configured
+	w, err := t.resolve(ctx, audioFile)
+	if err != nil {
+		return fmt.Errorf("failed to select whisper model: %w", err)
+	}
+
+	if err := t.transcribe(ctx, w, audioFile, output, ""); err != nil {
+		return fmt.Errorf("failed to transcribe audio: %w", err)
+	}
+
+	return nil
+}
+
+// Translate transcribes and translates an audio file. With a backend the
+// transcript is translated as by TranslateAll; without one whisper translates
+// the audio, which it only does into English.
+func (t *Translator) Translate(ctx context.Context, input, output, targetLang string) (err error) {
 	if targetLang == "" {
 		return fmt.Errorf("target language is required")
 	}
+	if t.backend != nil {
+		return t.TranslateAll(ctx, input, map[string]string{targetLang: output})
+	}
+	if err := requireBackend(targetLang); err != nil {
+		return err
+	}
 
 	if _, err := os.Stat(input); os.IsNotExist(err) {
 		return fmt.Errorf("input file not found: %s", input)
@@ -79,30 +446,38 @@ func (t *Translator) Translate(ctx context.Context, input, output, targetLang st
 	}
 
 	// If the input is not a WAV file, convert it
-	var audioFile string
-	if strings.ToLower(filepath.Ext(input)) != ".wav" {
-		audioFile = strings.TrimSuffix(output, ".srt") + ".wav"
-		if err := t.ffmpegProcessor.ExtractAudio(ctx, input, audioFile); err != nil {
-			return fmt.Errorf("failed to extract audio: %v", err)
-		}
-		defer os.Remove(audioFile)
-	} else {
-		audioFile = input
+	audioFile, done, err := t.prepareAudio(ctx, input, false)
+	if err != nil {
+		return fmt.Errorf("failed to extract audio: %w", err)
+	}
+	defer func() { done(err) }()
+
+	// Pick a model for the audio if automatic selection is configured
+	w, err := t.resolve(ctx, audioFile)
+	if err != nil {
+		return fmt.Errorf("failed to select whisper model: %w", err)
 	}
 
 	// Transcribe and translate audio
-	if err := t.whisperProcessor.TranscribeWithTranslation(ctx, audioFile, output, targetLang); err != nil {
+	if err := t.transcribe(ctx, w, audioFile, output, targetLang); err != nil {
 		return fmt.Errorf("failed to translate audio: %w", err)
 	}
 
 	return nil
 }
 
-// TranslateFile transcribes and translates a video file
-func (t *Translator) TranslateFile(ctx context.Context, input, output, targetLang string) error {
+// TranslateFile transcribes and translates a video file, into English only
+// unless a backend is set
+func (t *Translator) TranslateFile(ctx context.Context, input, output, targetLang string) (err error) {
 	if targetLang == "" {
 		return fmt.Errorf("target language is required")
 	}
+	if t.backend != nil {
+		return t.TranslateAll(ctx, input, map[string]string{targetLang: output})
+	}
+	if err := requireBackend(targetLang); err != nil {
+		return err
+	}
 
 	if _, err := os.Stat(input); os.IsNotExist(err) {
 		return fmt.Errorf("input file not found: %s", input)
@@ -114,13 +489,20 @@ func (t *Translator) TranslateFile(ctx context.Context, input, output, targetLan
 	}
 
 	// Extract audio from input file
-	audioFile := filepath.Join(filepath.Dir(output), filepath.Base(input)+".wav")
-	if err := t.ffmpegProcessor.ExtractAudio(ctx, input, audioFile); err != nil {
+	audioFile, done, err := t.prepareAudio(ctx, input, true)
+	if err != nil {
 		return fmt.Errorf("failed to extract audio: %w", err)
 	}
+	defer func() { done(err) }()
+
+	// Pick a model for the audio if automatic selection is configured
+	w, err := t.resolve(ctx, audioFile)
+	if err != nil {
+		return fmt.Errorf("failed to select whisper model: %w", err)
+	}
 
 	// Transcribe and translate audio
-	if err := t.whisperProcessor.TranscribeWithTranslation(ctx, audioFile, output, targetLang); err != nil {
+	if err := t.transcribe(ctx, w, audioFile, output, targetLang); err != nil {
 		return fmt.Errorf("failed to transcribe and translate audio: %w", err)
 	}
 
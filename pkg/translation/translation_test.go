@@ -9,6 +9,14 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/cache"
+	"github.com/gleicon/transcoder/pkg/ffmpeg"
+	"github.com/gleicon/transcoder/pkg/hallucination"
+	"github.com/gleicon/transcoder/pkg/mt"
+	"github.com/gleicon/transcoder/pkg/subtitle"
+	"github.com/gleicon/transcoder/pkg/whisper"
 )
 
 func mockCommand(name string, args ...string) *exec.Cmd {
@@ -233,14 +241,14 @@ func TestTranslate(t *testing.T) {
 			name:       "valid input",
 			input:      inputFile,
 			output:     outputFile,
-			targetLang: "es",
+			targetLang: "en",
 			wantErr:    false,
 		},
 		{
 			name:        "nonexistent input",
 			input:       "nonexistent.wav",
 			output:      outputFile,
-			targetLang:  "es",
+			targetLang:  "en",
 			wantErr:     true,
 			errContains: "input file not found",
 		},
@@ -269,6 +277,54 @@ func TestTranslate(t *testing.T) {
 	}
 }
 
+func TestTranscribe(t *testing.T) {
+	// Create test files
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "input.wav")
+	outputFile := filepath.Join(tmpDir, "output.srt")
+	createTestFile(t, inputFile)
+
+	// Create translator
+	translator, err := New(mockCommand("ffmpeg"), mockCommand("whisper-cli"))
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+	defer translator.Close()
+
+	tests := []struct {
+		name        string
+		input       string
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name:    "valid input",
+			input:   inputFile,
+			wantErr: false,
+		},
+		{
+			name:        "nonexistent input",
+			input:       "nonexistent.wav",
+			wantErr:     true,
+			errContains: "input file not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := translator.Transcribe(context.Background(), tt.input, outputFile)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Transcribe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && tt.errContains != "" && err != nil {
+				if !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("Transcribe() error = %v, want error containing %v", err, tt.errContains)
+				}
+			}
+		})
+	}
+}
+
 func TestTranslateFile(t *testing.T) {
 	// Get the test file's directory
 	_, testFile, _, _ := runtime.Caller(0)
@@ -295,21 +351,21 @@ func TestTranslateFile(t *testing.T) {
 			name:       "valid_mp3",
 			input:      filepath.Join(testDataDir, "samples", "sample.mp3"),
 			output:     "output.srt",
-			targetLang: "es",
+			targetLang: "en",
 			wantErr:    false,
 		},
 		{
 			name:       "valid_mp4",
 			input:      filepath.Join(testDataDir, "samples", "sample.mp4"),
 			output:     "output.srt",
-			targetLang: "es",
+			targetLang: "en",
 			wantErr:    false,
 		},
 		{
 			name:        "nonexistent_input",
 			input:       "nonexistent.mp3",
 			output:      "output.srt",
-			targetLang:  "es",
+			targetLang:  "en",
 			wantErr:     true,
 			errContains: "input file not found",
 		},
@@ -351,3 +407,319 @@ func TestTranslateFile(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractAudioCached(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "talk.mp4")
+	if err := os.WriteFile(input, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cache.Open(filepath.Join(dir, "cache"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := c.FileHash(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := filepath.Join(dir, "cached.wav")
+	if err := os.WriteFile(cached, []byte("cached wav"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(cache.Key("audio", sum, "pcm_s16le", "16000", "1"), cached); err != nil {
+		t.Fatal(err)
+	}
+
+	// A cache hit must not run ffmpeg
+	translator := &Translator{ffmpegProcessor: &ffmpeg.FFmpeg{}}
+	translator.SetCache(c)
+	output := filepath.Join(dir, "out", "talk.wav")
+	if err := translator.ExtractAudio(context.Background(), input, output); err != nil {
+		t.Fatalf("ExtractAudio() error = %v", err)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil || string(got) != "cached wav" {
+		t.Errorf("ExtractAudio() wrote %q, %v; want the cached audio", got, err)
+	}
+}
+
+func TestPostprocessFilter(t *testing.T) {
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "transcript.json")
+	segment := func(from, to int, text string, p float64) string {
+		return fmt.Sprintf(`{"offsets": {"from": %d, "to": %d}, "text": %q, "tokens": [{"text": %q, "p": %g}]}`, from, to, text, text, p)
+	}
+	json := `{"transcription": [` + strings.Join([]string{
+		segment(0, 2000, " We sail at dawn.", 0.9),
+		segment(2000, 4000, " The sea is calm.", 0.9),
+		segment(4000, 6000, " The sea is calm.", 0.8),
+		segment(6000, 8000, " The sea is calm.", 0.8),
+		segment(8000, 10000, " Mumbled words", 0.2),
+		segment(10000, 12000, " Thank you for watching.", 0.9),
+	}, ",") + `]}`
+	if err := os.WriteFile(raw, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	translator := &Translator{}
+	translator.SetFilter(&FilterOptions{})
+	stats := &Stats{}
+	output := filepath.Join(dir, "talk.srt")
+	if err := translator.postprocess(WithStats(context.Background(), stats), "", raw, output, false); err != nil {
+		t.Fatalf("postprocess() error = %v", err)
+	}
+
+	track, err := subtitle.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(track.Cues) != 2 || track.Cues[0].Text != "We sail at dawn." || track.Cues[1].Text != "The sea is calm." {
+		t.Errorf("postprocess() kept %+v", track.Cues)
+	}
+	var reasons []string
+	for _, r := range stats.Removed {
+		reasons = append(reasons, r.Reason)
+	}
+	want := []string{hallucination.Repeated, hallucination.Repeated, hallucination.LowProbability, hallucination.Boilerplate}
+	if strings.Join(reasons, ",") != strings.Join(want, ",") {
+		t.Errorf("Stats.Removed reasons = %v, want %v", reasons, want)
+	}
+}
+
+// fakeWhisperCLI puts a whisper-cli on PATH that writes one cue where -of
+// points and appends its arguments, a line per run, to the returned file
+func fakeWhisperCLI(t *testing.T, dir string) string {
+	t.Helper()
+	argsFile := filepath.Join(dir, "whisper-args")
+	script := "#!/bin/sh\necho \"$@\" >> " + argsFile + "\n" +
+		"while [ $# -gt 0 ]; do [ \"$1\" = -of ] && out=$2; shift; done\n" +
+		"printf '1\\n00:00:01,000 --> 00:00:02,000\\nTest subtitle\\n' > \"$out.srt\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "whisper-cli"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+// whisperRuns returns the arguments of every run of fakeWhisperCLI
+func whisperRuns(t *testing.T, argsFile string) []string {
+	t.Helper()
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		runs = append(runs, " "+line+" ")
+	}
+	return runs
+}
+
+// newWhisperTranslator returns a translator running fakeWhisperCLI on Spanish
+// audio, with the input file, the file of the whisper-cli arguments and a
+// directory for outputs
+func newWhisperTranslator(t *testing.T) (*Translator, string, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	input := filepath.Join(dir, "talk.wav")
+	if err := os.WriteFile(input, []byte("wav"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := whisper.DefaultConfig()
+	config.Language = "es"
+	config.ModelPath = filepath.Join(dir, "ggml-base.bin")
+	if err := os.WriteFile(config.ModelPath, []byte("model"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	argsFile := fakeWhisperCLI(t, dir)
+	w, err := whisper.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Translator{ffmpegProcessor: &ffmpeg.FFmpeg{}, whisperProcessor: w}, input, argsFile, dir
+}
+
+func TestTranslateAllWithWhisper(t *testing.T) {
+	translator, input, argsFile, dir := newWhisperTranslator(t)
+	if err := translator.TranslateAll(context.Background(), input, map[string]string{"en": filepath.Join(dir, "talk.en.srt")}); err != nil {
+		t.Fatalf("TranslateAll() error = %v", err)
+	}
+	// -l names the spoken language; whisper translates into English with -tr
+	runs := whisperRuns(t, argsFile)
+	if len(runs) != 1 || !strings.Contains(runs[0], " -l es ") || !strings.Contains(runs[0], " -tr ") {
+		t.Errorf("whisper-cli runs = %q, want one translation of Spanish audio", runs)
+	}
+}
+
+func TestTranslateBilingualWithWhisper(t *testing.T) {
+	translator, input, argsFile, dir := newWhisperTranslator(t)
+	output := filepath.Join(dir, "talk.bilingual.srt")
+	if err := translator.TranslateBilingual(context.Background(), input, output, "en"); err != nil {
+		t.Fatalf("TranslateBilingual() error = %v", err)
+	}
+	// The transcript and then the translation, both of Spanish audio
+	runs := whisperRuns(t, argsFile)
+	if len(runs) != 2 || strings.Contains(runs[0], " -tr ") || !strings.Contains(runs[1], " -tr ") {
+		t.Fatalf("whisper-cli runs = %q, want a transcription and a translation", runs)
+	}
+	for _, run := range runs {
+		if !strings.Contains(run, " -l es ") {
+			t.Errorf("whisper-cli run %q does not name the spoken language", run)
+		}
+	}
+}
+
+func TestTranscribeJSON(t *testing.T) {
+	translator, input, argsFile, dir := newWhisperTranslator(t)
+	output := filepath.Join(dir, "talk.json")
+	if err := translator.Transcribe(context.Background(), input, output); err != nil {
+		t.Fatalf("Transcribe() error = %v", err)
+	}
+	// Subtitle JSON, as with a filter or captions, not whisper-cli's own
+	track, err := subtitle.ReadFile(output)
+	if err != nil || len(track.Cues) != 1 || track.Cues[0].Text != "Test subtitle" {
+		t.Errorf("Transcribe() wrote %+v, %v", track, err)
+	}
+	if runs := whisperRuns(t, argsFile); len(runs) != 1 || strings.Contains(runs[0], " -ojf ") {
+		t.Errorf("whisper-cli runs = %q, want an SRT transcript", runs)
+	}
+}
+
+// prefixBackend translates by prefixing texts with the target language
+type prefixBackend struct{}
+
+func (prefixBackend) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	out := make([]string, len(texts))
+	for i, text := range texts {
+		out[i] = target + ": " + text
+	}
+	return out, nil
+}
+
+func TestTranslateAll(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "talk.wav")
+	if err := os.WriteFile(input, []byte("wav"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := whisper.DefaultConfig()
+	config.ModelPath = filepath.Join(dir, "ggml-base.bin")
+	if err := os.WriteFile(config.ModelPath, []byte("model"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fakeWhisperCLI(t, dir)
+	w, err := whisper.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	translator := &Translator{ffmpegProcessor: &ffmpeg.FFmpeg{}, whisperProcessor: w}
+	translator.SetBackend(prefixBackend{})
+
+	outputs := map[string]string{
+		"es": filepath.Join(dir, "talk.es.srt"),
+		"fr": filepath.Join(dir, "talk.fr.vtt"),
+		"ja": filepath.Join(dir, "talk.ja.json"),
+	}
+	if err := translator.TranslateAll(context.Background(), input, outputs); err != nil {
+		t.Fatalf("TranslateAll() error = %v", err)
+	}
+	for lang, path := range outputs {
+		track, err := subtitle.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", lang, err)
+		}
+		if len(track.Cues) != 1 || track.Cues[0].Text != lang+": Test subtitle" {
+			t.Errorf("%s translation = %+v", lang, track.Cues)
+		}
+	}
+
+	translator.SetGlossary(mt.NewGlossary([]mt.Term{{Source: "subtitle", Targets: map[string]string{"es": "subtítulo"}}}))
+	stats := &Stats{}
+	if err := translator.TranslateAll(WithStats(context.Background(), stats), input, map[string]string{"es": outputs["es"]}); err != nil {
+		t.Fatalf("TranslateAll() with a glossary error = %v", err)
+	}
+	if track, err := subtitle.ReadFile(outputs["es"]); err != nil || track.Cues[0].Text != "es: Test subtítulo" {
+		t.Errorf("translation with a glossary = %+v, %v", track, err)
+	}
+	if len(stats.Glossary) != 0 {
+		t.Errorf("Stats.Glossary = %v, want no violations", stats.Glossary)
+	}
+
+	memory, err := mt.OpenMemory(filepath.Join(dir, "tm.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	translator.SetMemory(memory)
+	for _, want := range []mt.MemoryMatches{{Translated: 1}, {Exact: 1}} {
+		stats := &Stats{}
+		if err := translator.TranslateAll(WithStats(context.Background(), stats), input, map[string]string{"es": outputs["es"]}); err != nil {
+			t.Fatalf("TranslateAll() with a memory error = %v", err)
+		}
+		if stats.Memory != want {
+			t.Errorf("Stats.Memory = %+v, want %+v", stats.Memory, want)
+		}
+	}
+
+	if err := translator.TranslateAll(context.Background(), input, map[string]string{"": outputs["es"]}); err == nil {
+		t.Error("TranslateAll() accepted an empty language")
+	}
+}
+
+func TestTranslateAllRequiresBackend(t *testing.T) {
+	translator := &Translator{}
+	for _, outputs := range []map[string]string{
+		{"es": "talk.es.srt"},
+		{"en": "talk.en.srt", "fr": "talk.fr.srt"},
+	} {
+		err := translator.TranslateAll(context.Background(), "talk.mp4", outputs)
+		if err == nil || !strings.Contains(err.Error(), "requires a translation backend") {
+			t.Errorf("TranslateAll(%v) without a backend error = %v", outputs, err)
+		}
+	}
+}
+
+func TestTranslateRequiresBackend(t *testing.T) {
+	translator := &Translator{}
+	if err := translator.Translate(context.Background(), "talk.wav", "talk.es.srt", "es"); err == nil || !strings.Contains(err.Error(), "requires a translation backend") {
+		t.Errorf("Translate() to es without a backend error = %v", err)
+	}
+	if err := translator.TranslateFile(context.Background(), "talk.mp4", "talk.es.srt", "es"); err == nil || !strings.Contains(err.Error(), "requires a translation backend") {
+		t.Errorf("TranslateFile() to es without a backend error = %v", err)
+	}
+}
+
+func TestTranslateBilingualRequiresBackend(t *testing.T) {
+	err := (&Translator{}).TranslateBilingual(context.Background(), "talk.mp4", "talk.es.vtt", "es")
+	if err == nil || !strings.Contains(err.Error(), "requires a translation backend") {
+		t.Errorf("TranslateBilingual() to es without a backend error = %v", err)
+	}
+}
+
+func TestTranslateTrack(t *testing.T) {
+	track := &subtitle.Track{Cues: []subtitle.Cue{{Start: time.Second, End: 2 * time.Second, Text: "Welcome to Acme."}}}
+	translator := &Translator{ffmpegProcessor: &ffmpeg.FFmpeg{}, whisperProcessor: &whisper.Whisper{}}
+	if _, err := translator.TranslateTrack(context.Background(), track, "en", "es"); err == nil || !strings.Contains(err.Error(), "requires a translation backend") {
+		t.Errorf("TranslateTrack() without a backend error = %v", err)
+	}
+
+	memory, err := mt.OpenMemory(filepath.Join(t.TempDir(), "tm.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	translator.SetBackend(prefixBackend{})
+	translator.SetGlossary(mt.NewGlossary([]mt.Term{{Source: "Acme", Targets: map[string]string{"es": "Acmé"}}}))
+	translator.SetMemory(memory)
+	stats := &Stats{}
+	translated, err := translator.TranslateTrack(WithStats(context.Background(), stats), track, "en", "es")
+	if err != nil {
+		t.Fatalf("TranslateTrack() error = %v", err)
+	}
+	if got := translated.Cues[0]; got.Text != "es: Welcome to Acmé." || got.Start != time.Second {
+		t.Errorf("TranslateTrack() cue = %+v", got)
+	}
+	if want := (mt.MemoryMatches{Translated: 1}); stats.Memory != want || len(stats.Glossary) != 0 {
+		t.Errorf("Stats = %+v, want %+v and no glossary violations", stats, want)
+	}
+}
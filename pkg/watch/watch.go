@@ -0,0 +1,281 @@
+// Package watch monitors a drop folder and processes media files once they are
+// completely written.
+package watch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/gleicon/transcoder/pkg/batch"
+	"github.com/gleicon/transcoder/pkg/logging"
+)
+
+// Handler processes a stable file. Records logged through ctx, as with
+// logging.From, end up in the sidecar log next to the moved original. outDir
+// is the directory the original will be moved to on success.
+type Handler func(ctx context.Context, input, outDir string) error
+
+// Config holds the configuration of a Watcher
+type Config struct {
+	Dir       string        // drop folder to monitor
+	DoneDir   string        // where processed originals go, default <Dir>/done
+	FailedDir string        // where failed originals go, default <Dir>/failed
+	StableFor time.Duration // how long size and mtime must stay unchanged
+	Interval  time.Duration // how often pending files are checked and, when polling, the folder rescanned
+	Poll      bool          // scan the folder instead of using filesystem notifications
+	Workers   int           // files processed concurrently
+	Logger    *slog.Logger  // receives watcher events, defaults to the default logger
+}
+
+// DefaultConfig returns a configuration for watching dir
+func DefaultConfig(dir string) Config {
+	return Config{
+		Dir:       dir,
+		DoneDir:   filepath.Join(dir, "done"),
+		FailedDir: filepath.Join(dir, "failed"),
+		StableFor: 5 * time.Second,
+		Interval:  time.Second,
+		Workers:   1,
+	}
+}
+
+// fileState tracks a pending file until it stops changing
+type fileState struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// Watcher monitors a drop folder
+type Watcher struct {
+	config  Config
+	handler Handler
+	logger  *slog.Logger
+
+	mu       sync.Mutex
+	pending  map[string]fileState
+	inFlight map[string]bool
+}
+
+// New creates a watcher for the configured folder
+func New(config Config, handler Handler) (*Watcher, error) {
+	if handler == nil {
+		return nil, fmt.Errorf("handler is required")
+	}
+	info, err := os.Stat(config.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("watch directory not found: %s", config.Dir)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", config.Dir)
+	}
+
+	defaults := DefaultConfig(config.Dir)
+	if config.DoneDir == "" {
+		config.DoneDir = defaults.DoneDir
+	}
+	if config.FailedDir == "" {
+		config.FailedDir = defaults.FailedDir
+	}
+	if config.StableFor <= 0 {
+		config.StableFor = defaults.StableFor
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.Workers < 1 {
+		config.Workers = defaults.Workers
+	}
+	for _, dir := range []string{config.DoneDir, config.FailedDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %v", dir, err)
+		}
+	}
+
+	return &Watcher{
+		config:   config,
+		handler:  handler,
+		logger:   config.Logger,
+		pending:  make(map[string]fileState),
+		inFlight: make(map[string]bool),
+	}, nil
+}
+
+// Run watches the folder until ctx is cancelled, then waits for files being
+// processed to finish
+func (w *Watcher) Run(ctx context.Context) error {
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if !w.config.Poll {
+		fsw, err := fsnotify.NewWatcher()
+		if err == nil {
+			err = fsw.Add(w.config.Dir)
+		}
+		if err != nil {
+			logging.From(ctx, w.logger).Warn("filesystem notifications unavailable, polling", "dir", w.config.Dir, "error", err)
+		} else {
+			defer fsw.Close()
+			events, errs = fsw.Events, fsw.Errors
+		}
+	}
+
+	// Files dropped before we started are picked up by an initial scan
+	w.scan(ctx)
+
+	sem := make(chan struct{}, w.config.Workers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if ev.Has(fsnotify.Create) || ev.Has(fsnotify.Write) {
+				w.observe(ev.Name)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			logging.From(ctx, w.logger).Warn("filesystem notification failed", "error", err)
+		case <-ticker.C:
+			if events == nil {
+				w.scan(ctx)
+			}
+			for _, path := range w.stable(time.Now()) {
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					w.release(path)
+					return nil
+				}
+				wg.Add(1)
+				go func(path string) {
+					defer wg.Done()
+					defer func() { <-sem }()
+					w.process(ctx, path)
+				}(path)
+			}
+		}
+	}
+}
+
+// scan adds every candidate file in the folder to the pending set
+func (w *Watcher) scan(ctx context.Context) {
+	entries, err := os.ReadDir(w.config.Dir)
+	if err != nil {
+		logging.From(ctx, w.logger).Error("failed to scan", "dir", w.config.Dir, "error", err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			w.observe(filepath.Join(w.config.Dir, entry.Name()))
+		}
+	}
+}
+
+// observe records a file as pending if it is a media file directly in the folder
+func (w *Watcher) observe(path string) {
+	name := filepath.Base(path)
+	if filepath.Dir(path) != filepath.Clean(w.config.Dir) || strings.HasPrefix(name, ".") || !batch.IsMedia(name) {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.inFlight[path] {
+		return
+	}
+	if _, ok := w.pending[path]; !ok {
+		w.pending[path] = fileState{size: -1}
+	}
+}
+
+// stable returns the pending files whose size and mtime have not changed for
+// StableFor and marks them as in flight
+func (w *Watcher) stable(now time.Time) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var ready []string
+	for path, state := range w.pending {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Removed or renamed before it settled
+			delete(w.pending, path)
+			continue
+		}
+		if info.Size() != state.size || !info.ModTime().Equal(state.modTime) {
+			w.pending[path] = fileState{size: info.Size(), modTime: info.ModTime(), since: now}
+			continue
+		}
+		if now.Sub(state.since) >= w.config.StableFor {
+			delete(w.pending, path)
+			w.inFlight[path] = true
+			ready = append(ready, path)
+		}
+	}
+	return ready
+}
+
+// release forgets an in-flight file
+func (w *Watcher) release(path string) {
+	w.mu.Lock()
+	delete(w.inFlight, path)
+	w.mu.Unlock()
+}
+
+// process runs the handler on a file and moves it to the done or failed folder
+// with a sidecar log of the records logged for it
+func (w *Watcher) process(ctx context.Context, path string) {
+	defer w.release(path)
+
+	name := filepath.Base(path)
+	var logBuf bytes.Buffer
+	ctx = logging.WithHandler(logging.WithAttrs(ctx, "file", name), slog.NewTextHandler(&logBuf, nil))
+	logger := logging.From(ctx, w.logger)
+
+	start := time.Now()
+	logger.Info("processing file")
+
+	err := w.handler(ctx, path, w.config.DoneDir)
+	if err != nil && ctx.Err() != nil {
+		// Shutting down: leave the original in place so it is picked up again
+		logger.Info("interrupted, leaving file in place", "dir", w.config.Dir)
+		return
+	}
+
+	dest := w.config.DoneDir
+	if err != nil {
+		dest = w.config.FailedDir
+		logger.Error("processing failed", "duration", time.Since(start).Round(time.Millisecond), "error", err)
+	} else {
+		logger.Info("processing completed", "duration", time.Since(start).Round(time.Millisecond))
+	}
+
+	target := filepath.Join(dest, name)
+	if err := os.Rename(path, target); err != nil {
+		logger.Error("failed to move file", "dir", dest, "error", err)
+	}
+	if err := os.WriteFile(target+".log", logBuf.Bytes(), 0644); err != nil {
+		logger.Error("failed to write sidecar log", "error", err)
+	}
+}
@@ -0,0 +1,149 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/logging"
+)
+
+func testConfig(t *testing.T) Config {
+	t.Helper()
+	config := DefaultConfig(t.TempDir())
+	config.StableFor = 50 * time.Millisecond
+	config.Interval = 10 * time.Millisecond
+	config.Poll = true
+	config.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return config
+}
+
+// waitFor polls until path exists or the timeout expires
+func waitFor(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", path)
+}
+
+func TestWatcher(t *testing.T) {
+	config := testConfig(t)
+
+	handler := func(ctx context.Context, input, outDir string) error {
+		name := filepath.Base(input)
+		logging.From(ctx, nil).Info("handled")
+		if strings.HasPrefix(name, "bad") {
+			return errors.New("whisper failed")
+		}
+		return os.WriteFile(filepath.Join(outDir, strings.TrimSuffix(name, filepath.Ext(name))+".srt"), []byte("subs"), 0644)
+	}
+
+	w, err := New(config, handler)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// A file dropped before the watcher starts is picked up by the initial scan
+	if err := os.WriteFile(filepath.Join(config.Dir, "early.mp3"), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	for _, name := range []string{"talk.mp4", "bad.wav", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(config.Dir, name), []byte("media"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, filepath.Join(config.DoneDir, "early.mp3.log"))
+	waitFor(t, filepath.Join(config.DoneDir, "talk.mp4.log"))
+	waitFor(t, filepath.Join(config.FailedDir, "bad.wav.log"))
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	for _, path := range []string{
+		filepath.Join(config.DoneDir, "talk.mp4"),
+		filepath.Join(config.DoneDir, "talk.srt"),
+		filepath.Join(config.FailedDir, "bad.wav"),
+		filepath.Join(config.Dir, "notes.txt"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s: %v", path, err)
+		}
+	}
+
+	logData, err := os.ReadFile(filepath.Join(config.FailedDir, "bad.wav.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logData), "msg=handled file=bad.wav") || !strings.Contains(string(logData), "whisper failed") {
+		t.Errorf("sidecar log = %q", logData)
+	}
+}
+
+func TestStable(t *testing.T) {
+	config := testConfig(t)
+	w, err := New(config, func(context.Context, string, string) error { return nil })
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	path := filepath.Join(config.Dir, "growing.mp4")
+	if err := os.WriteFile(path, []byte("part"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.observe(path)
+
+	now := time.Now()
+	if ready := w.stable(now); len(ready) != 0 {
+		t.Fatalf("stable() = %v on first sight", ready)
+	}
+
+	// The file keeps growing, so it is not ready yet
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("more data")
+	f.Close()
+	if ready := w.stable(now.Add(time.Second)); len(ready) != 0 {
+		t.Fatalf("stable() = %v while file is growing", ready)
+	}
+
+	if ready := w.stable(now.Add(2 * time.Second)); len(ready) != 1 || ready[0] != path {
+		t.Fatalf("stable() = %v, want %s", ready, path)
+	}
+
+	// An in-flight file is not observed again
+	w.observe(path)
+	if len(w.pending) != 0 {
+		t.Errorf("in-flight file re-added to pending")
+	}
+}
+
+func TestNewInvalidDir(t *testing.T) {
+	handler := func(context.Context, string, string) error { return nil }
+	if _, err := New(DefaultConfig(filepath.Join(t.TempDir(), "missing")), handler); err == nil {
+		t.Error("New() expected error for missing directory")
+	}
+	if _, err := New(DefaultConfig(t.TempDir()), nil); err == nil {
+		t.Error("New() expected error for nil handler")
+	}
+}
@@ -0,0 +1,337 @@
+package whisper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gleicon/transcoder/pkg/logging"
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/proc"
+)
+
+// AutoModel is the Config.Model value that asks the processor to pick a model
+const AutoModel = "auto"
+
+// Preference expresses the quality/speed trade-off used by automatic model selection
+type Preference string
+
+const (
+	PreferSpeed    Preference = "speed"
+	PreferBalanced Preference = "balanced"
+	PreferQuality  Preference = "quality"
+)
+
+// ModelInfo describes a ggml Whisper model as published by whisper.cpp
+type ModelInfo struct {
+	Name         string // e.g. "base", "base.en", "large-v3"
+	Tier         int    // 0 = tiny ... 4 = large, higher is slower and more accurate
+	Multilingual bool   // false for the English-only ".en" models
+	DiskSize     int64  // approximate file size in bytes
+	Memory       int64  // approximate memory required to run the model in bytes
+	Path         string // location on disk, empty if the model is not installed
+}
+
+// FileName returns the ggml file name of the model
+func (m ModelInfo) FileName() string {
+	return "ggml-" + m.Name + ".bin"
+}
+
+const (
+	mib = int64(1) << 20
+	gib = int64(1) << 30
+)
+
+// knownModels lists the models published by whisper.cpp, ordered by tier
+var knownModels = []ModelInfo{
+	{Name: "tiny", Tier: 0, Multilingual: true, DiskSize: 75 * mib, Memory: 273 * mib},
+	{Name: "tiny.en", Tier: 0, Multilingual: false, DiskSize: 75 * mib, Memory: 273 * mib},
+	{Name: "base", Tier: 1, Multilingual: true, DiskSize: 142 * mib, Memory: 388 * mib},
+	{Name: "base.en", Tier: 1, Multilingual: false, DiskSize: 142 * mib, Memory: 388 * mib},
+	{Name: "small", Tier: 2, Multilingual: true, DiskSize: 466 * mib, Memory: 852 * mib},
+	{Name: "small.en", Tier: 2, Multilingual: false, DiskSize: 466 * mib, Memory: 852 * mib},
+	{Name: "medium", Tier: 3, Multilingual: true, DiskSize: 1500 * mib, Memory: 2100 * mib},
+	{Name: "medium.en", Tier: 3, Multilingual: false, DiskSize: 1500 * mib, Memory: 2100 * mib},
+	{Name: "large-v3-turbo", Tier: 3, Multilingual: true, DiskSize: 1600 * mib, Memory: 2300 * mib},
+	{Name: "large-v1", Tier: 4, Multilingual: true, DiskSize: 2900 * mib, Memory: 3900 * mib},
+	{Name: "large-v2", Tier: 4, Multilingual: true, DiskSize: 2900 * mib, Memory: 3900 * mib},
+	{Name: "large-v3", Tier: 4, Multilingual: true, DiskSize: 2900 * mib, Memory: 3900 * mib},
+}
+
+// KnownModels returns the metadata of every model known to the processor
+func KnownModels() []ModelInfo {
+	models := make([]ModelInfo, len(knownModels))
+	copy(models, knownModels)
+	return models
+}
+
+// LookupModel returns the metadata for a model name or ggml file name
+func LookupModel(name string) (ModelInfo, bool) {
+	name = strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), "ggml-"), ".bin")
+	// Quantized variants (e.g. ggml-base-q5_1.bin) share the metadata of their base model
+	if i := strings.Index(name, "-q"); i > 0 {
+		name = name[:i]
+	}
+	for _, m := range knownModels {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return ModelInfo{}, false
+}
+
+// AvailableModels returns the known models installed in dir, ordered by tier
+func AvailableModels(dir string) ([]ModelInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model directory %s: %v", dir, err)
+	}
+
+	var models []ModelInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "ggml-") || filepath.Ext(entry.Name()) != ".bin" {
+			continue
+		}
+		m, ok := LookupModel(entry.Name())
+		if !ok {
+			continue
+		}
+		m.Path = filepath.Join(dir, entry.Name())
+		models = append(models, m)
+	}
+
+	sort.SliceStable(models, func(i, j int) bool { return models[i].Tier < models[j].Tier })
+	return models, nil
+}
+
+// Budget describes the hardware available to run a model
+type Budget struct {
+	Memory  int64 // available memory in bytes, 0 if unknown
+	Threads int   // CPU threads whisper may use
+}
+
+// SystemBudget returns the memory and CPU threads available on this machine
+func SystemBudget() Budget {
+	return Budget{
+		Memory:  availableMemory(),
+		Threads: runtime.NumCPU(),
+	}
+}
+
+// availableMemory returns the available memory in bytes, or 0 if it cannot be determined
+func availableMemory() int64 {
+	if f, err := os.Open("/proc/meminfo"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+				kb, err := strconv.ParseInt(fields[1], 10, 64)
+				if err == nil {
+					return kb * 1024
+				}
+			}
+		}
+		return 0
+	}
+
+	// macOS has no /proc, fall back to the total physical memory
+	out, err := exec.Command("sysctl", "-n", "hw.memsize").Output()
+	if err != nil {
+		return 0
+	}
+	total, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return total
+}
+
+// maxTier returns the largest model tier worth running for a preference and thread count
+func maxTier(pref Preference, threads int) int {
+	switch pref {
+	case PreferSpeed:
+		if threads >= 8 {
+			return 1
+		}
+		return 0
+	case PreferQuality:
+		return 4
+	default:
+		switch {
+		case threads >= 8:
+			return 3
+		case threads >= 4:
+			return 2
+		default:
+			return 1
+		}
+	}
+}
+
+// SelectModel picks the most accurate model among available that suits the
+// source language, preference and hardware budget. English-only models are
+// only considered when lang is "en"; an unknown language is treated as
+// non-English.
+func SelectModel(available []ModelInfo, lang string, pref Preference, budget Budget) (ModelInfo, error) {
+	if len(available) == 0 {
+		return ModelInfo{}, fmt.Errorf("no whisper models available")
+	}
+
+	english := lang == "en"
+	var candidates []ModelInfo
+	for _, m := range available {
+		if !m.Multilingual && !english {
+			continue
+		}
+		candidates = append(candidates, m)
+	}
+	if len(candidates) == 0 {
+		return ModelInfo{}, fmt.Errorf("no multilingual whisper model available for language %q, only English-only models are installed", lang)
+	}
+
+	var fitting []ModelInfo
+	for _, m := range candidates {
+		if budget.Memory > 0 && m.Memory > budget.Memory {
+			continue
+		}
+		fitting = append(fitting, m)
+	}
+	if len(fitting) == 0 {
+		return ModelInfo{}, fmt.Errorf("no whisper model fits in the available memory (%d MiB)", budget.Memory/mib)
+	}
+
+	limit := maxTier(pref, budget.Threads)
+	best := -1
+	for i, m := range fitting {
+		if m.Tier > limit {
+			continue
+		}
+		if best < 0 || better(m, fitting[best], english) {
+			best = i
+		}
+	}
+	if best < 0 {
+		// Nothing is small enough for the preference, fall back to the smallest fitting model
+		best = 0
+		for i, m := range fitting {
+			if m.Tier < fitting[best].Tier {
+				best = i
+			}
+		}
+	}
+
+	return fitting[best], nil
+}
+
+// better reports whether a should be preferred over b
+func better(a, b ModelInfo, english bool) bool {
+	if a.Tier != b.Tier {
+		return a.Tier > b.Tier
+	}
+	// At the same size the English-only models are more accurate on English audio
+	if english && a.Multilingual != b.Multilingual {
+		return !a.Multilingual
+	}
+	if a.Memory != b.Memory {
+		return a.Memory < b.Memory
+	}
+	// Prefer the newest release of a model, e.g. large-v3 over large-v2
+	return a.Name > b.Name
+}
+
+var detectedLanguageRe = regexp.MustCompile(`auto-detected language:\s*([a-z]{2,3})`)
+
+// parseDetectedLanguage extracts the language code from whisper-cli output
+func parseDetectedLanguage(output []byte) (string, error) {
+	m := detectedLanguageRe.FindSubmatch(output)
+	if m == nil {
+		return "", fmt.Errorf("language not found in whisper-cli output")
+	}
+	return string(m[1]), nil
+}
+
+// DetectLanguage runs whisper-cli language detection on a 16kHz WAV file
+// using the given multilingual model and returns the detected language code
+func DetectLanguage(ctx context.Context, model ModelInfo, input string) (string, error) {
+	if !model.Multilingual {
+		return "", fmt.Errorf("language detection requires a multilingual model, got %s", model.Name)
+	}
+
+	var out bytes.Buffer
+	cmd := proc.Command(ctx, "whisper-cli", "-m", model.Path, "-dl", "-f", input)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to detect language: %v", err)
+	}
+
+	return parseDetectedLanguage(out.Bytes())
+}
+
+// Resolve returns a processor bound to a concrete model. If the configuration
+// asks for automatic selection, the source language is detected from input
+// (unless configured) and a model is chosen from Config.ModelDir; otherwise
+// the processor itself is returned.
+func (w *Whisper) Resolve(ctx context.Context, input string) (*Whisper, error) {
+	if w.config.Model != AutoModel {
+		return w, nil
+	}
+
+	available, err := AvailableModels(w.config.ModelDir)
+	if err != nil {
+		return nil, err
+	}
+
+	lang := w.config.Language
+	if lang == "" || lang == "auto" {
+		var detector *ModelInfo
+		for i := range available {
+			if available[i].Multilingual {
+				detector = &available[i]
+				break
+			}
+		}
+		if detector == nil {
+			return nil, fmt.Errorf("cannot detect the source language: no multilingual model in %s", w.config.ModelDir)
+		}
+		err = w.Policy.Run(ctx, policy.Transcribe, 0, func(ctx context.Context) error {
+			lang, err = DetectLanguage(ctx, *detector, input)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	budget := SystemBudget()
+	if w.config.Threads > 0 && w.config.Threads < budget.Threads {
+		budget.Threads = w.config.Threads
+	}
+
+	model, err := SelectModel(available, lang, w.config.Preference, budget)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.From(ctx, w.Logger).Info("selected whisper model", "model", model.Name, "language", lang)
+	config := w.config
+	config.Model = model.Name
+	config.ModelPath = model.Path
+	config.Language = lang
+	return &Whisper{config: config, Cmd: w.Cmd, Policy: w.Policy, Logger: w.Logger}, nil
+}
+
+// Config returns the processor configuration
+func (w *Whisper) Config() Config {
+	return w.config
+}
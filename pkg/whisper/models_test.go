@@ -0,0 +1,233 @@
+package whisper
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func installModels(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		path := filepath.Join(dir, "ggml-"+name+".bin")
+		if err := os.WriteFile(path, []byte("mock model data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLookupModel(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		want   string
+		wantOK bool
+	}{
+		{name: "plain name", input: "base", want: "base", wantOK: true},
+		{name: "file name", input: "ggml-base.en.bin", want: "base.en", wantOK: true},
+		{name: "path", input: "/models/ggml-small.bin", want: "small", wantOK: true},
+		{name: "quantized", input: "ggml-medium-q5_0.bin", want: "medium", wantOK: true},
+		{name: "unknown", input: "ggml-custom.bin", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := LookupModel(tt.input)
+			if ok != tt.wantOK {
+				t.Fatalf("LookupModel() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && m.Name != tt.want {
+				t.Errorf("LookupModel() = %s, want %s", m.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestAvailableModels(t *testing.T) {
+	dir := installModels(t, "small", "base.en", "tiny")
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	models, err := AvailableModels(dir)
+	if err != nil {
+		t.Fatalf("AvailableModels() error = %v", err)
+	}
+	if len(models) != 3 {
+		t.Fatalf("AvailableModels() returned %d models, want 3", len(models))
+	}
+	if models[0].Name != "tiny" || models[2].Name != "small" {
+		t.Errorf("AvailableModels() not ordered by tier: %v", models)
+	}
+	if models[1].Path != filepath.Join(dir, "ggml-base.en.bin") {
+		t.Errorf("AvailableModels() path = %s", models[1].Path)
+	}
+
+	if _, err := AvailableModels(filepath.Join(dir, "missing")); err == nil {
+		t.Error("AvailableModels() expected error for missing directory")
+	}
+}
+
+func TestSelectModel(t *testing.T) {
+	all := KnownModels()
+	only := func(names ...string) []ModelInfo {
+		var models []ModelInfo
+		for _, name := range names {
+			m, _ := LookupModel(name)
+			models = append(models, m)
+		}
+		return models
+	}
+
+	tests := []struct {
+		name      string
+		available []ModelInfo
+		lang      string
+		pref      Preference
+		budget    Budget
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "never english-only for portuguese",
+			available: only("base.en", "base"),
+			lang:      "pt",
+			pref:      PreferBalanced,
+			budget:    Budget{Memory: 16 * gib, Threads: 4},
+			want:      "base",
+		},
+		{
+			name:      "only english-only models for portuguese",
+			available: only("base.en", "small.en"),
+			lang:      "pt",
+			pref:      PreferBalanced,
+			budget:    Budget{Memory: 16 * gib, Threads: 4},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown language treated as non-english",
+			available: only("base.en", "tiny"),
+			lang:      "",
+			pref:      PreferQuality,
+			budget:    Budget{Memory: 16 * gib, Threads: 8},
+			want:      "tiny",
+		},
+		{
+			name:      "english prefers english-only at same tier",
+			available: only("base", "base.en"),
+			lang:      "en",
+			pref:      PreferBalanced,
+			budget:    Budget{Memory: 16 * gib, Threads: 2},
+			want:      "base.en",
+		},
+		{
+			name:      "quality picks largest that fits",
+			available: all,
+			lang:      "es",
+			pref:      PreferQuality,
+			budget:    Budget{Memory: 16 * gib, Threads: 8},
+			want:      "large-v3",
+		},
+		{
+			name:      "memory limits model size",
+			available: all,
+			lang:      "es",
+			pref:      PreferQuality,
+			budget:    Budget{Memory: 1 * gib, Threads: 8},
+			want:      "small",
+		},
+		{
+			name:      "speed on few threads",
+			available: all,
+			lang:      "fr",
+			pref:      PreferSpeed,
+			budget:    Budget{Memory: 16 * gib, Threads: 2},
+			want:      "tiny",
+		},
+		{
+			name:      "balanced scales with threads",
+			available: all,
+			lang:      "fr",
+			pref:      PreferBalanced,
+			budget:    Budget{Memory: 16 * gib, Threads: 4},
+			want:      "small",
+		},
+		{
+			name:      "falls back to smallest fitting model",
+			available: only("medium", "large-v3"),
+			lang:      "de",
+			pref:      PreferSpeed,
+			budget:    Budget{Memory: 16 * gib, Threads: 2},
+			want:      "medium",
+		},
+		{
+			name:      "nothing fits in memory",
+			available: only("large-v3"),
+			lang:      "de",
+			pref:      PreferQuality,
+			budget:    Budget{Memory: 512 * mib, Threads: 8},
+			wantErr:   true,
+		},
+		{
+			name:    "no models",
+			lang:    "en",
+			pref:    PreferBalanced,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := SelectModel(tt.available, tt.lang, tt.pref, tt.budget)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SelectModel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && m.Name != tt.want {
+				t.Errorf("SelectModel() = %s, want %s", m.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDetectedLanguage(t *testing.T) {
+	output := []byte("whisper_init_from_file: loading model\nwhisper_full_with_state: auto-detected language: pt (p = 0.912345)\n")
+	lang, err := parseDetectedLanguage(output)
+	if err != nil {
+		t.Fatalf("parseDetectedLanguage() error = %v", err)
+	}
+	if lang != "pt" {
+		t.Errorf("parseDetectedLanguage() = %s, want pt", lang)
+	}
+
+	if _, err := parseDetectedLanguage([]byte("no language here")); err == nil {
+		t.Error("parseDetectedLanguage() expected error")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := installModels(t, "base.en", "base", "tiny")
+
+	config := DefaultConfig()
+	config.Model = AutoModel
+	config.ModelDir = dir
+	config.Language = "pt"
+	config.Preference = PreferBalanced
+
+	w := &Whisper{config: config}
+	resolved, err := w.Resolve(context.Background(), "input.wav")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got := resolved.Config(); got.ModelPath != filepath.Join(dir, "ggml-base.bin") || got.Language != "pt" {
+		t.Errorf("Resolve() config = %+v", got)
+	}
+
+	// A concrete model is used as configured
+	fixed := &Whisper{config: DefaultConfig()}
+	if r, err := fixed.Resolve(context.Background(), "input.wav"); err != nil || r != fixed {
+		t.Errorf("Resolve() = %v, %v, want the processor itself", r, err)
+	}
+}
@@ -0,0 +1,70 @@
+package whisper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Segment is a transcribed segment with the confidence of whisper in it
+type Segment struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+	// Probability is the mean probability of the text tokens of the
+	// segment; text whisper made up tends to score low
+	Probability float64
+}
+
+// fullJSON is the part of whisper-cli's -ojf output read by ReadSegments
+type fullJSON struct {
+	Transcription []struct {
+		Offsets struct {
+			From int64 `json:"from"` // milliseconds
+			To   int64 `json:"to"`
+		} `json:"offsets"`
+		Text   string `json:"text"`
+		Tokens []struct {
+			Text string  `json:"text"`
+			P    float64 `json:"p"`
+		} `json:"tokens"`
+	} `json:"transcription"`
+}
+
+// ReadSegments reads the segments of a transcript written to a .json output
+func ReadSegments(path string) ([]Segment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transcript: %w", err)
+	}
+	var full fullJSON
+	if err := json.Unmarshal(data, &full); err != nil {
+		return nil, fmt.Errorf("failed to parse transcript %s: %v", path, err)
+	}
+
+	segments := make([]Segment, 0, len(full.Transcription))
+	for _, t := range full.Transcription {
+		s := Segment{
+			Start: time.Duration(t.Offsets.From) * time.Millisecond,
+			End:   time.Duration(t.Offsets.To) * time.Millisecond,
+			Text:  strings.TrimSpace(t.Text),
+		}
+		var sum float64
+		var n int
+		for _, tok := range t.Tokens {
+			// Special tokens such as [_BEG_] and timestamps carry no text
+			if strings.HasPrefix(tok.Text, "[_") {
+				continue
+			}
+			sum += tok.P
+			n++
+		}
+		if n > 0 {
+			s.Probability = sum / float64(n)
+		}
+		segments = append(segments, s)
+	}
+	return segments, nil
+}
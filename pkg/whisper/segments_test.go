@@ -0,0 +1,62 @@
+package whisper
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const sampleFullJSON = `{
+	"systeminfo": "AVX = 1",
+	"result": {"language": "en"},
+	"transcription": [
+		{
+			"timestamps": {"from": "00:00:00,000", "to": "00:00:02,500"},
+			"offsets": {"from": 0, "to": 2500},
+			"text": " Hello there.",
+			"tokens": [
+				{"text": "[_BEG_]", "p": 0.1},
+				{"text": " Hello", "p": 0.9},
+				{"text": " there", "p": 0.8},
+				{"text": ".", "p": 1.0},
+				{"text": "[_TT_125]", "p": 0.2}
+			]
+		},
+		{
+			"offsets": {"from": 2500, "to": 30000},
+			"text": " Thank you for watching.",
+			"tokens": []
+		}
+	]
+}`
+
+func TestReadSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "talk.json")
+	if err := os.WriteFile(path, []byte(sampleFullJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := ReadSegments(path)
+	if err != nil {
+		t.Fatalf("ReadSegments() error = %v", err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("ReadSegments() = %d segments, want 2", len(segments))
+	}
+	s := segments[0]
+	if s.Start != 0 || s.End != 2500*time.Millisecond || s.Text != "Hello there." {
+		t.Errorf("first segment = %+v", s)
+	}
+	if math.Abs(s.Probability-0.9) > 1e-9 {
+		t.Errorf("first segment probability = %v, want 0.9 without special tokens", s.Probability)
+	}
+	if segments[1].Probability != 0 {
+		t.Errorf("segment without tokens has probability %v, want 0", segments[1].Probability)
+	}
+
+	if _, err := ReadSegments(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("ReadSegments() of a missing file succeeded")
+	}
+}
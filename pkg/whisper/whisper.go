@@ -3,18 +3,29 @@ package whisper
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/gleicon/transcoder/pkg/atomicfile"
+	"github.com/gleicon/transcoder/pkg/logging"
+	"github.com/gleicon/transcoder/pkg/policy"
+	"github.com/gleicon/transcoder/pkg/proc"
 )
 
 // Config holds the configuration for the Whisper processor
 type Config struct {
-	ModelPath string
-	Device    string // "cpu", "cuda", "metal"
-	Threads   int
-	Language  string
+	ModelPath  string
+	Model      string     // model name, or "auto" to select one from ModelDir
+	ModelDir   string     // directory holding ggml-*.bin models for automatic selection
+	Preference Preference // quality/speed trade-off for automatic selection
+	Device     string     // "cpu", "cuda", "metal"
+	Threads    int
+	Language   string
 }
 
 // DefaultConfig returns a default configuration
@@ -29,17 +40,21 @@ func DefaultConfig() Config {
 	defaultModel := filepath.Join(homeDir, ".cache", "whisper", "base.bin")
 
 	return Config{
-		ModelPath: defaultModel,
-		Device:    "cpu",
-		Threads:   4,
-		Language:  "auto",
+		ModelPath:  defaultModel,
+		ModelDir:   filepath.Dir(defaultModel),
+		Preference: PreferBalanced,
+		Device:     "cpu",
+		Threads:    4,
+		Language:   "auto",
 	}
 }
 
 // Whisper represents a Whisper processor
 type Whisper struct {
 	config Config
-	Cmd    *exec.Cmd // For testing purposes
+	Cmd    *exec.Cmd      // For testing purposes
+	Policy *policy.Policy // timeout and retries of whisper-cli runs, nil for none
+	Logger *slog.Logger   // receives runs and, at debug level, whisper-cli output; defaults to slog.Default()
 }
 
 // New creates a new Whisper processor with the given configuration
@@ -49,13 +64,13 @@ func New(config Config) (*Whisper, error) {
 		return nil, fmt.Errorf("whisper-cli command not found. Please install it using: brew install whisper-cpp")
 	}
 
-	// Validate model path
-	if _, err := os.Stat(config.ModelPath); err != nil {
-		return nil, fmt.Errorf("model file not found at %s: %v", config.ModelPath, err)
+	if err := validateModel(config); err != nil {
+		return nil, err
 	}
 
 	return &Whisper{
 		config: config,
+		Policy: policy.Default(),
 	}, nil
 }
 
@@ -65,9 +80,8 @@ func NewWithCmd(cmd *exec.Cmd, config Config) (*Whisper, error) {
 		return nil, fmt.Errorf("command is required")
 	}
 
-	// Validate model path
-	if _, err := os.Stat(config.ModelPath); err != nil {
-		return nil, fmt.Errorf("model file not found at %s: %v", config.ModelPath, err)
+	if err := validateModel(config); err != nil {
+		return nil, err
 	}
 
 	return &Whisper{
@@ -76,10 +90,27 @@ func NewWithCmd(cmd *exec.Cmd, config Config) (*Whisper, error) {
 	}, nil
 }
 
+// validateModel checks that the configured model, or the model directory for
+// automatic selection, exists
+func validateModel(config Config) error {
+	if config.Model == AutoModel {
+		if _, err := os.Stat(config.ModelDir); err != nil {
+			return fmt.Errorf("model directory not found at %s: %v", config.ModelDir, err)
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(config.ModelPath); err != nil {
+		return fmt.Errorf("model file not found at %s: %v", config.ModelPath, err)
+	}
+	return nil
+}
+
 // Close releases the Whisper resources (no-op for command-line wrapper)
 func (w *Whisper) Close() {}
 
-// Transcribe transcribes an audio file to SRT format
+// Transcribe transcribes an audio file to SRT format, or to whisper-cli's
+// full JSON for an output ending in .json
 func (w *Whisper) Transcribe(ctx context.Context, input, output string) error {
 	// Validate input file
 	if _, err := os.Stat(input); err != nil {
@@ -94,40 +125,16 @@ func (w *Whisper) Transcribe(ctx context.Context, input, output string) error {
 		return fmt.Errorf("failed to create output directory: %v", err)
 	}
 
-	// Build whisper-cli command
-	args := []string{
-		"-m", w.config.ModelPath,
-		"-osrt",
-		"-of", strings.TrimSuffix(output, ".srt"),
-	}
-
-	if w.config.Language != "" && w.config.Language != "auto" {
-		args = append(args, "-l", w.config.Language)
-	}
-
-	if w.config.Threads > 0 {
-		args = append(args, "-t", fmt.Sprintf("%d", w.config.Threads))
-	}
-
-	args = append(args, "-f", input)
-
-	// Create command with context
-	if w.Cmd == nil {
-		w.Cmd = exec.CommandContext(ctx, "whisper-cli", args...)
-		w.Cmd.Stdout = os.Stdout
-		w.Cmd.Stderr = os.Stderr
-	}
-
-	// Run the command
-	if err := w.Cmd.Run(); err != nil {
-		return fmt.Errorf("failed to transcribe audio: %v", err)
+	if err := w.run(ctx, input, output, w.transcribeArgs(input)); err != nil {
+		return fmt.Errorf("failed to transcribe audio: %w", err)
 	}
 
 	return nil
 }
 
-// TranscribeWithTranslation transcribes and translates an audio file
-func (w *Whisper) TranscribeWithTranslation(ctx context.Context, input, output, targetLang string) error {
+// TranscribeWords transcribes an audio file to SRT with a cue per word, whose
+// timings are used to align existing subtitles to the speech
+func (w *Whisper) TranscribeWords(ctx context.Context, input, output string) error {
 	// Validate input file
 	if _, err := os.Stat(input); err != nil {
 		if os.IsNotExist(err) {
@@ -141,58 +148,114 @@ func (w *Whisper) TranscribeWithTranslation(ctx context.Context, input, output,
 		return fmt.Errorf("failed to create output directory: %v", err)
 	}
 
-	// Validate target language if provided
-	if targetLang != "" && targetLang != "auto" {
-		// List of supported language codes
-		supportedLangs := []string{
-			"en", "zh", "de", "es", "ru", "ko", "fr", "ja", "pt", "tr", "pl", "ca", "nl", "ar", "sv", "it", "id", "hi", "fi", "vi", "he", "uk", "el", "ms", "cs", "ro", "da", "hu", "ta", "no", "th", "ur", "hr", "bg", "lt", "la", "mi", "ml", "cy", "sk", "te", "fa", "lv", "bn", "sr", "az", "sl", "kn", "et", "mk", "br", "eu", "is", "hy", "ne", "mn", "bs", "kk", "sq", "sw", "gl", "mr", "pa", "si", "km", "sn", "yo", "so", "af", "oc", "ka", "be", "tg", "sd", "gu", "am", "yi", "lo", "uz", "fo", "ht", "ps", "tk", "nn", "mt", "sa", "lb", "my", "bo", "tl", "mg", "as", "tt", "haw", "ln", "ha", "ba", "jw", "su",
-		}
-		isValid := false
-		for _, lang := range supportedLangs {
-			if lang == targetLang {
-				isValid = true
-				break
-			}
-		}
-		if !isValid {
-			return fmt.Errorf("unsupported language code: %s", targetLang)
-		}
+	// Segments of at most one character, split on words, are single words
+	args := w.transcribeArgs(input, "-ml", "1", "-sow")
+	if err := w.run(ctx, input, output, args); err != nil {
+		return fmt.Errorf("failed to transcribe words: %w", err)
 	}
 
-	// Build whisper-cli command
+	return nil
+}
+
+// transcribeArgs builds the whisper-cli arguments transcribing input to SRT
+func (w *Whisper) transcribeArgs(input string, extra ...string) []string {
 	args := []string{
 		"-m", w.config.ModelPath,
 		"-osrt",
-		"-tr", // Enable translation
-		"-of", strings.TrimSuffix(output, ".srt"),
 	}
 
-	// Only add language if it's not empty
-	if targetLang != "" {
-		args = append(args, "-l", targetLang)
+	if w.config.Language != "" && w.config.Language != "auto" {
+		args = append(args, "-l", w.config.Language)
 	}
 
 	if w.config.Threads > 0 {
 		args = append(args, "-t", fmt.Sprintf("%d", w.config.Threads))
 	}
 
-	args = append(args, "-f", input)
+	args = append(args, extra...)
+	return append(args, "-f", input)
+}
 
-	// Create command with context
-	if w.Cmd == nil {
-		w.Cmd = exec.CommandContext(ctx, "whisper-cli", args...)
-		w.Cmd.Stdout = os.Stdout
-		w.Cmd.Stderr = os.Stderr
+// TranscribeWithTranslation transcribes an audio file and translates it
+// into English, the only language whisper translates into; targetLang has to
+// be "en" or empty. The -l flag of whisper-cli names the spoken language, so
+// it carries the configured source language, as for Transcribe.
+func (w *Whisper) TranscribeWithTranslation(ctx context.Context, input, output, targetLang string) error {
+	// Validate input file
+	if _, err := os.Stat(input); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("input file not found: %s", input)
+		}
+		return fmt.Errorf("error checking input file: %v", err)
 	}
 
-	// Run the command
-	if err := w.Cmd.Run(); err != nil {
-		return fmt.Errorf("failed to translate audio: %v", err)
+	if targetLang != "" && targetLang != "en" {
+		return fmt.Errorf("whisper only translates into English, not %s", targetLang)
+	}
+
+	// Ensure output directory exists
+	if err := EnsureOutputDir(output); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	if err := w.run(ctx, input, output, w.transcribeArgs(input, "-tr")); err != nil {
+		return fmt.Errorf("failed to translate audio: %w", err)
 	}
 
 	return nil
 }
 
+// run runs whisper-cli with args, writing the SRT to a temporary file that is
+// renamed to output once whisper-cli succeeds. An output ending in .json
+// receives whisper-cli's full JSON instead, which ReadSegments reads. Attempts
+// are limited and retried by the policy, scaled by the duration of the input
+// audio.
+func (w *Whisper) run(ctx context.Context, input, output string, args []string) error {
+	if filepath.Ext(output) == ".json" {
+		args = slices.Clone(args)
+		if i := slices.Index(args, "-osrt"); i >= 0 {
+			args[i] = "-ojf"
+		}
+	}
+	logger := logging.From(ctx, w.Logger).With("tool", "whisper-cli", "model", filepath.Base(w.config.ModelPath))
+	return w.Policy.Run(ctx, policy.Transcribe, WAVDuration(input), func(ctx context.Context) error {
+		return atomicfile.Write(output, func(tmp string) error {
+			// A new command per call so the processor can be reused
+			cmd := w.Cmd
+			if cmd == nil {
+				args := append(args[:len(args):len(args)], "-of", strings.TrimSuffix(tmp, filepath.Ext(tmp)))
+				cmd = proc.Command(ctx, "whisper-cli", args...)
+				out := logging.NewWriter(ctx, logger, slog.LevelDebug, "whisper-cli output")
+				defer out.Close()
+				cmd.Stdout = out
+				cmd.Stderr = out
+			}
+
+			logger.Debug("running whisper-cli", "cmd", cmd.String())
+			start := time.Now()
+			err := proc.Run(cmd)
+			if err != nil {
+				logger.Warn("whisper-cli failed", "cmd", cmd.String(), "duration", time.Since(start), "error", err)
+				return err
+			}
+			logger.Debug("whisper-cli finished", "output", output, "duration", time.Since(start))
+			return nil
+		})
+	})
+}
+
+// wavBytesPerSecond is the data rate of the 16kHz mono 16-bit WAV whisper reads
+const wavBytesPerSecond = 16000 * 2
+
+// WAVDuration estimates the duration of a WAV file from its size, 0 if unknown
+func WAVDuration(path string) time.Duration {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() <= 44 {
+		return 0
+	}
+	return time.Duration(info.Size()-44) * time.Second / wavBytesPerSecond
+}
+
 // EnsureOutputDir ensures the output directory exists
 func EnsureOutputDir(output string) error {
 	dir := filepath.Dir(output)
@@ -192,6 +192,42 @@ func TestTranscribeWithTranslation(t *testing.T) {
 	}
 }
 
+func TestTranscribeWithTranslationArgs(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "talk.wav")
+	if err := os.WriteFile(input, []byte("wav"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A fake whisper-cli that records its arguments and writes an empty SRT
+	// where -of points
+	argsFile := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\n" +
+		"while [ $# -gt 0 ]; do [ \"$1\" = -of ] && out=$2; shift; done\n: > \"$out.srt\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "whisper-cli"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for _, lang := range []string{"es", "auto"} {
+		config := DefaultConfig()
+		config.Language = lang
+		w := &Whisper{config: config}
+		if err := w.TranscribeWithTranslation(context.Background(), input, filepath.Join(dir, "talk.en.srt"), "en"); err != nil {
+			t.Fatalf("TranscribeWithTranslation() error = %v", err)
+		}
+		data, err := os.ReadFile(argsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// -l is the spoken language, left to detection for auto
+		args := " " + strings.TrimSpace(string(data)) + " "
+		wantLang := lang != "auto"
+		if !strings.Contains(args, " -tr ") || strings.Contains(args, " -l "+lang+" ") != wantLang || strings.Contains(args, " -l ") != wantLang {
+			t.Errorf("source %s: whisper-cli args = %q", lang, args)
+		}
+	}
+}
+
 func TestEnsureOutputDir(t *testing.T) {
 	// Test with current directory
 	err := EnsureOutputDir("file.txt")
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package workspace
+
+// FreeSpace returns -1 where the free space cannot be determined
+func FreeSpace(dir string) (int64, error) {
+	return -1, nil
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package workspace
+
+import "syscall"
+
+// FreeSpace returns the bytes available to unprivileged users on the
+// filesystem holding dir
+func FreeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return -1, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
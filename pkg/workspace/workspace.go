@@ -0,0 +1,127 @@
+// Package workspace provides per-job directories for intermediate files such
+// as extracted audio, with a free-space check before work starts and cleanup
+// once the job is over.
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Config holds the workspace settings shared by every job
+type Config struct {
+	Root          string // where workspaces are created, default os.TempDir()
+	MinFree       int64  // bytes that must stay free on the filesystem besides a job's needs
+	KeepOnFailure bool   // leave the workspace of a failed job in place for debugging
+}
+
+// Workspace is a directory holding the intermediate files of one job
+type Workspace struct {
+	dir  string
+	keep bool
+	kept bool
+}
+
+// Create creates a new workspace with a unique name under the root, after
+// checking that need bytes (plus MinFree) are available
+func Create(config Config, need int64) (*Workspace, error) {
+	root := config.Root
+	if root == "" {
+		root = os.TempDir()
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workspace root: %v", err)
+	}
+	if err := checkSpace(root, need+config.MinFree); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(root, "transcoder-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %v", err)
+	}
+	return &Workspace{dir: dir, keep: config.KeepOnFailure}, nil
+}
+
+// Open opens the workspace with the given name under the root, creating it if
+// needed. Files left by an interrupted job with the same name are kept so the
+// job can resume.
+func Open(config Config, name string, need int64) (*Workspace, error) {
+	root := config.Root
+	if root == "" {
+		root = os.TempDir()
+	}
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %v", err)
+	}
+	if err := checkSpace(dir, need+config.MinFree); err != nil {
+		return nil, err
+	}
+	return &Workspace{dir: dir, keep: config.KeepOnFailure}, nil
+}
+
+// Dir returns the workspace directory
+func (w *Workspace) Dir() string {
+	return w.dir
+}
+
+// Path returns the location of a file in the workspace
+func (w *Workspace) Path(name string) string {
+	return filepath.Join(w.dir, name)
+}
+
+// Close ends the job using the workspace. The directory is removed, unless
+// failure is non-nil and the configuration keeps failed workspaces.
+func (w *Workspace) Close(failure error) error {
+	if failure != nil && w.keep {
+		w.kept = true
+		return nil
+	}
+	return w.Remove()
+}
+
+// Remove deletes the workspace regardless of the outcome of the job
+func (w *Workspace) Remove() error {
+	if err := os.RemoveAll(w.dir); err != nil {
+		return fmt.Errorf("failed to remove workspace: %v", err)
+	}
+	return nil
+}
+
+// Kept reports whether Close left the workspace in place
+func (w *Workspace) Kept() bool {
+	return w.kept
+}
+
+// bytesPerSecond is the size of the 16kHz mono 16-bit WAV whisper reads
+const bytesPerSecond = 16000 * 2
+
+// MediaNeed estimates the space needed to process a media file of the given
+// size: the extracted audio plus the given number of re-encoded copies of
+// the media. When the duration is unknown the audio is assumed to be as
+// large as the input.
+func MediaNeed(size int64, duration time.Duration, copies int) int64 {
+	audio := size
+	if duration > 0 {
+		audio = int64(duration.Seconds() * bytesPerSecond)
+	}
+	return audio + size*int64(copies)
+}
+
+// checkSpace fails if fewer than need bytes are free on the filesystem of dir
+func checkSpace(dir string, need int64) error {
+	if need <= 0 {
+		return nil
+	}
+	free, err := FreeSpace(dir)
+	if err != nil || free < 0 {
+		// Unknown free space is not a reason to refuse work
+		return nil
+	}
+	if free < need {
+		return fmt.Errorf("not enough disk space in %s: %d MiB free, %d MiB needed", dir, free>>20, (need+(1<<20)-1)>>20)
+	}
+	return nil
+}
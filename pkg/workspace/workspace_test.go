@@ -0,0 +1,95 @@
+package workspace
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	root := t.TempDir()
+
+	w, err := Create(Config{Root: root}, 0)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if filepath.Dir(w.Dir()) != root {
+		t.Errorf("Dir() = %s, want a directory under %s", w.Dir(), root)
+	}
+	if err := os.WriteFile(w.Path("audio.wav"), []byte("wav"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(errors.New("failed")); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(w.Dir()); !os.IsNotExist(err) {
+		t.Error("failed workspace was kept without KeepOnFailure")
+	}
+
+	w, err = Create(Config{Root: root, KeepOnFailure: true}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close(errors.New("failed"))
+	if _, err := os.Stat(w.Dir()); err != nil || !w.Kept() {
+		t.Errorf("failed workspace was removed with KeepOnFailure: %v", err)
+	}
+
+	w, err = Create(Config{Root: root, KeepOnFailure: true}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close(nil)
+	if _, err := os.Stat(w.Dir()); !os.IsNotExist(err) || w.Kept() {
+		t.Error("successful workspace was kept")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	config := Config{Root: t.TempDir()}
+	w, err := Open(config, "job1", 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := os.WriteFile(w.Path("audio.wav"), []byte("wav"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening after an interruption finds the earlier files
+	again, err := Open(config, "job1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(again.Path("audio.wav")); err != nil {
+		t.Errorf("reopened workspace lost its files: %v", err)
+	}
+}
+
+func TestSpace(t *testing.T) {
+	root := t.TempDir()
+	free, err := FreeSpace(root)
+	if err != nil || free < 0 {
+		t.Skip("free space is unknown on this platform")
+	}
+
+	_, err = Create(Config{Root: root, MinFree: free}, 1<<40)
+	if err == nil || !strings.Contains(err.Error(), "not enough disk space") {
+		t.Errorf("Create() error = %v, want a disk space error", err)
+	}
+	entries, _ := os.ReadDir(root)
+	if len(entries) != 0 {
+		t.Error("Create() left a directory behind after failing")
+	}
+}
+
+func TestMediaNeed(t *testing.T) {
+	if got := MediaNeed(1000, 0, 2); got != 3000 {
+		t.Errorf("MediaNeed() without a duration = %d, want 3000", got)
+	}
+	if got := MediaNeed(1000, 10*time.Second, 1); got != 10*bytesPerSecond+1000 {
+		t.Errorf("MediaNeed() = %d", got)
+	}
+}